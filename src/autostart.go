@@ -1,15 +1,18 @@
 package main
 
 import (
+	"encoding/xml"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"strings"
 )
 
-// ensureAutoStart configures the program to run at user login for the current OS.
-func ensureAutoStart() {
+// ensureAutoStart configures the program to run at user login for the current
+// OS, invoked with args.
+func ensureAutoStart(args ...string) {
 	exePath, err := os.Executable()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "auto-start: executable: %v\n", err)
@@ -27,7 +30,7 @@ func ensureAutoStart() {
 			`HKCU\Software\Microsoft\Windows\CurrentVersion\Run`,
 			"/v", "backup",
 			"/t", "REG_SZ",
-			"/d", exePath,
+			"/d", commandLine(exePath, args),
 			"/f",
 		)
 		_ = cmd.Run()
@@ -48,11 +51,11 @@ func ensureAutoStart() {
 <dict>
     <key>Label</key><string>com.example.backup</string>
     <key>ProgramArguments</key>
-    <array><string>%s</string></array>
+    <array>%s</array>
     <key>RunAtLoad</key><true/>
 </dict>
 </plist>
-`, exePath)
+`, plistStrings(append([]string{exePath}, args...)))
 		plistPath := filepath.Join(dir, "com.example.backup.plist")
 		_ = os.WriteFile(plistPath, []byte(plist), 0644)
 	default:
@@ -74,8 +77,66 @@ NoDisplay=false
 X-GNOME-Autostart-enabled=true
 Name=backup
 Comment=Backup program
-`, exePath)
+`, commandLine(exePath, args))
 		desktopPath := filepath.Join(dir, "backup.desktop")
 		_ = os.WriteFile(desktopPath, []byte(desktop), 0644)
 	}
 }
+
+// commandLine joins exePath and args, quoting exePath when it contains spaces.
+func commandLine(exePath string, args []string) string {
+	if len(args) == 0 {
+		return exePath
+	}
+	if strings.Contains(exePath, " ") {
+		exePath = `"` + exePath + `"`
+	}
+	return exePath + " " + strings.Join(args, " ")
+}
+
+// plistStrings renders values as plist <string> elements.
+func plistStrings(values []string) string {
+	var b strings.Builder
+	for _, v := range values {
+		b.WriteString("<string>")
+		xml.EscapeText(&b, []byte(v))
+		b.WriteString("</string>")
+	}
+	return b.String()
+}
+
+// removeAutoStart deletes the login entry created by ensureAutoStart.
+func removeAutoStart() error {
+	switch runtime.GOOS {
+	case "windows":
+		cmd := exec.Command("reg", "delete",
+			`HKCU\Software\Microsoft\Windows\CurrentVersion\Run`,
+			"/v", "backup",
+			"/f",
+		)
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("auto-start: reg delete: %w", err)
+		}
+		return nil
+	case "darwin":
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return fmt.Errorf("auto-start: home: %w", err)
+		}
+		return removeIfExists(filepath.Join(home, "Library", "LaunchAgents", "com.example.backup.plist"))
+	default:
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return fmt.Errorf("auto-start: home: %w", err)
+		}
+		return removeIfExists(filepath.Join(home, ".config", "autostart", "backup.desktop"))
+	}
+}
+
+// removeIfExists removes path and ignores a missing file.
+func removeIfExists(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("auto-start: remove: %w", err)
+	}
+	return nil
+}
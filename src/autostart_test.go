@@ -14,7 +14,7 @@ func TestEnsureAutoStartLinux(t *testing.T) {
 	}
 	home := t.TempDir()
 	t.Setenv("HOME", home)
-	ensureAutoStart()
+	ensureAutoStart("backup", "-yes")
 	desktop := filepath.Join(home, ".config", "autostart", "backup.desktop")
 	data, err := os.ReadFile(desktop)
 	if err != nil {
@@ -24,7 +24,49 @@ func TestEnsureAutoStartLinux(t *testing.T) {
 	if err != nil {
 		t.Fatalf("executable: %v", err)
 	}
-	if !strings.Contains(string(data), exe) {
-		t.Fatalf("desktop missing executable: %s", data)
+	if !strings.Contains(string(data), exe+" backup -yes") {
+		t.Fatalf("desktop missing unattended backup command: %s", data)
+	}
+}
+
+func TestRemoveAutoStartLinux(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("linux only")
+	}
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	ensureAutoStart("backup", "-yes")
+	if err := removeAutoStart(); err != nil {
+		t.Fatalf("removeAutoStart: %v", err)
+	}
+	desktop := filepath.Join(home, ".config", "autostart", "backup.desktop")
+	if _, err := os.Stat(desktop); !os.IsNotExist(err) {
+		t.Fatalf("desktop file still present: %v", err)
+	}
+	if err := removeAutoStart(); err != nil {
+		t.Fatalf("second removeAutoStart: %v", err)
+	}
+}
+
+func TestEnsureAutoStartDaemonLinux(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("linux only")
+	}
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	ensureAutoStart("daemon")
+	data, err := os.ReadFile(filepath.Join(home, ".config", "autostart", "backup.desktop"))
+	if err != nil {
+		t.Fatalf("read desktop: %v", err)
+	}
+	exe, _ := os.Executable()
+	if !strings.Contains(string(data), "Exec="+commandLine(exe, []string{"daemon"})+"\n") || !strings.HasSuffix(commandLine(exe, []string{"daemon"}), " daemon") {
+		t.Fatalf("desktop does not start the daemon: %s", data)
+	}
+}
+
+func TestPlistStrings(t *testing.T) {
+	if got := plistStrings([]string{"/Apps/a&b", "daemon"}); got != "<string>/Apps/a&amp;b</string><string>daemon</string>" {
+		t.Fatalf("unexpected plist strings: %q", got)
 	}
 }
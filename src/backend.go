@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// remoteBackends lists the restic repository URL schemes of remote backends.
+var remoteBackends = []string{"sftp", "rest", "s3", "b2", "azure", "gs", "swift", "rclone"}
+
+// repoBackend returns the backend of a repository location, "local" for a
+// path on disk.
+func repoBackend(repo string) string {
+	if i := strings.Index(repo, ":"); i > 0 {
+		for _, b := range remoteBackends {
+			if repo[:i] == b {
+				return b
+			}
+		}
+	}
+	return "local"
+}
+
+// backend holds the credentials of remote repository backends. restic reads
+// them from the environment variables documented for each backend.
+type backend struct {
+	AWSAccessKeyID     string `json:"aws-access-key-id,omitempty"`
+	AWSSecretAccessKey string `json:"aws-secret-access-key,omitempty"`
+	AWSDefaultRegion   string `json:"aws-default-region,omitempty"`
+	RESTUsername       string `json:"rest-username,omitempty"`
+	RESTPassword       string `json:"rest-password,omitempty"`
+	B2AccountID        string `json:"b2-account-id,omitempty"`
+	B2AccountKey       string `json:"b2-account-key,omitempty"`
+	AzureAccountName   string `json:"azure-account-name,omitempty"`
+	AzureAccountKey    string `json:"azure-account-key,omitempty"`
+	// Env sets further environment variables of the backends, for example for
+	// rclone or swift. Only names accepted by allowedBackendEnv are passed to
+	// restic. Values of keys that look secret are masked like other secrets.
+	Env map[string]string `json:"env,omitempty"`
+}
+
+// backendEnvPrefixes lists the prefixes of the environment variables read by
+// restic's backends and rclone.
+var backendEnvPrefixes = []string{"AWS_", "B2_", "AZURE_", "GOOGLE_", "OS_", "ST_", "SWIFT_", "RCLONE_", "RESTIC_REST_"}
+
+// backendEnvDenied lists suffixes of variables that make rclone run another
+// program, such as RCLONE_PASSWORD_COMMAND.
+var backendEnvDenied = []string{"_COMMAND", "_PROGRAM", "_SSH"}
+
+// allowedBackendEnv reports whether name may be set through backend.env.
+// Variables such as PATH, LD_PRELOAD or RESTIC_PASSWORD_COMMAND would let
+// the configuration run programs.
+func allowedBackendEnv(name string) bool {
+	for _, s := range backendEnvDenied {
+		if strings.HasSuffix(name, s) {
+			return false
+		}
+	}
+	for _, p := range backendEnvPrefixes {
+		if strings.HasPrefix(name, p) && len(name) > len(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// validate rejects environment variables that no backend reads.
+func (b backend) validate() error {
+	var bad []string
+	for k := range b.Env {
+		if !allowedBackendEnv(k) {
+			bad = append(bad, k)
+		}
+	}
+	if len(bad) == 0 {
+		return nil
+	}
+	sort.Strings(bad)
+	return fmt.Errorf("backend env may not set %s, only variables starting with %s", strings.Join(bad, ", "), strings.Join(backendEnvPrefixes, ", "))
+}
+
+// backendVar maps a backend field to the environment variable restic reads.
+type backendVar struct {
+	name   string
+	value  string
+	secret bool
+}
+
+// vars returns the configured backend variables.
+func (b backend) vars() []backendVar {
+	vars := []backendVar{
+		{"AWS_ACCESS_KEY_ID", b.AWSAccessKeyID, false},
+		{"AWS_SECRET_ACCESS_KEY", b.AWSSecretAccessKey, true},
+		{"AWS_DEFAULT_REGION", b.AWSDefaultRegion, false},
+		{"RESTIC_REST_USERNAME", b.RESTUsername, false},
+		{"RESTIC_REST_PASSWORD", b.RESTPassword, true},
+		{"B2_ACCOUNT_ID", b.B2AccountID, false},
+		{"B2_ACCOUNT_KEY", b.B2AccountKey, true},
+		{"AZURE_ACCOUNT_NAME", b.AzureAccountName, false},
+		{"AZURE_ACCOUNT_KEY", b.AzureAccountKey, true},
+	}
+	keys := make([]string, 0, len(b.Env))
+	for k := range b.Env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		// validate reports the others
+		if allowedBackendEnv(k) {
+			vars = append(vars, backendVar{k, b.Env[k], isSecretKey(k)})
+		}
+	}
+	var out []backendVar
+	for _, v := range vars {
+		if v.value != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// env returns the backend credentials as environment variables for restic.
+func (b backend) env() []string {
+	var env []string
+	for _, v := range b.vars() {
+		env = append(env, v.name+"="+v.value)
+	}
+	return env
+}
+
+// secrets returns the secret backend credentials.
+func (b backend) secrets() []string {
+	var s []string
+	for _, v := range b.vars() {
+		if v.secret {
+			s = append(s, v.value)
+		}
+	}
+	return s
+}
+
+// isZero reports whether no backend credentials are configured.
+func (b backend) isZero() bool {
+	return len(b.vars()) == 0
+}
+
+// redacted returns a copy of b with all secrets masked.
+func (b backend) redacted() backend {
+	mask := func(s string) string {
+		if s == "" {
+			return ""
+		}
+		return redactedMark
+	}
+	b.AWSSecretAccessKey = mask(b.AWSSecretAccessKey)
+	b.RESTPassword = mask(b.RESTPassword)
+	b.B2AccountKey = mask(b.B2AccountKey)
+	b.AzureAccountKey = mask(b.AzureAccountKey)
+	if b.Env != nil {
+		env := make(map[string]string, len(b.Env))
+		for k, v := range b.Env {
+			if isSecretKey(k) {
+				v = mask(v)
+			}
+			env[k] = v
+		}
+		b.Env = env
+	}
+	return b
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRepoBackend(t *testing.T) {
+	tests := map[string]string{
+		"~/backups":                    "local",
+		`C:\backups`:                   "local",
+		"sftp:user@host:/srv/restic":   "sftp",
+		"rest:https://host:8000/repo":  "rest",
+		"s3:s3.amazonaws.com/bucket":   "s3",
+		"rclone:remote:backups/family": "rclone",
+	}
+	for repo, want := range tests {
+		if got := repoBackend(repo); got != want {
+			t.Errorf("repoBackend(%q) = %q, want %q", repo, got, want)
+		}
+	}
+}
+
+func TestBackendEnvAndSecrets(t *testing.T) {
+	b := backend{
+		AWSAccessKeyID:     "AKIAEXAMPLE",
+		AWSSecretAccessKey: "aws-secret",
+		RESTUsername:       "family",
+		RESTPassword:       "rest-secret",
+		Env:                map[string]string{"RCLONE_CONFIG_TOKEN": "rclone-secret", "RCLONE_VERBOSE": "1"},
+	}
+	env := strings.Join(b.env(), " ")
+	want := "AWS_ACCESS_KEY_ID=AKIAEXAMPLE AWS_SECRET_ACCESS_KEY=aws-secret RESTIC_REST_USERNAME=family RESTIC_REST_PASSWORD=rest-secret RCLONE_CONFIG_TOKEN=rclone-secret RCLONE_VERBOSE=1"
+	if env != want {
+		t.Fatalf("unexpected env:\n%s\nwant\n%s", env, want)
+	}
+	if got := strings.Join(b.secrets(), " "); got != "aws-secret rest-secret rclone-secret" {
+		t.Fatalf("unexpected secrets: %q", got)
+	}
+	r := config{Backend: b}.redacted().Backend
+	if r.RESTPassword != redactedMark || r.AWSSecretAccessKey != redactedMark || r.Env["RCLONE_CONFIG_TOKEN"] != redactedMark || r.RESTUsername != "family" {
+		t.Fatalf("unexpected redaction: %+v", r)
+	}
+	if b.Env["RCLONE_CONFIG_TOKEN"] != "rclone-secret" {
+		t.Fatalf("redaction modified the original")
+	}
+	if !(backend{}).isZero() || b.isZero() {
+		t.Fatalf("unexpected isZero")
+	}
+}
+
+func TestBackendEnvAllowed(t *testing.T) {
+	for name, want := range map[string]bool{
+		"RCLONE_CONFIG_GDRIVE_TOKEN": true,
+		"OS_AUTH_URL":                true,
+		"GOOGLE_PROJECT_ID":          true,
+		"AWS_PROFILE":                true,
+		"PATH":                       false,
+		"LD_PRELOAD":                 false,
+		"RESTIC_PASSWORD_COMMAND":    false,
+		"RCLONE_PASSWORD_COMMAND":    false,
+		"RCLONE_SFTP_SSH":            false,
+		"AWS_":                       false,
+	} {
+		if got := allowedBackendEnv(name); got != want {
+			t.Errorf("allowedBackendEnv(%q) = %v, want %v", name, got, want)
+		}
+	}
+	b := backend{Env: map[string]string{"RCLONE_VERBOSE": "1", "PATH": "/tmp", "LD_PRELOAD": "/tmp/x.so"}}
+	if err := b.validate(); err == nil || !strings.Contains(err.Error(), "may not set LD_PRELOAD, PATH") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := strings.Join(b.env(), " "); got != "RCLONE_VERBOSE=1" {
+		t.Fatalf("denied variables passed to restic: %q", got)
+	}
+	if err := (config{Backend: b}).validateJobs(); err == nil {
+		t.Fatalf("top-level backend not validated")
+	}
+}
+
+// restServer is a minimal stand-in for restic's rest-server. It only knows
+// the repository config file and requires basic auth.
+type restServer struct {
+	mu      sync.Mutex
+	config  []byte
+	creates int
+}
+
+// createCount returns how often a repository was created.
+func (s *restServer) createCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.creates
+}
+
+func (s *restServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if u, p, ok := r.BasicAuth(); !ok || u != "family" || p != "rest-secret" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/repo/" && r.URL.Query().Get("create") == "true":
+		s.creates++
+	case r.Method == http.MethodPost && r.URL.Path == "/repo/config":
+		s.config = []byte("config")
+	case r.Method == http.MethodGet && r.URL.Path == "/repo/config":
+		if s.config == nil {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(s.config)
+	default:
+		http.Error(w, "bad request", http.StatusBadRequest)
+	}
+}
+
+// restResticBody stands in for restic with a rest: repository. It talks to
+// the server with curl using the credentials restic reads from the
+// environment and exits with restic's exit codes.
+const restResticBody = `while [ "$1" != "" ]; do
+ if [ "$1" = "-r" ]; then shift; repo=${1#rest:}; else cmd="$cmd $1"; fi; shift; done
+auth="$RESTIC_REST_USERNAME:$RESTIC_REST_PASSWORD"
+case "$cmd" in
+" cat config")
+ code=$(curl -s -o /dev/null -w '%{http_code}' -u "$auth" "$repo/config")
+ case $code in
+ 200) exit 0 ;;
+ 404) echo "Fatal: unable to open config file: <config/> does not exist" >&2
+      echo "Is there a repository at the following location?" >&2
+      exit 10 ;;
+ esac
+ echo "Fatal: unable to open repository: unexpected HTTP response ($code)" >&2
+ exit 1 ;;
+" init")
+ curl -sf -u "$auth" -X POST "$repo/?create=true" &&
+ curl -sf -u "$auth" -X POST --data config "$repo/config" || exit 1
+ echo "created restic repository" ;;
+esac`
+
+// TestEnsureRepoRESTBackend initializes a rest: repository exactly once and
+// never initializes one it cannot open.
+func TestEnsureRepoRESTBackend(t *testing.T) {
+	if _, err := exec.LookPath("curl"); err != nil {
+		t.Skip("curl not installed")
+	}
+	srv := &restServer{}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+	resticPath := fakeRestic(t, t.TempDir(), restResticBody)
+	captureOutput(t)
+
+	cfg := config{Repo: "rest:" + ts.URL + "/repo", Password: "pw", Backend: backend{RESTUsername: "family", RESTPassword: "wrong"}}
+	if err := ensureRepo(resticPath, cfg); err == nil {
+		t.Fatalf("expected error with wrong credentials")
+	}
+	if srv.createCount() != 0 {
+		t.Fatalf("repository initialized without access")
+	}
+
+	cfg.Backend.RESTPassword = "rest-secret"
+	for i := 0; i < 2; i++ {
+		if err := ensureRepo(resticPath, cfg); err != nil {
+			t.Fatalf("ensureRepo: %v", err)
+		}
+	}
+	if n := srv.createCount(); n != 1 {
+		t.Fatalf("expected one initialization, got %d", n)
+	}
+}
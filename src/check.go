@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"backup/internal/restic"
+)
+
+// checkConfig schedules repository verification with restic check.
+type checkConfig struct {
+	// Interval is the minimum time between scheduled checks, e.g. "30d".
+	// Empty disables the scheduled check.
+	Interval string `json:"interval,omitempty"`
+	// ReadDataParts splits the pack files into this many parts. Every check
+	// reads the next part, so all data is read once per rotation.
+	ReadDataParts int `json:"read-data-parts,omitempty"`
+}
+
+// due reports whether a scheduled check should run.
+func (c checkConfig) due(lastCheck, now time.Time) (bool, error) {
+	if c.Interval == "" {
+		return false, nil
+	}
+	d, err := parseDuration(c.Interval)
+	if err != nil {
+		return false, fmt.Errorf("check interval: %w", err)
+	}
+	return now.Sub(lastCheck) >= d, nil
+}
+
+// nextSubset returns the --read-data-subset argument and the part number for
+// the next check of the rotation. Without rotation it returns "" and 0.
+func (c checkConfig) nextSubset(st jobState) (string, int) {
+	if c.ReadDataParts <= 0 {
+		return "", 0
+	}
+	part := st.CheckPart%c.ReadDataParts + 1
+	return strconv.Itoa(part) + "/" + strconv.Itoa(c.ReadDataParts), part
+}
+
+// runCheck verifies the repository, reading subset of the data if not empty.
+// The result is stored in the history and the state, and failures are sent
+// through notify. part advances the rotation when the check succeeds.
+func runCheck(client *restic.Client, cfg config, subset string, part int, out io.Writer, host string) error {
+	rec := runRecord{Kind: "check", Start: time.Now(), Detail: "structure only"}
+	if subset != "" {
+		rec.Detail = "read data subset " + subset
+	}
+	logf(out, "checking repository (%s)", rec.Detail)
+	err := unlockAndRetry(client, out, func() error {
+		return client.Check(context.Background(), restic.CheckOptions{ReadDataSubset: subset})
+	})
+	rec.End = time.Now()
+	rec.Job = cfg.Job
+	if err != nil {
+		rec.Error = err.Error()
+		logf(out, "repository check failed: %v", err)
+		notify(cfg, out, runNotification(rec, host, "repository check failed", fmt.Sprintf("restic check of %s on %s found problems (%s): %v", cfg.Repo, host, rec.Detail, err)))
+	} else {
+		rec.Success = true
+		logf(out, "repository check passed")
+	}
+	recordRun(rec)
+	serr := updateJobState(cfg.Job, func(js *jobState) {
+		js.LastCheck = rec.End
+		if err == nil && part > 0 {
+			js.CheckPart = part
+		}
+	})
+	if serr != nil {
+		fmt.Fprintf(stderr, "failed to save state: %v\n", serr)
+	}
+	return err
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"backup/internal/restic"
+)
+
+func TestCheckNextSubsetRotates(t *testing.T) {
+	c := checkConfig{ReadDataParts: 3}
+	var st jobState
+	var got []string
+	for i := 0; i < 4; i++ {
+		subset, part := c.nextSubset(st)
+		got = append(got, subset)
+		st.CheckPart = part
+	}
+	if strings.Join(got, " ") != "1/3 2/3 3/3 1/3" {
+		t.Fatalf("unexpected rotation: %v", got)
+	}
+	if subset, part := (checkConfig{}).nextSubset(st); subset != "" || part != 0 {
+		t.Fatalf("unexpected subset without rotation: %q %d", subset, part)
+	}
+}
+
+func TestCheckDue(t *testing.T) {
+	now := time.Now()
+	if due, _ := (checkConfig{}).due(time.Time{}, now); due {
+		t.Fatalf("check due without interval")
+	}
+	c := checkConfig{Interval: "30d"}
+	if due, _ := c.due(now.Add(-29*24*time.Hour), now); due {
+		t.Fatalf("check due too early")
+	}
+	if due, _ := c.due(time.Time{}, now); !due {
+		t.Fatalf("first check not due")
+	}
+}
+
+// fakeCheckRestic returns a client for a restic stand-in that reports repository
+// errors and exits with code.
+func fakeCheckRestic(t *testing.T, dir string, code int) *restic.Client {
+	t.Helper()
+	path := fakeRestic(t, dir, "echo 'Fatal: repository contains errors' >&2\nexit "+strconv.Itoa(code))
+	return restic.New(path, "/repo", "pw")
+}
+
+// TestRunCheckFailure records the failure and sends a notification.
+func TestRunCheckFailure(t *testing.T) {
+	dir := t.TempDir()
+	chdir(t, dir)
+	var form url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		form = r.Form
+	}))
+	defer srv.Close()
+	oldURL := pushoverURL
+	pushoverURL = srv.URL
+	defer func() { pushoverURL = oldURL }()
+
+	client := fakeCheckRestic(t, dir, 1)
+	cfg := config{Repo: "/repo", PushoverToken: "pt", PushoverUser: "pu"}
+	var out bytes.Buffer
+	if err := runCheck(client, cfg, "2/12", 2, &out, "host"); err == nil {
+		t.Fatalf("expected error")
+	}
+	if form.Get("title") != "repository check failed" || !strings.Contains(form.Get("message"), "read data subset 2/12") {
+		t.Fatalf("unexpected notification: %v", form)
+	}
+	recs, _ := readHistory()
+	if len(recs) != 1 || recs[0].Kind != "check" || recs[0].Success || !strings.Contains(recs[0].Error, "repository contains errors") {
+		t.Fatalf("unexpected history: %+v", recs)
+	}
+	st := loadState()
+	if st.LastCheck.IsZero() || st.CheckPart != 0 {
+		t.Fatalf("unexpected state: %+v", st)
+	}
+}
+
+// TestRunCheckSuccess advances the rotation.
+func TestRunCheckSuccess(t *testing.T) {
+	dir := t.TempDir()
+	chdir(t, dir)
+	client := fakeCheckRestic(t, dir, 0)
+	var out bytes.Buffer
+	if err := runCheck(client, config{}, "1/12", 1, &out, "host"); err != nil {
+		t.Fatalf("runCheck: %v", err)
+	}
+	if calls := readCalls(t, dir); len(calls) != 1 || calls[0] != "-r /repo check --read-data-subset 1/12" {
+		t.Fatalf("unexpected call: %q", calls)
+	}
+	if st := loadState(); st.CheckPart != 1 {
+		t.Fatalf("rotation not advanced: %+v", st)
+	}
+	if !strings.Contains(out.String(), "repository check passed") {
+		t.Fatalf("unexpected output: %q", out.String())
+	}
+}
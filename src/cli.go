@@ -0,0 +1,725 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"backup/internal/restic"
+)
+
+// stdout and stderr are the streams used by the subcommands. Tests replace
+// them to capture output.
+var (
+	stdout io.Writer = os.Stdout
+	stderr io.Writer = os.Stderr
+)
+
+// errUsage signals that a command was invoked with invalid arguments. The flag
+// package has already printed the problem and the usage text.
+var errUsage = errors.New("usage error")
+
+// command describes a CLI subcommand.
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) error
+}
+
+// commands lists all subcommands in the order they are shown in the help text.
+var commands []command
+
+func init() {
+	commands = []command{
+		{"backup", "back up the configured jobs (default)", cmdBackup},
+		{"daemon", "stay resident and run jobs on their schedules", cmdDaemon},
+		{"restore", "restore files from a snapshot", cmdRestore},
+		{"snapshots", "list snapshots in the repository", cmdSnapshots},
+		{"check", "verify repository integrity", cmdCheck},
+		{"forget", "remove snapshots from the repository", cmdForget},
+		{"scan", "estimate the size of a backup and find unreadable paths", cmdScan},
+		{"status", "show running jobs and when each job last ran", cmdStatus},
+		{"health", "print a report about the environment and configuration", cmdHealth},
+		{"notify", "send a test message or list the outbox (test|queue)", cmdNotify},
+		{"config", "print the effective configuration", cmdConfig},
+		{"install", "download restic and enable auto start at login", cmdInstall},
+		{"uninstall", "disable auto start at login", cmdUninstall},
+		{"paths", "show where configuration, state and restic are stored", cmdPaths},
+		{"version", "print the program version", cmdVersion},
+	}
+}
+
+// runCLI dispatches args to the matching subcommand and returns the exit code.
+// Without arguments the backup command runs.
+func runCLI(args []string) int {
+	if len(args) == 0 {
+		args = []string{"backup"}
+	}
+	name := args[0]
+	switch name {
+	case "-h", "-help", "--help", "help":
+		if len(args) > 1 {
+			if c, ok := findCommand(args[1]); ok {
+				return runCommand(c, []string{"-h"})
+			}
+		}
+		printUsage(stdout)
+		return 0
+	}
+	c, ok := findCommand(name)
+	if !ok {
+		fmt.Fprintf(stderr, "unknown command %q\n\n", name)
+		printUsage(stderr)
+		return 2
+	}
+	return runCommand(c, args[1:])
+}
+
+// runCommand executes c and converts its error into an exit code.
+func runCommand(c command, args []string) int {
+	err := c.run(args)
+	switch {
+	case err == nil, errors.Is(err, flag.ErrHelp):
+		return 0
+	case errors.Is(err, errUsage):
+		return 2
+	default:
+		fmt.Fprintf(stderr, "%s\n", redact(err.Error()))
+		return 1
+	}
+}
+
+// findCommand looks up a subcommand by name.
+func findCommand(name string) (command, bool) {
+	for _, c := range commands {
+		if c.name == name {
+			return c, true
+		}
+	}
+	return command{}, false
+}
+
+// printUsage writes the top-level help text to w.
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "usage: backup <command> [flags]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "commands:")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-10s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "run 'backup help <command>' for details about a command")
+}
+
+// newFlagSet creates a flag set for a subcommand with a usage line and a
+// description shown by -h.
+func newFlagSet(name, args, description string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		out := fs.Output()
+		fmt.Fprintf(out, "usage: backup %s", name)
+		if args != "" {
+			fmt.Fprintf(out, " %s", args)
+		}
+		fmt.Fprintf(out, "\n\n%s\n", description)
+		hasFlags := false
+		fs.VisitAll(func(*flag.Flag) { hasFlags = true })
+		if hasFlags {
+			fmt.Fprintln(out, "\nflags:")
+			fs.PrintDefaults()
+		}
+	}
+	return fs
+}
+
+// parseFlags parses args into fs and maps parse failures to errUsage.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return errUsage
+	}
+	return nil
+}
+
+// multiFlag collects the values of a repeatable string flag.
+type multiFlag []string
+
+// String returns the collected values.
+func (m *multiFlag) String() string {
+	return strings.Join(*m, ",")
+}
+
+// Set appends a value.
+func (m *multiFlag) Set(v string) error {
+	*m = append(*m, v)
+	return nil
+}
+
+// setup loads the configuration and makes sure restic is available. A restic
+// download is reported on stderr so it does not mix with the command output.
+func setup() (string, config, error) {
+	cfg := getConfig()
+	resticPath, err := ensureRestic(cfg, stderr)
+	if err != nil {
+		return "", config{}, err
+	}
+	return resticPath, cfg, nil
+}
+
+// cmdBackup implements the backup command.
+func cmdBackup(args []string) error {
+	fs := newFlagSet("backup", "[flags] [job...]", "Back up the paths of the named jobs, or of every job, to their restic\nrepositories.\n\nWithout a terminal on stdin, or with -yes or \"unattended\": true in the\nconfiguration, the backup starts without asking and its output is also\nappended to the log file shown by 'backup paths'.")
+	yes := fs.Bool("yes", false, "do not ask for confirmation")
+	fs.BoolVar(yes, "y", false, "shorthand for -yes")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	cfg := getConfig()
+	jobs, err := cfg.selectJobs(fs.Args())
+	if err != nil {
+		return err
+	}
+	unattended := cfg.Unattended || *yes || !isTerminal(os.Stdin)
+	out := stdout
+	if unattended {
+		f, err := openRunLog()
+		if err != nil {
+			fmt.Fprintf(stderr, "failed to open log: %v\n", err)
+		} else {
+			defer f.Close()
+			out = io.MultiWriter(stdout, f)
+		}
+	}
+	resticPath, err := ensureRestic(cfg, out)
+	if err != nil {
+		return err
+	}
+	retryOutbox(cfg, out, time.Now())
+	var failed []string
+	var lastErr error
+	for _, jc := range jobs {
+		jc.Unattended = unattended
+		if err := backupJob(resticPath, jc, out); err != nil {
+			failed = append(failed, jc.Job)
+			lastErr = err
+		}
+	}
+	// after the backups, so a job that just caught up is not reported
+	checkBackupAges(resticPath, jobs, out, time.Now())
+	if len(jobs) == 1 && lastErr != nil {
+		return lastErr
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d jobs failed: %s", len(failed), len(jobs), strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// backupJob backs up a single job, records and notifies the result and runs
+// the maintenance after a successful backup.
+func backupJob(resticPath string, cfg config, out io.Writer) error {
+	host, _ := os.Hostname()
+	label := jobLabel(cfg)
+	lock, err := acquireLock(cfg.Job, "backup")
+	if err != nil {
+		logf(out, "backup%s skipped: %v", label, err)
+		return err
+	}
+	defer lock.release()
+	rec := runRecord{Kind: "backup", Job: cfg.Job, Start: time.Now()}
+	logf(out, "backup%s started on %s", label, host)
+	fmt.Fprintln(out, "repository:", redact(cfg.Repo))
+	res, err := func() (*backupResult, error) {
+		if err := ensureRepo(resticPath, cfg); err != nil {
+			return nil, fmt.Errorf("failed to ensure repo: %w", err)
+		}
+		return runBackup(resticPath, cfg, os.Stdin, out)
+	}()
+	if err == nil && res == nil {
+		return nil
+	}
+	rec.End = time.Now()
+	if err != nil {
+		rec.Error = err.Error()
+		logf(out, "backup%s failed: %v", label, err)
+		notify(cfg, out, runNotification(rec, host, "backup failed", fmt.Sprintf("backup%s on %s failed: %v", label, host, err)))
+	} else {
+		rec.Success = true
+		rec.Summary = res.Summary
+		rec.Progress = &res.Progress
+		msg := summaryText(res.Summary, res.Progress.Errors)
+		logf(out, "backup%s succeeded: %s", label, msg)
+		notify(cfg, out, runNotification(rec, host, "backup succeeded", fmt.Sprintf("backup%s on %s completed: %s", label, host, msg)))
+	}
+	recordRun(rec)
+	serr := updateJobState(cfg.Job, func(js *jobState) {
+		js.LastRun = rec.Start
+		if err == nil {
+			js.LastSuccess = rec.Start
+		}
+	})
+	if serr != nil {
+		fmt.Fprintf(stderr, "failed to save state: %v\n", serr)
+	}
+	if err == nil {
+		client := newResticClient(resticPath, cfg)
+		client.Stdout, client.Stderr = out, out
+		runMaintenance(client, cfg, out, host)
+	}
+	return err
+}
+
+// cmdDaemon implements the daemon command.
+func cmdDaemon(args []string) error {
+	fs := newFlagSet("daemon", "[flags] [job...]", "Stay resident and back up the named jobs, or every job, according to their\n\"schedule\": an interval such as \"1h\" or \"7d\", or a cron expression such as\n\"30 2 * * *\". Runs missed while the machine was off or asleep are caught\nup soon after it wakes. The configuration is reloaded before due jobs run\nand every 15 minutes. Output is also appended to the log file.")
+	jitter := fs.Duration("jitter", 5*time.Minute, "delay due jobs by a random time up to `duration`")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	cfg := getConfig()
+	jobs, err := cfg.selectJobs(fs.Args())
+	if err != nil {
+		return err
+	}
+	out := stdout
+	if f, err := openRunLog(); err != nil {
+		fmt.Fprintf(stderr, "failed to open log: %v\n", err)
+	} else {
+		defer f.Close()
+		out = io.MultiWriter(stdout, f)
+	}
+	d, err := newDaemon(jobs, *jitter, out, func(jc config) error {
+		resticPath, err := ensureRestic(jc, out)
+		if err != nil {
+			return err
+		}
+		jc.Unattended = true
+		return backupJob(resticPath, jc, out)
+	})
+	if err != nil {
+		return err
+	}
+	// the closures below see the configuration of the last reload
+	d.reload = func() ([]config, error) {
+		next := getConfig()
+		nextJobs, err := next.selectJobs(fs.Args())
+		if err != nil {
+			return nil, err
+		}
+		cfg, jobs = next, nextJobs
+		return jobs, nil
+	}
+	d.retry = func() { retryOutbox(cfg, out, time.Now()) }
+	d.checkAge = func() {
+		resticPath, err := ensureRestic(cfg, out)
+		if err != nil {
+			logf(out, "max-age check failed: %v", err)
+			return
+		}
+		checkBackupAges(resticPath, jobs, out, time.Now())
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	logf(out, "daemon started")
+	d.loop(ctx)
+	return nil
+}
+
+// cmdRestore implements the restore command.
+func cmdRestore(args []string) error {
+	fs := newFlagSet("restore", "[flags] [snapshot]", "Restore files from the repository. Without a snapshot or -include the\ncommand lists the snapshots and lets you search for a file name or browse a\nsnapshot. Files are restored into a new folder below ~/Restored so nothing\nis overwritten.")
+	target := fs.String("target", "", "directory to restore into (default ~/Restored/<date>)")
+	jobName := fs.String("job", "", "restore from the repository of this `job` (required with several jobs)")
+	var include multiFlag
+	fs.Var(&include, "include", "restore only paths matching `pattern` (repeatable)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return errUsage
+	}
+	resticPath, cfg, err := setup()
+	if err != nil {
+		return err
+	}
+	cfg, err = cfg.selectJob(*jobName)
+	if err != nil {
+		return err
+	}
+	client := newResticClient(resticPath, cfg)
+	var sels []restoreSelection
+	if fs.NArg() == 1 || len(include) > 0 {
+		snapshot := "latest"
+		if fs.NArg() == 1 {
+			snapshot = fs.Arg(0)
+		}
+		sels = []restoreSelection{{Snapshot: snapshot, Paths: include}}
+	} else {
+		if !isTerminal(os.Stdin) {
+			return errors.New("restore needs a terminal; pass a snapshot or -include to restore without questions")
+		}
+		sels, err = guidedRestore(client, os.Stdin, stdout)
+		if err != nil {
+			return err
+		}
+	}
+	dst := defaultRestoreTarget(time.Now())
+	if *target != "" {
+		dst = expandUser(*target)
+	}
+	return restoreSelections(client, sels, dst, stdout)
+}
+
+// cmdSnapshots implements the snapshots command.
+func cmdSnapshots(args []string) error {
+	fs := newFlagSet("snapshots", "[job...]", "List the snapshots stored in the repositories of the named jobs, or of\nevery job.")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	resticPath, cfg, err := setup()
+	if err != nil {
+		return err
+	}
+	jobs, err := cfg.selectJobs(fs.Args())
+	if err != nil {
+		return err
+	}
+	for i, jc := range jobs {
+		if len(cfg.Jobs) > 0 {
+			if i > 0 {
+				fmt.Fprintln(stdout)
+			}
+			fmt.Fprintf(stdout, "job %s (%s)\n", jc.Job, redact(jc.Repo))
+		}
+		snaps, err := newResticClient(resticPath, jc).Snapshots(context.Background())
+		if err != nil {
+			return err
+		}
+		printSnapshots(stdout, snaps)
+	}
+	return nil
+}
+
+// printSnapshots writes snaps as a table to w.
+func printSnapshots(w io.Writer, snaps []restic.Snapshot) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tTIME\tHOST\tPATHS")
+	for _, s := range snaps {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", s.ShortID, s.Time.Local().Format("2006-01-02 15:04:05"), s.Hostname, strings.Join(s.Paths, ", "))
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "%d snapshots\n", len(snaps))
+}
+
+// cmdCheck implements the check command.
+func cmdCheck(args []string) error {
+	fs := newFlagSet("check", "[flags] [job...]", "Verify the integrity of the repositories of the named jobs, or of every\njob. Without -read-data-subset the next part of the rotation configured with\n\"check\": {\"read-data-parts\": N} is read. The result is recorded in the\nhistory and failures are notified.")
+	subset := fs.String("read-data-subset", "", "read this `subset` of the data, e.g. 1/12 or 10%")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	resticPath, cfg, err := setup()
+	if err != nil {
+		return err
+	}
+	jobs, err := cfg.selectJobs(fs.Args())
+	if err != nil {
+		return err
+	}
+	retryOutbox(cfg, stdout, time.Now())
+	host, _ := os.Hostname()
+	var errs []error
+	for _, jc := range jobs {
+		s, part := *subset, 0
+		if s == "" {
+			s, part = jc.Check.nextSubset(loadState().job(jc.Job))
+		}
+		err := withJobLock(jc.Job, "check", func() error {
+			return runCheck(newResticClient(resticPath, jc), jc, s, part, stdout, host)
+		})
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// cmdForget implements the forget command.
+func cmdForget(args []string) error {
+	fs := newFlagSet("forget", "[flags] [job...]", "Remove snapshots that fall outside the retention policy configured in the\n\"retention\" block of the named jobs, or of every job. Use -dry-run to\npreview which snapshots would be removed.")
+	dryRun := fs.Bool("dry-run", false, "only list the snapshots that would be removed")
+	prune := fs.Bool("prune", false, "prune the repository afterwards regardless of prune-interval")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	resticPath, cfg, err := setup()
+	if err != nil {
+		return err
+	}
+	jobs, err := cfg.selectJobs(fs.Args())
+	if err != nil {
+		return err
+	}
+	if !*dryRun {
+		retryOutbox(cfg, stdout, time.Now())
+	}
+	applied := 0
+	for _, jc := range jobs {
+		if !jc.Retention.enabled() {
+			continue
+		}
+		applied++
+		if len(cfg.Jobs) > 0 {
+			fmt.Fprintf(stdout, "job %s\n", jc.Job)
+		}
+		// a dry run only reads the repository and may overlap a running job
+		run := func() error { return forgetJob(resticPath, jc, *dryRun, *prune) }
+		if !*dryRun {
+			run = func() error {
+				return withJobLock(jc.Job, "forget", func() error { return forgetJob(resticPath, jc, false, *prune) })
+			}
+		}
+		if err := run(); err != nil {
+			return err
+		}
+	}
+	if applied == 0 {
+		return errors.New("no retention policy configured")
+	}
+	return nil
+}
+
+// forgetJob applies the retention policy of a job and prunes its repository
+// if requested.
+func forgetJob(resticPath string, jc config, dryRun, prune bool) error {
+	client := newResticClient(resticPath, jc)
+	ctx := context.Background()
+	var groups []restic.ForgetGroup
+	err := unlockAndRetry(client, stdout, func() (err error) {
+		groups, err = client.Forget(ctx, restic.ForgetOptions{Policy: jc.Retention.policy(), Tags: jc.snapshotTags(), DryRun: dryRun})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	printForgetGroups(stdout, groups, dryRun)
+	if dryRun || !prune {
+		return nil
+	}
+	if err := unlockAndRetry(client, stdout, func() error { return client.Prune(ctx) }); err != nil {
+		return err
+	}
+	return updateJobState(jc.Job, func(js *jobState) { js.LastPrune = time.Now() })
+}
+
+// cmdScan implements the scan command.
+func cmdScan(args []string) error {
+	fs := newFlagSet("scan", "[flags] [job...]", "Walk the paths of the named jobs, or of every job, as a backup would and\nreport their size, the largest directories, missing and unreadable paths and\nthe free space of local repositories. The same scan runs before every\nbackup.")
+	asJSON := fs.Bool("json", false, "print the result as JSON")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	cfg := getConfig()
+	jobs, err := cfg.selectJobs(fs.Args())
+	if err != nil {
+		return err
+	}
+	results := make([]scanResult, 0, len(jobs))
+	for _, jc := range jobs {
+		results = append(results, scanPaths(jc))
+	}
+	if *asJSON {
+		data, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(stdout, string(data))
+		return nil
+	}
+	for i, r := range results {
+		indent := ""
+		if len(cfg.Jobs) > 0 {
+			if i > 0 {
+				fmt.Fprintln(stdout)
+			}
+			fmt.Fprintf(stdout, "job %s\n", r.Job)
+			indent = "  "
+		}
+		printScan(stdout, indent, r)
+	}
+	return nil
+}
+
+// cmdStatus implements the status command.
+func cmdStatus(args []string) error {
+	fs := newFlagSet("status", "[job...]", "Show which of the named jobs, or of every job, are running right now, and\nwhen each job last ran, last succeeded and runs next.")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	jobs, err := getConfig().selectJobs(fs.Args())
+	if err != nil {
+		return err
+	}
+	printStatus(stdout, jobs, time.Now())
+	return nil
+}
+
+// cmdHealth implements the health command.
+func cmdHealth(args []string) error {
+	fs := newFlagSet("health", "[flags] [job...]", "Print a report about the environment, the configuration and the named\njobs, or every job, and send it through the configured notification\nchannels. Secrets are masked in the report; -show-secrets prints them but\nthey are still masked in notifications.")
+	fs.BoolVar(&showSecrets, "show-secrets", false, "print passwords and tokens for debugging")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	resticPath, cfg, err := setup()
+	if err != nil {
+		return err
+	}
+	jobs, err := cfg.selectJobs(fs.Args())
+	if err != nil {
+		return err
+	}
+	report, masked := healthReport(resticPath, cfg, jobs)
+	fmt.Fprint(stdout, report)
+	host, _ := os.Hostname()
+	notify(cfg, stdout, notification{Subject: "health report", Body: masked, Event: "health", Status: "info", Host: host})
+	checkBackupAges(resticPath, jobs, stdout, time.Now())
+	return nil
+}
+
+// cmdNotify implements the notify command.
+func cmdNotify(args []string) error {
+	fs := newFlagSet("notify", "test|queue", "test sends a test message through every configured notification channel\nand reports whether each channel delivered it.\n\nqueue lists the notifications that failed to be delivered: pending ones\nare retried with increasing delays on the next run or daemon tick until\nthey are delivered or expire after a week.")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 || (fs.Arg(0) != "test" && fs.Arg(0) != "queue") {
+		fs.Usage()
+		return errUsage
+	}
+	if fs.Arg(0) == "queue" {
+		printOutbox(stdout, loadOutbox(), time.Now())
+		return nil
+	}
+	cfg := getConfig()
+	host, _ := os.Hostname()
+	results := notify(cfg, io.Discard, notification{
+		Subject: "test notification",
+		Body:    fmt.Sprintf("test notification from backup on %s", host),
+		Event:   "test",
+		Status:  "info",
+		Host:    host,
+		Start:   time.Now(),
+	})
+	if len(results) == 0 {
+		return errors.New("no notification channel configured")
+	}
+	failed := 0
+	for _, r := range results {
+		if r.Err != nil {
+			failed++
+			fmt.Fprintf(stdout, "%s: failed: %v\n", r.Channel, r.Err)
+		} else {
+			fmt.Fprintf(stdout, "%s: delivered\n", r.Channel)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d channels failed", failed, len(results))
+	}
+	return nil
+}
+
+// cmdConfig implements the config command.
+func cmdConfig(args []string) error {
+	fs := newFlagSet("config", "", "Print the effective configuration as JSON with secrets masked.")
+	fs.BoolVar(&showSecrets, "show-secrets", false, "print passwords and tokens for debugging")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	cfg := getConfig()
+	if !showSecrets {
+		cfg = cfg.redacted()
+	}
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(stdout, string(data))
+	return nil
+}
+
+// cmdInstall implements the install command.
+func cmdInstall(args []string) error {
+	fs := newFlagSet("install", "[flags]", "Download restic and start the program automatically at login. By default\nthe login entry runs a backup once; with -daemon it starts the daemon,\nwhich runs the jobs on their schedules.\n\nWith -rollback-restic the restic binary is swapped with the one the last\nupgrade replaced and kept until install runs again.")
+	rollback := fs.Bool("rollback-restic", false, "restore the previously installed restic binary")
+	asDaemon := fs.Bool("daemon", false, "start the daemon at login instead of a single backup")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *rollback {
+		if err := rollbackRestic(managedResticPath()); err != nil {
+			return err
+		}
+		v, _ := installedResticVersion(managedResticPath())
+		if err := updateState(func(st *state) { st.ResticPin = v.String() }); err != nil {
+			return err
+		}
+		fmt.Fprintln(stdout, "restic rolled back to", v)
+		return nil
+	}
+	if *asDaemon {
+		ensureAutoStart("daemon")
+	} else {
+		// -yes keeps the login run from prompting whatever stdin is
+		ensureAutoStart("backup", "-yes")
+	}
+	// look for an upgrade now, also of a rolled back binary
+	err := updateState(func(st *state) { st.ResticPin, st.LastResticCheck = "", time.Time{} })
+	if err != nil {
+		return err
+	}
+	_, err = ensureRestic(getConfig(), stdout)
+	return err
+}
+
+// cmdUninstall implements the uninstall command.
+func cmdUninstall(args []string) error {
+	fs := newFlagSet("uninstall", "", "Stop starting the program automatically at login.")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	return removeAutoStart()
+}
+
+// cmdPaths implements the paths command.
+func cmdPaths(args []string) error {
+	fs := newFlagSet("paths", "", "Show where the configuration, state, history, log and restic binary are\nstored for the current user.")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	printPaths(stdout)
+	return nil
+}
+
+// cmdVersion implements the version command.
+func cmdVersion(args []string) error {
+	fs := newFlagSet("version", "", "Print the program version.")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	printVersion()
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"backup/internal/restic"
+)
+
+// captureOutput redirects stdout and stderr for the duration of the test.
+func captureOutput(t *testing.T) (*bytes.Buffer, *bytes.Buffer) {
+	t.Helper()
+	oldOut, oldErr := stdout, stderr
+	var out, errOut bytes.Buffer
+	stdout, stderr = &out, &errOut
+	t.Cleanup(func() { stdout, stderr = oldOut, oldErr })
+	return &out, &errOut
+}
+
+// TestRunCLIVersion ensures the version command only prints the version.
+func TestRunCLIVersion(t *testing.T) {
+	out, _ := captureOutput(t)
+	if code := runCLI([]string{"version"}); code != 0 {
+		t.Fatalf("unexpected exit code %d", code)
+	}
+	if !strings.Contains(out.String(), "backup version "+Version) {
+		t.Fatalf("unexpected output: %q", out.String())
+	}
+	if strings.Contains(out.String(), "restic") {
+		t.Fatalf("version touched restic: %q", out.String())
+	}
+}
+
+// TestRunCLIUnknown reports unknown commands with a usage error.
+func TestRunCLIUnknown(t *testing.T) {
+	_, errOut := captureOutput(t)
+	if code := runCLI([]string{"bogus"}); code != 2 {
+		t.Fatalf("unexpected exit code %d", code)
+	}
+	if !strings.Contains(errOut.String(), `unknown command "bogus"`) || !strings.Contains(errOut.String(), "snapshots") {
+		t.Fatalf("unexpected output: %q", errOut.String())
+	}
+}
+
+// TestRunCLIHelp prints the command list and per-command help.
+func TestRunCLIHelp(t *testing.T) {
+	out, errOut := captureOutput(t)
+	if code := runCLI([]string{"help"}); code != 0 {
+		t.Fatalf("unexpected exit code %d", code)
+	}
+	for _, c := range commands {
+		if !strings.Contains(out.String(), c.name) {
+			t.Fatalf("help missing %s: %q", c.name, out.String())
+		}
+	}
+	if code := runCLI([]string{"help", "restore"}); code != 0 {
+		t.Fatalf("unexpected exit code %d", code)
+	}
+	if !strings.Contains(errOut.String(), "usage: backup restore") || !strings.Contains(errOut.String(), "-target") {
+		t.Fatalf("unexpected restore help: %q", errOut.String())
+	}
+}
+
+// TestRunCLIBadFlag returns a usage error for unknown flags.
+func TestRunCLIBadFlag(t *testing.T) {
+	captureOutput(t)
+	if code := runCLI([]string{"version", "-nope"}); code != 2 {
+		t.Fatalf("unexpected exit code %d", code)
+	}
+}
+
+// TestRunCLIConfigJSON keeps the status of the remote configuration out of
+// the JSON on stdout.
+func TestRunCLIConfigJSON(t *testing.T) {
+	chdir(t, t.TempDir())
+	out, errOut := captureOutput(t)
+	restore := withHTTPClient(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		body := `{"restic-repo":"pb-repo"}`
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body)), Header: make(http.Header), Request: req}, nil
+	}))
+	defer restore()
+	if code := runCLI([]string{"config"}); code != 0 {
+		t.Fatalf("unexpected exit code %d: %s", code, errOut.String())
+	}
+	var cfg config
+	if err := json.Unmarshal(out.Bytes(), &cfg); err != nil || cfg.Repo != "pb-repo" {
+		t.Fatalf("stdout is not the configuration (%v): %q", err, out.String())
+	}
+	if !strings.Contains(errOut.String(), "pastebin config fetched successfully") {
+		t.Fatalf("unexpected stderr: %q", errOut.String())
+	}
+}
+
+// TestPrintSnapshots renders snapshots as a table.
+func TestPrintSnapshots(t *testing.T) {
+	var b bytes.Buffer
+	printSnapshots(&b, []restic.Snapshot{{ShortID: "abc123", Hostname: "laptop", Paths: []string{"/a", "/b"}}})
+	out := b.String()
+	if !strings.Contains(out, "abc123") || !strings.Contains(out, "laptop") || !strings.Contains(out, "/a, /b") || !strings.Contains(out, "1 snapshots") {
+		t.Fatalf("unexpected table: %q", out)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"reflect"
+	"time"
+)
+
+// daemonTick is how often the daemon looks for due jobs.
+const daemonTick = time.Minute
+
+// configReloadInterval is how often the daemon reloads the configuration
+// while no job is due. It is reloaded on every tick while one is.
+const configReloadInterval = 15 * time.Minute
+
+// daemon runs scheduled jobs while the program stays resident.
+type daemon struct {
+	// configured holds every job given, jobs only those with a schedule.
+	configured []config
+	jobs       []config
+	schedules  map[string]schedule
+	jitter     time.Duration
+	out        io.Writer
+
+	// pending holds the start time, including jitter, of jobs that are due.
+	pending map[string]time.Time
+
+	// now, randDuration and run are replaced in tests.
+	now          func() time.Time
+	randDuration func(max time.Duration) time.Duration
+	run          func(cfg config) error
+	// retry, if set, retries the queued notifications on every tick.
+	retry func()
+	// checkAge, if set, compares the snapshots with the max-age of the jobs
+	// on the first tick and then every ageCheckInterval.
+	checkAge     func()
+	lastAgeCheck time.Time
+	// reload, if set, returns the jobs of the current configuration, so
+	// changes apply without restarting the daemon.
+	reload     func() ([]config, error)
+	lastReload time.Time
+}
+
+// newDaemon prepares the scheduled jobs among jobs. Jobs without a schedule
+// are skipped with a message; an invalid schedule is an error.
+func newDaemon(jobs []config, jitter time.Duration, out io.Writer, run func(cfg config) error) (*daemon, error) {
+	d := &daemon{
+		jitter:  jitter,
+		out:     out,
+		pending: map[string]time.Time{},
+		// Round(0) drops the monotonic clock reading, so times are compared
+		// by the wall clock, which keeps running while the machine sleeps.
+		now: func() time.Time { return time.Now().Round(0) },
+		randDuration: func(max time.Duration) time.Duration {
+			if max <= 0 {
+				return 0
+			}
+			return rand.N(max)
+		},
+		run: run,
+	}
+	if err := d.setJobs(jobs); err != nil {
+		return nil, err
+	}
+	d.lastReload = d.now()
+	return d, nil
+}
+
+// setJobs replaces the scheduled jobs with those among jobs. On an error the
+// previous jobs are kept.
+func (d *daemon) setJobs(jobs []config) error {
+	var scheduled []config
+	schedules := map[string]schedule{}
+	for _, jc := range jobs {
+		if jc.Schedule == "" {
+			fmt.Fprintf(d.out, "job %s has no schedule and only runs on demand\n", jc.Job)
+			continue
+		}
+		s, err := parseSchedule(jc.Schedule)
+		if err != nil {
+			return fmt.Errorf("job %s: %w", jc.Job, err)
+		}
+		scheduled = append(scheduled, jc)
+		schedules[jc.Job] = s
+	}
+	if len(scheduled) == 0 {
+		return fmt.Errorf("no job has a schedule")
+	}
+	for name := range d.pending {
+		if _, ok := schedules[name]; !ok {
+			delete(d.pending, name)
+		}
+	}
+	d.configured, d.jobs, d.schedules = jobs, scheduled, schedules
+	return nil
+}
+
+// reloadJobs applies changes of the configuration to the jobs. A
+// configuration that cannot be loaded or has no valid schedule is logged and
+// the previous jobs keep running.
+func (d *daemon) reloadJobs() {
+	jobs, err := d.reload()
+	if err != nil {
+		logf(d.out, "configuration not reloaded: %v", err)
+		return
+	}
+	if reflect.DeepEqual(jobs, d.configured) {
+		return
+	}
+	if err := d.setJobs(jobs); err != nil {
+		logf(d.out, "configuration not reloaded: %v", err)
+		return
+	}
+	logf(d.out, "configuration changed")
+	d.printSchedule()
+}
+
+// due reports whether a job waits for its jitter or has reached its next run.
+func (d *daemon) due() bool {
+	if len(d.pending) > 0 {
+		return true
+	}
+	st := loadState()
+	for _, jc := range d.jobs {
+		if !d.now().Before(nextRun(d.schedules[jc.Job], st.job(jc.Job))) {
+			return true
+		}
+	}
+	return false
+}
+
+// loop runs due jobs every daemonTick until ctx is canceled.
+func (d *daemon) loop(ctx context.Context) {
+	d.printSchedule()
+	ticker := time.NewTicker(daemonTick)
+	defer ticker.Stop()
+	for {
+		d.tick()
+		select {
+		case <-ctx.Done():
+			logf(d.out, "daemon stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// printSchedule lists when every job runs next.
+func (d *daemon) printSchedule() {
+	st := loadState()
+	for _, jc := range d.jobs {
+		next := nextRun(d.schedules[jc.Job], st.job(jc.Job))
+		when := "now"
+		if next.After(d.now()) {
+			when = next.Local().Format("2006-01-02 15:04")
+		}
+		logf(d.out, "job %s (%s) runs next %s", jc.Job, jc.Schedule, when)
+	}
+}
+
+// tick reloads the configuration, retries queued notifications, checks the
+// max-age of the jobs and starts the jobs that are due. A due job is delayed
+// by a random jitter so machines sharing a repository do not all start at the
+// same moment. Jobs run one after another.
+func (d *daemon) tick() {
+	if d.reload != nil && (d.due() || d.now().Sub(d.lastReload) >= configReloadInterval) {
+		d.lastReload = d.now()
+		d.reloadJobs()
+	}
+	if d.retry != nil {
+		d.retry()
+	}
+	if d.checkAge != nil && d.now().Sub(d.lastAgeCheck) >= ageCheckInterval {
+		d.lastAgeCheck = d.now()
+		d.checkAge()
+	}
+	for _, jc := range d.jobs {
+		now := d.now()
+		next := nextRun(d.schedules[jc.Job], loadState().job(jc.Job))
+		if now.Before(next) {
+			delete(d.pending, jc.Job)
+			continue
+		}
+		at, ok := d.pending[jc.Job]
+		if !ok {
+			at = now.Add(d.randDuration(d.jitter))
+			d.pending[jc.Job] = at
+			if at.After(now) {
+				logf(d.out, "job %s is due, starting at %s", jc.Job, at.Local().Format("15:04:05"))
+			}
+		}
+		if now.Before(at) {
+			continue
+		}
+		delete(d.pending, jc.Job)
+		// a job that is already running was logged by run and is retried
+		// once the other run has finished
+		if err := d.run(jc); err != nil && !errors.Is(err, errAlreadyRunning) {
+			logf(d.out, "job %s failed: %v", jc.Job, err)
+		}
+		if next := nextRun(d.schedules[jc.Job], loadState().job(jc.Job)); !next.IsZero() {
+			logf(d.out, "job %s runs next %s", jc.Job, next.Local().Format("2006-01-02 15:04"))
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testDaemon returns a daemon with a fake clock and a run function that
+// records the jobs it runs and updates their state like backupJob.
+func testDaemon(t *testing.T, jobs []config, fail bool) (*daemon, *time.Time, *[]string, *bytes.Buffer) {
+	t.Helper()
+	var out bytes.Buffer
+	var ran []string
+	now := local(2024, 5, 1, 10, 0)
+	d, err := newDaemon(jobs, 10*time.Minute, &out, func(jc config) error {
+		ran = append(ran, jc.Job)
+		start := now
+		updateJobState(jc.Job, func(js *jobState) {
+			js.LastRun = start
+			if !fail {
+				js.LastSuccess = start
+			}
+		})
+		if fail {
+			return errors.New("disk not connected")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("newDaemon: %v", err)
+	}
+	d.now = func() time.Time { return now }
+	d.randDuration = func(max time.Duration) time.Duration { return max / 2 }
+	return d, &now, &ran, &out
+}
+
+func TestNewDaemonSchedules(t *testing.T) {
+	var out bytes.Buffer
+	if _, err := newDaemon([]config{{Job: "a"}}, 0, &out, nil); err == nil {
+		t.Fatalf("expected error without scheduled jobs")
+	}
+	if _, err := newDaemon([]config{{Job: "a", Schedule: "whenever"}}, 0, &out, nil); err == nil {
+		t.Fatalf("expected error for invalid schedule")
+	}
+	d, err := newDaemon([]config{{Job: "a"}, {Job: "b", Schedule: "1h"}}, 0, &out, nil)
+	if err != nil || len(d.jobs) != 1 || d.jobs[0].Job != "b" {
+		t.Fatalf("unexpected daemon: %+v %v", d, err)
+	}
+	if !strings.Contains(out.String(), "job a has no schedule") {
+		t.Fatalf("unscheduled job not reported: %q", out.String())
+	}
+}
+
+// TestDaemonJitterAndInterval runs a new job after the jitter and then once
+// per interval.
+func TestDaemonJitterAndInterval(t *testing.T) {
+	chdir(t, t.TempDir())
+	d, now, ran, _ := testDaemon(t, []config{{Job: "docs", Schedule: "1h"}}, false)
+
+	d.tick()
+	if len(*ran) != 0 {
+		t.Fatalf("job ran before the jitter passed")
+	}
+	*now = now.Add(5 * time.Minute)
+	d.tick()
+	if len(*ran) != 1 {
+		t.Fatalf("job did not run after the jitter: %v", *ran)
+	}
+	*now = now.Add(30 * time.Minute)
+	d.tick()
+	if len(*ran) != 1 {
+		t.Fatalf("job ran before its interval: %v", *ran)
+	}
+	*now = now.Add(35 * time.Minute)
+	d.tick()
+	*now = now.Add(5 * time.Minute)
+	d.tick()
+	if len(*ran) != 2 {
+		t.Fatalf("job did not run after its interval: %v", *ran)
+	}
+}
+
+// TestDaemonCatchUp runs a job whose scheduled time passed while the machine
+// was asleep soon after it wakes up.
+func TestDaemonCatchUp(t *testing.T) {
+	chdir(t, t.TempDir())
+	d, now, ran, out := testDaemon(t, []config{{Job: "photos", Schedule: "0 2 * * *"}}, false)
+	last := local(2024, 4, 29, 2, 0)
+	updateJobState("photos", func(js *jobState) { js.LastRun, js.LastSuccess = last, last })
+
+	d.tick()
+	*now = now.Add(5 * time.Minute)
+	d.tick()
+	if len(*ran) != 1 {
+		t.Fatalf("missed run not caught up: %v", *ran)
+	}
+	if !strings.Contains(out.String(), "job photos runs next 2024-05-02 02:00") {
+		t.Fatalf("unexpected output: %q", out.String())
+	}
+}
+
+// TestDaemonRetriesFailure retries a failed job after failedRunRetry.
+func TestDaemonRetriesFailure(t *testing.T) {
+	chdir(t, t.TempDir())
+	d, now, ran, out := testDaemon(t, []config{{Job: "photos", Schedule: "7d"}}, true)
+	d.randDuration = func(time.Duration) time.Duration { return 0 }
+
+	d.tick()
+	if len(*ran) != 1 || !strings.Contains(out.String(), "job photos failed: disk not connected") {
+		t.Fatalf("unexpected run: %v %q", *ran, out.String())
+	}
+	*now = now.Add(failedRunRetry - time.Minute)
+	d.tick()
+	if len(*ran) != 1 {
+		t.Fatalf("failed job retried too early")
+	}
+	*now = now.Add(time.Minute)
+	d.tick()
+	if len(*ran) != 2 {
+		t.Fatalf("failed job not retried: %v", *ran)
+	}
+}
+
+// TestDaemonReloadsConfig picks up configuration changes before due jobs run
+// and every configReloadInterval, and keeps the jobs when the new
+// configuration is invalid.
+func TestDaemonReloadsConfig(t *testing.T) {
+	chdir(t, t.TempDir())
+	d, now, _, out := testDaemon(t, []config{{Job: "docs", Repo: "/old", Schedule: "1h"}}, false)
+	d.lastReload = *now
+	var repos []string
+	d.run = func(jc config) error {
+		repos = append(repos, jc.Repo)
+		start := *now
+		return updateJobState(jc.Job, func(js *jobState) { js.LastRun, js.LastSuccess = start, start })
+	}
+	current := []config{{Job: "docs", Repo: "/old", Schedule: "1h"}}
+	reloads := 0
+	d.reload = func() ([]config, error) {
+		reloads++
+		return current, nil
+	}
+
+	d.tick()
+	current = []config{{Job: "docs", Repo: "/new", Schedule: "1h"}}
+	*now = now.Add(5 * time.Minute)
+	d.tick()
+	if reloads != 2 || len(repos) != 1 || repos[0] != "/new" {
+		t.Fatalf("job ran without the new configuration: %d reloads, repos %v", reloads, repos)
+	}
+
+	// nothing due: reloaded only after the interval
+	*now = now.Add(10 * time.Minute)
+	d.tick()
+	if reloads != 2 {
+		t.Fatalf("reloaded while no job was due")
+	}
+	current = append(current, config{Job: "photos", Schedule: "1h"})
+	*now = now.Add(configReloadInterval)
+	d.tick()
+	if reloads != 3 || len(d.jobs) != 2 || !strings.Contains(out.String(), "configuration changed") || !strings.Contains(out.String(), "job photos (1h) runs next now") {
+		t.Fatalf("new job not scheduled: %+v %q", d.jobs, out.String())
+	}
+
+	current = []config{{Job: "docs", Schedule: "whenever"}}
+	*now = now.Add(5 * time.Minute)
+	d.tick()
+	if len(d.jobs) != 2 || !strings.Contains(out.String(), "configuration not reloaded: job docs:") {
+		t.Fatalf("invalid configuration applied: %+v %q", d.jobs, out.String())
+	}
+	if len(repos) != 2 {
+		t.Fatalf("jobs stopped after an invalid configuration: %v", repos)
+	}
+}
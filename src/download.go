@@ -0,0 +1,408 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"backup/internal/restic"
+)
+
+const releasesURL = "https://api.github.com/repos/restic/restic/releases?per_page=100"
+
+// resticCheckInterval limits how often GitHub is asked for new releases.
+const resticCheckInterval = 24 * time.Hour
+
+type release struct {
+	TagName    string `json:"tag_name"`
+	Draft      bool   `json:"draft"`
+	Prerelease bool   `json:"prerelease"`
+	Assets     []struct {
+		Name               string `json:"name"`
+		BrowserDownloadURL string `json:"browser_download_url"`
+	} `json:"assets"`
+}
+
+// assetURL returns the download URL of the named release asset.
+func (r release) assetURL(name string) string {
+	for _, a := range r.Assets {
+		if a.Name == name {
+			return a.BrowserDownloadURL
+		}
+	}
+	return ""
+}
+
+// ensureRestic returns the restic binary to use. A configured restic-path is
+// used as is. Otherwise it makes sure a managed restic satisfying
+// cfg.ResticVersion is installed; releases are looked up at most once per
+// resticCheckInterval and only upgrades within the allowed range are
+// installed. When the lookup or download fails, an existing binary inside the
+// range or a restic found in $PATH is used instead, so an offline machine
+// still backs up. A binary rolled back with install -rollback-restic is kept
+// while the range allows it. Downloads and fallbacks are reported to out.
+func ensureRestic(cfg config, out io.Writer) (string, error) {
+	if cfg.ResticPath != "" {
+		path := expandUser(cfg.ResticPath)
+		if _, err := os.Stat(path); err != nil {
+			return "", fmt.Errorf("restic-path: %w", err)
+		}
+		return path, nil
+	}
+	resticPath := managedResticPath()
+	constraint, err := parseVersionConstraint(cfg.ResticVersion)
+	if err != nil {
+		return "", err
+	}
+	installed, ok := installedResticVersion(resticPath)
+	usable := ok && constraint.allows(installed)
+	st := loadState()
+	if usable && (st.ResticPin == installed.String() || time.Since(st.LastResticCheck) < resticCheckInterval) {
+		return resticPath, nil
+	}
+	if err := updateRestic(cfg, out, constraint, resticPath, installed, ok, usable); err != nil {
+		if usable {
+			fmt.Fprintf(out, "%v; continuing with restic %s\n", err, installed)
+			return resticPath, nil
+		}
+		if ok {
+			return "", fmt.Errorf("restic %s does not match restic-version %q: %w", installed, cfg.ResticVersion, err)
+		}
+		if path, lerr := exec.LookPath("restic"); lerr == nil {
+			fmt.Fprintf(out, "%v; using %s\n", err, path)
+			return path, nil
+		}
+		return "", err
+	}
+	// the download may take a while, so only the restic fields are updated
+	err = updateState(func(st *state) {
+		st.LastResticCheck = time.Now()
+		// an upgrade replaces a rolled back binary the range no longer allows
+		st.ResticPin = ""
+	})
+	if err != nil {
+		fmt.Fprintf(out, "failed to save state: %v\n", err)
+	}
+	return resticPath, nil
+}
+
+// updateRestic installs the newest release allowed by constraint unless the
+// installed version is already usable and up to date.
+func updateRestic(cfg config, out io.Writer, constraint versionConstraint, resticPath string, installed semver, ok, usable bool) error {
+	rels, err := fetchReleases(cfg.ResticMirror)
+	if err != nil {
+		return fmt.Errorf("failed to list restic releases: %w", err)
+	}
+	rel, v, err := selectRelease(rels, constraint)
+	if err != nil {
+		return err
+	}
+	if usable && installed.compare(v) >= 0 {
+		return nil
+	}
+	if ok {
+		fmt.Fprintf(out, "replacing restic %s with %s...\n", installed, v)
+	} else {
+		fmt.Fprintf(out, "restic not found, downloading %s...\n", v)
+	}
+	if err := downloadRestic(rel, filepath.Dir(resticPath), resticPath, cfg.ResticKeyring); err != nil {
+		return fmt.Errorf("failed to download restic: %w", err)
+	}
+	fmt.Fprintln(out, "restic", v, "installed to", resticPath)
+	return nil
+}
+
+// managedResticPath returns where the downloaded restic binary lives.
+func managedResticPath() string {
+	resticName := "restic"
+	if runtime.GOOS == "windows" {
+		resticName += ".exe"
+	}
+	return dataPath("bin", resticName)
+}
+
+// installedResticVersion returns the version of the restic binary at path.
+func installedResticVersion(path string) (semver, bool) {
+	if _, err := os.Stat(path); err != nil {
+		return semver{}, false
+	}
+	out, err := restic.New(path, "", "").Version(context.Background())
+	if err != nil {
+		return semver{}, false
+	}
+	fields := strings.Fields(out)
+	if len(fields) < 2 {
+		return semver{}, false
+	}
+	v, _, err := parseSemver(fields[1])
+	return v, err == nil
+}
+
+// fetchReleases lists the published restic releases. With a mirror, the list
+// is read from <mirror>/releases.json, which uses the format of the GitHub API,
+// and assets are downloaded from <mirror>/<tag>/<name>.
+func fetchReleases(mirror string) ([]release, error) {
+	url := releasesURL
+	mirror = strings.TrimSuffix(mirror, "/")
+	if mirror != "" {
+		url = mirror + "/releases.json"
+	}
+	data, err := downloadWithRetry(url)
+	if err != nil {
+		return nil, err
+	}
+	var rels []release
+	if err := json.Unmarshal(data, &rels); err != nil {
+		return nil, err
+	}
+	if mirror != "" {
+		for i, r := range rels {
+			for j, a := range r.Assets {
+				rels[i].Assets[j].BrowserDownloadURL = mirror + "/" + r.TagName + "/" + a.Name
+			}
+		}
+	}
+	return rels, nil
+}
+
+// downloadAttempts and downloadBackoff control how often failed downloads
+// are retried. The delay doubles after every attempt.
+var (
+	downloadAttempts = 4
+	downloadBackoff  = time.Second
+)
+
+// downloadWithRetry fetches url, retrying network errors and server side
+// failures with exponential backoff. Proxies are taken from the standard
+// HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables.
+func downloadWithRetry(url string) ([]byte, error) {
+	var err error
+	for attempt := 0; attempt < downloadAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(downloadBackoff << (attempt - 1))
+		}
+		var data []byte
+		if data, err = httpGetBody(url); err == nil {
+			return data, nil
+		}
+		var se *statusError
+		if errors.As(err, &se) && se.Code < 500 && se.Code != http.StatusTooManyRequests {
+			break
+		}
+	}
+	return nil, err
+}
+
+// selectRelease returns the newest stable release allowed by c.
+func selectRelease(rels []release, c versionConstraint) (release, semver, error) {
+	var (
+		best    release
+		bestVer semver
+		found   bool
+	)
+	for _, r := range rels {
+		if r.Draft || r.Prerelease {
+			continue
+		}
+		v, _, err := parseSemver(r.TagName)
+		if err != nil || !c.allows(v) {
+			continue
+		}
+		if !found || v.compare(bestVer) > 0 {
+			best, bestVer, found = r, v, true
+		}
+	}
+	if !found {
+		return release{}, semver{}, errors.New("no restic release matches restic-version")
+	}
+	return best, bestVer, nil
+}
+
+// rollbackRestic swaps the installed restic binary with the one it replaced.
+func rollbackRestic(resticPath string) error {
+	prev := resticPath + ".previous"
+	if _, err := os.Stat(prev); err != nil {
+		return errors.New("no previous restic binary to roll back to")
+	}
+	tmp := resticPath + ".rollback"
+	if err := os.Rename(resticPath, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(prev, resticPath); err != nil {
+		os.Rename(tmp, resticPath)
+		return err
+	}
+	return os.Rename(tmp, prev)
+}
+
+// downloadRestic installs release rel for the current platform. The archive
+// is checked against the release's SHA256SUMS, whose GPG signature is verified
+// with keyring if one is given. The binary is installed atomically and the one
+// it replaces is kept as restic.previous for rollback.
+func downloadRestic(rel release, binDir, resticPath, keyring string) error {
+	version := strings.TrimPrefix(rel.TagName, "v")
+	ext := ".bz2"
+	if runtime.GOOS == "windows" {
+		ext = ".zip"
+	}
+	targetName := fmt.Sprintf("restic_%s_%s_%s%s", version, runtime.GOOS, runtime.GOARCH, ext)
+	downloadURL := rel.assetURL(targetName)
+	if downloadURL == "" {
+		return fmt.Errorf("asset %s not found", targetName)
+	}
+	sumsURL := rel.assetURL("SHA256SUMS")
+	if sumsURL == "" {
+		return errors.New("release has no SHA256SUMS, refusing to install an unverified binary")
+	}
+	sums, err := downloadWithRetry(sumsURL)
+	if err != nil {
+		return fmt.Errorf("download SHA256SUMS: %w", err)
+	}
+	if keyring != "" {
+		sigURL := rel.assetURL("SHA256SUMS.asc")
+		if sigURL == "" {
+			return errors.New("release has no SHA256SUMS.asc signature")
+		}
+		sig, err := downloadWithRetry(sigURL)
+		if err != nil {
+			return fmt.Errorf("download SHA256SUMS.asc: %w", err)
+		}
+		if err := verifyGPGSignature(keyring, sums, sig); err != nil {
+			return err
+		}
+	}
+	expected, err := checksumFor(sums, targetName)
+	if err != nil {
+		return err
+	}
+	archive, err := downloadWithRetry(downloadURL)
+	if err != nil {
+		return fmt.Errorf("download failed: %w", err)
+	}
+	sum := sha256.Sum256(archive)
+	if got := hex.EncodeToString(sum[:]); got != expected {
+		return fmt.Errorf("checksum mismatch for %s: expected %s, got %s", targetName, expected, got)
+	}
+
+	if err := os.MkdirAll(binDir, 0755); err != nil {
+		return err
+	}
+	var bin io.Reader
+	if ext == ".zip" {
+		z, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+		if err != nil {
+			return err
+		}
+		for _, f := range z.File {
+			if f.Name == "restic.exe" {
+				rc, err := f.Open()
+				if err != nil {
+					return err
+				}
+				defer rc.Close()
+				bin = rc
+				break
+			}
+		}
+		if bin == nil {
+			return fmt.Errorf("restic.exe not found in %s", targetName)
+		}
+	} else {
+		bin = bzip2.NewReader(bytes.NewReader(archive))
+	}
+	if err := keepPrevious(resticPath); err != nil {
+		return err
+	}
+	return installFile(bin, resticPath)
+}
+
+// keepPrevious copies the binary at path to path+".previous".
+func keepPrevious(path string) error {
+	src, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	return installFile(src, path+".previous")
+}
+
+// checksumFor returns the SHA-256 listed for name in a SHA256SUMS file.
+func checksumFor(sums []byte, name string) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(sums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	return "", fmt.Errorf("no checksum for %s in SHA256SUMS", name)
+}
+
+// verifyGPGSignature checks the detached signature sig of data with gpgv
+// against the keys in keyring.
+func verifyGPGSignature(keyring string, data, sig []byte) error {
+	dir, err := os.MkdirTemp("", "restic-verify")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	dataPath := filepath.Join(dir, "SHA256SUMS")
+	sigPath := dataPath + ".asc"
+	if err := os.WriteFile(dataPath, data, 0644); err != nil {
+		return err
+	}
+	if err := os.WriteFile(sigPath, sig, 0644); err != nil {
+		return err
+	}
+	out, err := exec.Command("gpgv", "--keyring", expandUser(keyring), sigPath, dataPath).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("SHA256SUMS signature verification failed: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// installFile writes r to a temporary file next to dst and renames it into
+// place, so dst is never left half written.
+func installFile(r io.Reader, dst string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(dst), filepath.Base(dst)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Chmod(tmpPath, 0755); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, dst); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
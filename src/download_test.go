@@ -0,0 +1,377 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeReleaseServer serves a restic release with the given archive and
+// SHA256SUMS. ASSET in sums is replaced by the archive name.
+func fakeReleaseServer(t *testing.T, archive, sums string, withSig bool) {
+	t.Helper()
+	asset := fmt.Sprintf("restic_1.0.0_%s_%s.bz2", runtime.GOOS, runtime.GOARCH)
+	assets := fmt.Sprintf(`{"name":"%s","browser_download_url":"https://downloads/archive"}`, asset)
+	if sums != "" {
+		sums = strings.ReplaceAll(sums, "ASSET", asset)
+		assets += `,{"name":"SHA256SUMS","browser_download_url":"https://downloads/SHA256SUMS"}`
+	}
+	if withSig {
+		assets += `,{"name":"SHA256SUMS.asc","browser_download_url":"https://downloads/SHA256SUMS.asc"}`
+	}
+	files := map[string]string{
+		releasesURL:                        `[{"tag_name":"v1.0.0","assets":[` + assets + `]}]`,
+		"https://downloads/archive":        archive,
+		"https://downloads/SHA256SUMS":     sums,
+		"https://downloads/SHA256SUMS.asc": "-----BEGIN PGP SIGNATURE-----\nbogus\n-----END PGP SIGNATURE-----\n",
+	}
+	restore := withHTTPClient(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		body, ok := files[req.URL.String()]
+		if !ok {
+			return nil, fmt.Errorf("unexpected URL: %s", req.URL)
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body)), Header: make(http.Header), Request: req}, nil
+	}))
+	t.Cleanup(restore)
+}
+
+// latestRelease returns the first release served by fakeReleaseServer.
+func latestRelease(t *testing.T) release {
+	t.Helper()
+	rels, err := fetchReleases("")
+	if err != nil || len(rels) == 0 {
+		t.Fatalf("fetchReleases: %v %v", rels, err)
+	}
+	return rels[0]
+}
+
+func TestDownloadResticChecksumMismatch(t *testing.T) {
+	fakeReleaseServer(t, "tampered", fmt.Sprintf("%x  ASSET\n", sha256.Sum256([]byte("original"))), false)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "restic")
+	err := downloadRestic(latestRelease(t), dir, path, "")
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Fatalf("expected checksum mismatch, got %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("binary installed despite mismatch")
+	}
+}
+
+func TestDownloadResticWithoutSums(t *testing.T) {
+	fakeReleaseServer(t, "archive", "", false)
+	dir := t.TempDir()
+	if err := downloadRestic(latestRelease(t), dir, filepath.Join(dir, "restic"), ""); err == nil || !strings.Contains(err.Error(), "SHA256SUMS") {
+		t.Fatalf("expected missing SHA256SUMS error, got %v", err)
+	}
+}
+
+func TestDownloadResticBadSignature(t *testing.T) {
+	if _, err := os.Stat("/usr/bin/gpgv"); err != nil {
+		t.Skip("gpgv not installed")
+	}
+	fakeReleaseServer(t, "archive", fmt.Sprintf("%x  ASSET\n", sha256.Sum256([]byte("archive"))), true)
+	dir := t.TempDir()
+	keyring := filepath.Join(dir, "restic.gpg")
+	if err := os.WriteFile(keyring, nil, 0644); err != nil {
+		t.Fatalf("write keyring: %v", err)
+	}
+	err := downloadRestic(latestRelease(t), dir, filepath.Join(dir, "restic"), keyring)
+	if err == nil || !strings.Contains(err.Error(), "signature verification failed") {
+		t.Fatalf("expected signature error, got %v", err)
+	}
+}
+
+func TestChecksumFor(t *testing.T) {
+	sums := []byte("abc123  restic_1_linux_amd64.bz2\nDEF456 *restic_1_windows_amd64.zip\n")
+	if got, err := checksumFor(sums, "restic_1_windows_amd64.zip"); err != nil || got != "def456" {
+		t.Fatalf("unexpected checksum: %q %v", got, err)
+	}
+	if _, err := checksumFor(sums, "missing"); err == nil {
+		t.Fatalf("expected error for missing entry")
+	}
+}
+
+func TestInstallFileReplacesAtomically(t *testing.T) {
+	dir := t.TempDir()
+	dst := filepath.Join(dir, "restic")
+	if err := os.WriteFile(dst, []byte("old"), 0755); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := installFile(strings.NewReader("new"), dst); err != nil {
+		t.Fatalf("installFile: %v", err)
+	}
+	data, _ := os.ReadFile(dst)
+	fi, _ := os.Stat(dst)
+	if string(data) != "new" || fi.Mode().Perm() != 0755 {
+		t.Fatalf("unexpected result: %q %v", data, fi.Mode())
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Fatalf("temporary files left behind: %v", entries)
+	}
+}
+
+func TestSelectRelease(t *testing.T) {
+	rels := []release{
+		{TagName: "v0.18.0"},
+		{TagName: "v0.17.1-rc1", Prerelease: true},
+		{TagName: "v0.16.4"},
+		{TagName: "v0.16.2"},
+		{TagName: "v0.17.0", Draft: true},
+	}
+	c, _ := parseVersionConstraint(">=0.16 <0.18")
+	rel, v, err := selectRelease(rels, c)
+	if err != nil || rel.TagName != "v0.16.4" || v != (semver{0, 16, 4}) {
+		t.Fatalf("unexpected release: %v %v %v", rel.TagName, v, err)
+	}
+	if rel, _, _ := selectRelease(rels, nil); rel.TagName != "v0.18.0" {
+		t.Fatalf("unexpected latest release: %v", rel.TagName)
+	}
+	c, _ = parseVersionConstraint("0.15")
+	if _, _, err := selectRelease(rels, c); err == nil {
+		t.Fatalf("expected error when no release matches")
+	}
+}
+
+// resticVersion returns a restic stand-in body that reports version v.
+func resticVersion(v string) string {
+	return "echo restic " + v + " compiled with go1.22 on linux/amd64"
+}
+
+// resticArchive returns a bzip2 compressed restic stand-in reporting version
+// v. The test is skipped when bzip2 is not installed.
+func resticArchive(t *testing.T, v string) []byte {
+	t.Helper()
+	if _, err := exec.LookPath("bzip2"); err != nil {
+		t.Skip("bzip2 not installed")
+	}
+	cmd := exec.Command("bzip2", "-c")
+	cmd.Stdin = strings.NewReader(resticStandIn("", resticVersion(v)))
+	archive, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("bzip2: %v", err)
+	}
+	return archive
+}
+
+// installResticScript installs a managed restic stand-in reporting version v.
+func installResticScript(t *testing.T, v string) string {
+	t.Helper()
+	return fakeRestic(t, filepath.Dir(managedResticPath()), resticVersion(v))
+}
+
+// TestEnsureResticUpgradeWithinRange installs the newest allowed release,
+// keeps the replaced binary and supports rolling back.
+func TestEnsureResticUpgradeWithinRange(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell scripts")
+	}
+	archive := resticArchive(t, "0.16.4")
+	chdir(t, t.TempDir())
+	path := installResticScript(t, "0.16.1")
+	asset := fmt.Sprintf("restic_0.16.4_%s_%s.bz2", runtime.GOOS, runtime.GOARCH)
+	rels := `[{"tag_name":"v0.18.0","assets":[]},{"tag_name":"v0.16.4","assets":[{"name":"` + asset + `","browser_download_url":"https://downloads/archive"},{"name":"SHA256SUMS","browser_download_url":"https://downloads/SHA256SUMS"}]}]`
+	files := map[string]string{
+		releasesURL:                    rels,
+		"https://downloads/archive":    string(archive),
+		"https://downloads/SHA256SUMS": fmt.Sprintf("%x  %s\n", sha256.Sum256(archive), asset),
+	}
+	requests := 0
+	restore := withHTTPClient(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		requests++
+		body, ok := files[req.URL.String()]
+		if !ok {
+			return nil, fmt.Errorf("unexpected URL: %s", req.URL)
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body)), Header: make(http.Header), Request: req}, nil
+	}))
+	defer restore()
+
+	cfg := config{ResticVersion: ">=0.16 <0.17"}
+	if _, err := ensureRestic(cfg, io.Discard); err != nil {
+		t.Fatalf("ensureRestic: %v", err)
+	}
+	if v, _ := installedResticVersion(path); v != (semver{0, 16, 4}) {
+		t.Fatalf("unexpected installed version: %v", v)
+	}
+	if v, _ := installedResticVersion(path + ".previous"); v != (semver{0, 16, 1}) {
+		t.Fatalf("previous binary not kept: %v", v)
+	}
+	n := requests
+	if _, err := ensureRestic(cfg, io.Discard); err != nil {
+		t.Fatalf("second ensureRestic: %v", err)
+	}
+	if requests != n {
+		t.Fatalf("releases checked again within the check interval")
+	}
+	captureOutput(t)
+	if code := runCLI([]string{"install", "-rollback-restic"}); code != 0 {
+		t.Fatalf("install -rollback-restic exited with %d", code)
+	}
+	if v, _ := installedResticVersion(path); v != (semver{0, 16, 1}) {
+		t.Fatalf("rollback did not restore previous version: %v", v)
+	}
+
+	// the next check keeps the rolled back binary
+	st := loadState()
+	st.LastResticCheck = time.Time{}
+	saveState(st)
+	if _, err := ensureRestic(cfg, io.Discard); err != nil {
+		t.Fatalf("ensureRestic after rollback: %v", err)
+	}
+	if v, _ := installedResticVersion(path); v != (semver{0, 16, 1}) || requests != n {
+		t.Fatalf("rolled back restic upgraded again: %v", v)
+	}
+	// unless restic-version no longer allows it
+	if _, err := ensureRestic(config{ResticVersion: ">=0.16.2 <0.17"}, io.Discard); err != nil {
+		t.Fatalf("ensureRestic with a new range: %v", err)
+	}
+	if v, _ := installedResticVersion(path); v != (semver{0, 16, 4}) || loadState().ResticPin != "" {
+		t.Fatalf("pinned restic kept outside the range: %v", v)
+	}
+}
+
+// fastRetries shortens the download backoff for the duration of the test.
+func fastRetries(t *testing.T) {
+	old := downloadBackoff
+	downloadBackoff = time.Millisecond
+	t.Cleanup(func() { downloadBackoff = old })
+}
+
+// TestEnsureResticMirrorRetries downloads from a mirror that fails twice
+// before answering.
+func TestEnsureResticMirrorRetries(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell scripts")
+	}
+	archive := resticArchive(t, "0.16.4")
+	asset := fmt.Sprintf("restic_0.16.4_%s_%s.bz2", runtime.GOOS, runtime.GOARCH)
+	listed := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/releases.json":
+			listed++
+			if listed < 3 {
+				http.Error(w, "busy", http.StatusServiceUnavailable)
+				return
+			}
+			fmt.Fprintf(w, `[{"tag_name":"v0.16.4","assets":[{"name":%q,"browser_download_url":"https://github.invalid/a"},{"name":"SHA256SUMS","browser_download_url":"https://github.invalid/s"}]}]`, asset)
+		case "/v0.16.4/" + asset:
+			w.Write(archive)
+		case "/v0.16.4/SHA256SUMS":
+			fmt.Fprintf(w, "%x  %s\n", sha256.Sum256(archive), asset)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	fastRetries(t)
+	chdir(t, t.TempDir())
+
+	var out bytes.Buffer
+	path, err := ensureRestic(config{ResticMirror: srv.URL + "/"}, &out)
+	if err != nil {
+		t.Fatalf("ensureRestic: %v", err)
+	}
+	if !strings.Contains(out.String(), "restic not found, downloading 0.16.4...\nrestic 0.16.4 installed to "+path) {
+		t.Fatalf("unexpected output: %q", out.String())
+	}
+	if listed != 3 {
+		t.Fatalf("expected 3 attempts, got %d", listed)
+	}
+	if v, _ := installedResticVersion(path); v != (semver{0, 16, 4}) {
+		t.Fatalf("unexpected installed version: %v", v)
+	}
+}
+
+// TestDownloadWithRetryClientError does not retry requests the server rejects.
+func TestDownloadWithRetryClientError(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+	fastRetries(t)
+	if _, err := downloadWithRetry(srv.URL); err == nil || requests != 1 {
+		t.Fatalf("unexpected result: %v after %d requests", err, requests)
+	}
+}
+
+// offlineMirror returns the URL of a server that is no longer running.
+func offlineMirror(t *testing.T) string {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+	return srv.URL
+}
+
+// TestEnsureResticOfflineKeepsBinary continues with the installed restic when
+// releases cannot be listed, unless it is outside restic-version.
+func TestEnsureResticOfflineKeepsBinary(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell scripts")
+	}
+	fastRetries(t)
+	chdir(t, t.TempDir())
+	path := installResticScript(t, "0.16.1")
+	var out bytes.Buffer
+	got, err := ensureRestic(config{ResticMirror: offlineMirror(t), ResticVersion: ">=0.16"}, &out)
+	if err != nil || got != path {
+		t.Fatalf("unexpected result: %q %v", got, err)
+	}
+	if !strings.Contains(out.String(), "; continuing with restic 0.16.1") {
+		t.Fatalf("fallback not reported: %q", out.String())
+	}
+	if st := loadState(); !st.LastResticCheck.IsZero() {
+		t.Fatalf("failed check recorded: %+v", st)
+	}
+	_, err = ensureRestic(config{ResticMirror: offlineMirror(t), ResticVersion: ">=0.17"}, io.Discard)
+	if err == nil || !strings.Contains(err.Error(), `restic 0.16.1 does not match restic-version ">=0.17"`) {
+		t.Fatalf("expected error for restic outside restic-version, got %v", err)
+	}
+}
+
+// TestEnsureResticOfflineUsesPath falls back to a restic in $PATH when nothing
+// is installed and the download fails.
+func TestEnsureResticOfflineUsesPath(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell scripts")
+	}
+	fastRetries(t)
+	chdir(t, t.TempDir())
+	mirror := offlineMirror(t)
+	binDir := t.TempDir()
+	t.Setenv("PATH", binDir)
+	if _, err := ensureRestic(config{ResticMirror: mirror}, io.Discard); err == nil {
+		t.Fatalf("expected error without any restic")
+	}
+	want := fakeRestic(t, binDir, resticVersion("0.16.0"))
+	if got, err := ensureRestic(config{ResticMirror: mirror}, io.Discard); err != nil || got != want {
+		t.Fatalf("unexpected result: %q %v", got, err)
+	}
+}
+
+// TestEnsureResticConfiguredPath uses restic-path without any network access.
+func TestEnsureResticConfiguredPath(t *testing.T) {
+	chdir(t, t.TempDir())
+	dir := t.TempDir()
+	cfg := config{ResticPath: filepath.Join(dir, "restic"), ResticMirror: offlineMirror(t)}
+	if _, err := ensureRestic(cfg, io.Discard); err == nil {
+		t.Fatalf("expected error for missing restic-path")
+	}
+	want := fakeRestic(t, dir, resticVersion("0.16.0"))
+	if got, err := ensureRestic(cfg, io.Discard); err != nil || got != want {
+		t.Fatalf("unexpected result: %q %v", got, err)
+	}
+}
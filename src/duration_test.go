@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDuration(t *testing.T) {
+	cases := map[string]time.Duration{
+		"90m": 90 * time.Minute,
+		"7d":  7 * 24 * time.Hour,
+		"2w":  14 * 24 * time.Hour,
+		"0":   0,
+	}
+	for s, exp := range cases {
+		got, err := parseDuration(s)
+		if err != nil || got != exp {
+			t.Errorf("parseDuration(%q) = %v, %v; want %v", s, got, err, exp)
+		}
+	}
+	for _, s := range []string{"", "d", "-1d", "soon"} {
+		if _, err := parseDuration(s); err == nil {
+			t.Errorf("parseDuration(%q) succeeded", s)
+		}
+	}
+}
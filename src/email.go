@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// smtpSendMail delivers a message. Tests replace it.
+var smtpSendMail = sendMail
+
+// smtpRootCAs verifies the certificate of the mail server. Nil uses the
+// system roots; tests trust their own certificate.
+var smtpRootCAs *x509.CertPool
+
+// Values of email-tls. Without a value, port 465 uses implicit TLS and other
+// ports upgrade with STARTTLS when the server offers it.
+const (
+	emailSTARTTLS = "starttls"
+	emailTLS      = "tls"
+	emailNoTLS    = "none"
+)
+
+// emailNotifier sends notifications by SMTP.
+type emailNotifier struct {
+	server, user, password, from, to, cc, security string
+}
+
+func newEmailNotifiers(cfg config) []Notifier {
+	if cfg.EmailServer == "" || cfg.EmailUser == "" || cfg.EmailPassword == "" || cfg.EmailFrom == "" || cfg.EmailTo == "" {
+		return nil
+	}
+	return []Notifier{emailNotifier{
+		server:   cfg.EmailServer,
+		user:     cfg.EmailUser,
+		password: cfg.EmailPassword,
+		from:     cfg.EmailFrom,
+		to:       cfg.EmailTo,
+		cc:       cfg.EmailCC,
+		security: cfg.EmailTLS,
+	}}
+}
+
+func (e emailNotifier) Name() string { return "email" }
+
+func (e emailNotifier) Notify(ctx context.Context, n notification) error {
+	from, err := mail.ParseAddress(e.from)
+	if err != nil {
+		return fmt.Errorf("email: email-from: %w", err)
+	}
+	to, err := mail.ParseAddressList(e.to)
+	if err != nil {
+		return fmt.Errorf("email: email-to: %w", err)
+	}
+	var cc []*mail.Address
+	if strings.TrimSpace(e.cc) != "" {
+		if cc, err = mail.ParseAddressList(e.cc); err != nil {
+			return fmt.Errorf("email: email-cc: %w", err)
+		}
+	}
+	security := e.security
+	switch security {
+	case "", emailSTARTTLS, emailTLS, emailNoTLS:
+	default:
+		return fmt.Errorf("email: unknown email-tls %q, expected %s, %s or %s", security, emailSTARTTLS, emailTLS, emailNoTLS)
+	}
+	addr := e.server
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		port := "587"
+		if security == emailTLS {
+			port = "465"
+		}
+		addr = net.JoinHostPort(addr, port)
+	}
+	host, port, _ := net.SplitHostPort(addr)
+	if security == "" && port == "465" {
+		security = emailTLS
+	}
+	msg, err := buildEmail(from, to, cc, n, time.Now())
+	if err != nil {
+		return fmt.Errorf("email: %w", err)
+	}
+	var rcpts []string
+	for _, a := range append(to, cc...) {
+		rcpts = append(rcpts, a.Address)
+	}
+	auth := smtp.PlainAuth("", e.user, e.password, host)
+	if err := smtpSendMail(ctx, addr, security, auth, from.Address, rcpts, msg); err != nil {
+		return fmt.Errorf("email: %w", err)
+	}
+	return nil
+}
+
+// sendMail delivers msg through the SMTP server at addr. security selects
+// implicit TLS, STARTTLS or a plain connection as described for email-tls.
+// Like smtp.SendMail it only authenticates when the server supports it.
+func sendMail(ctx context.Context, addr, security string, a smtp.Auth, from string, to []string, msg []byte) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	tlsConfig := &tls.Config{ServerName: host, RootCAs: smtpRootCAs}
+	var conn net.Conn
+	if security == emailTLS {
+		conn, err = (&tls.Dialer{Config: tlsConfig}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if security != emailTLS && security != emailNoTLS {
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			if err := c.StartTLS(tlsConfig); err != nil {
+				return err
+			}
+		} else if security == emailSTARTTLS {
+			return errors.New("server does not support STARTTLS")
+		}
+	}
+	if ok, _ := c.Extension("AUTH"); ok && a != nil {
+		if err := c.Auth(a); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	for _, rcpt := range to {
+		if err := c.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// buildEmail returns an RFC 5322 message with a plain text and an HTML
+// version of n.
+func buildEmail(from *mail.Address, to, cc []*mail.Address, n notification, now time.Time) ([]byte, error) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for _, part := range []struct {
+		contentType string
+		render      func(*bytes.Buffer) error
+	}{
+		{"text/plain", func(b *bytes.Buffer) error { _, err := b.WriteString(n.Body + "\n"); return err }},
+		{"text/html", func(b *bytes.Buffer) error { return emailHTML.Execute(b, emailView(n)) }},
+	} {
+		var content bytes.Buffer
+		if err := part.render(&content); err != nil {
+			return nil, err
+		}
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType + "; charset=utf-8"},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		// the writer also turns line breaks into CRLF
+		qp := quotedprintable.NewWriter(w)
+		if _, err := qp.Write(content.Bytes()); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	var msg bytes.Buffer
+	header := func(name, value string) { msg.WriteString(name + ": " + value + "\r\n") }
+	header("From", from.String())
+	header("To", joinAddresses(to))
+	if len(cc) > 0 {
+		header("Cc", joinAddresses(cc))
+	}
+	header("Subject", mime.QEncoding.Encode("utf-8", n.Subject))
+	header("Date", now.Format(time.RFC1123Z))
+	header("Message-ID", messageID(from.Address))
+	header("MIME-Version", "1.0")
+	header("Content-Type", `multipart/alternative; boundary="`+mw.Boundary()+`"`)
+	// mark the message as sent by a program so mail servers do not answer
+	// it with vacation replies
+	header("Auto-Submitted", "auto-generated")
+	if n.Priority > 0 {
+		header("X-Priority", "1")
+		header("Importance", "high")
+	}
+	msg.WriteString("\r\n")
+	msg.Write(body.Bytes())
+	return msg.Bytes(), nil
+}
+
+// joinAddresses formats addresses for a To or Cc header.
+func joinAddresses(addrs []*mail.Address) string {
+	s := make([]string, len(addrs))
+	for i, a := range addrs {
+		s[i] = a.String()
+	}
+	return strings.Join(s, ", ")
+}
+
+// messageID returns a unique Message-ID in the domain of the sender.
+func messageID(from string) string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	domain := "localhost"
+	if i := strings.LastIndex(from, "@"); i >= 0 && i < len(from)-1 {
+		domain = from[i+1:]
+	}
+	return "<" + hex.EncodeToString(b) + "@" + domain + ">"
+}
+
+// emailRow is a row of the HTML report. A row without a value starts a
+// section such as a job of the health report.
+type emailRow struct {
+	Key, Value string
+	Indent     int
+}
+
+// emailReport is rendered by emailHTML.
+type emailReport struct {
+	Subject string
+	Status  string
+	// Run describes the run the notification reports on, Report holds the
+	// lines of the message body.
+	Run    []emailRow
+	Report []emailRow
+}
+
+// emailView prepares n for emailHTML.
+func emailView(n notification) emailReport {
+	r := emailReport{Subject: n.Subject, Status: n.Status}
+	add := func(key, value string) {
+		if value != "" {
+			r.Run = append(r.Run, emailRow{Key: key, Value: value})
+		}
+	}
+	add("status", n.Status)
+	add("host", n.Host)
+	add("job", n.Job)
+	if !n.Start.IsZero() {
+		add("started", n.Start.Local().Format("2006-01-02 15:04:05"))
+	}
+	if n.Duration > 0 {
+		add("duration", n.Duration.Round(time.Second).String())
+	}
+	if n.FilesNew > 0 || n.FilesChanged > 0 || n.BytesProcessed > 0 {
+		add("files", fmt.Sprintf("%d new, %d changed", n.FilesNew, n.FilesChanged))
+		add("data added", formatBytes(n.BytesAdded))
+		add("data processed", formatBytes(n.BytesProcessed))
+	}
+	add("error", n.Error)
+	r.Report = reportRows(n.Body)
+	return r
+}
+
+// reportRows splits a report such as the health report into rows. Lines of
+// the form "key: value" become a key and a value, a line ending in a colon
+// starts a section and other lines are kept as values. Indented lines keep
+// their depth.
+func reportRows(body string) []emailRow {
+	var rows []emailRow
+	for _, line := range strings.Split(strings.TrimRight(body, "\n"), "\n") {
+		trimmed := strings.TrimLeft(line, " ")
+		row := emailRow{Indent: (len(line) - len(trimmed)) / 2}
+		trimmed = strings.TrimRight(trimmed, " \r")
+		switch key, value, ok := strings.Cut(trimmed, ": "); {
+		case strings.HasSuffix(trimmed, ":") && !strings.HasPrefix(trimmed, "- "):
+			row.Key = strings.TrimSuffix(trimmed, ":")
+		case ok && !strings.HasPrefix(trimmed, "- ") && !strings.ContainsAny(key, `"{}[]`):
+			row.Key, row.Value = key, value
+		default:
+			row.Value = trimmed
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// emailHTML renders a notification as an HTML table. The styles are inline
+// since many mail clients ignore style sheets.
+var emailHTML = template.Must(template.New("email").Funcs(template.FuncMap{
+	"pad": func(indent int) template.CSS {
+		return template.CSS(fmt.Sprintf("padding:2px 12px 2px %dpx", 8+16*indent))
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<body style="font-family:sans-serif;font-size:14px;color:#222">
+<h2 style="font-size:18px;color:{{if eq .Status "failure"}}#b00020{{else}}#222{{end}}">{{.Subject}}</h2>
+{{- if .Run}}
+<table style="border-collapse:collapse;margin-bottom:16px">
+{{- range .Run}}
+<tr><th style="text-align:left;padding:2px 12px 2px 8px;background:#f2f2f2">{{.Key}}</th><td style="padding:2px 12px">{{.Value}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+{{- if .Report}}
+<table style="border-collapse:collapse">
+{{- range .Report}}
+{{- if and .Key (not .Value)}}
+<tr><th colspan="2" style="text-align:left;{{pad .Indent}};background:#e8e8e8">{{.Key}}</th></tr>
+{{- else if .Key}}
+<tr><td style="{{pad .Indent}};color:#555">{{.Key}}</td><td style="padding:2px 12px">{{.Value}}</td></tr>
+{{- else}}
+<tr><td colspan="2" style="{{pad .Indent}};font-family:monospace">{{.Value}}</td></tr>
+{{- end}}
+{{- end}}
+</table>
+{{- end}}
+</body>
+</html>
+`))
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"io"
+	"math/big"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testCertificate returns a self-signed certificate for 127.0.0.1 and a pool
+// trusting it.
+func testCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+// smtpStandIn is an in-process SMTP server accepting a single message.
+type smtpStandIn struct {
+	addr     string
+	starttls bool
+	config   *tls.Config
+
+	mu sync.Mutex
+	// encrypted reports whether the message arrived over TLS.
+	encrypted bool
+	auth      string
+	from      string
+	rcpts     []string
+	data      string
+}
+
+// startSMTP starts a stand-in that offers STARTTLS when starttls is set and
+// speaks TLS from the first byte when implicit is set. The client is made to
+// trust its certificate.
+func startSMTP(t *testing.T, starttls, implicit bool) *smtpStandIn {
+	t.Helper()
+	cert, pool := testCertificate(t)
+	old := smtpRootCAs
+	smtpRootCAs = pool
+	t.Cleanup(func() { smtpRootCAs = old })
+	s := &smtpStandIn{starttls: starttls, config: &tls.Config{Certificates: []tls.Certificate{cert}}}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	if implicit {
+		ln = tls.NewListener(ln, s.config)
+	}
+	t.Cleanup(func() { ln.Close() })
+	s.addr = ln.Addr().String()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		s.serve(conn)
+	}()
+	return s
+}
+
+func (s *smtpStandIn) serve(conn net.Conn) {
+	_, encrypted := conn.(*tls.Conn)
+	tp := textproto.NewConn(conn)
+	tp.PrintfLine("220 stand-in ESMTP")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		s.mu.Lock()
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			tp.PrintfLine("250-stand-in")
+			if s.starttls && !encrypted {
+				tp.PrintfLine("250-STARTTLS")
+			}
+			tp.PrintfLine("250 AUTH PLAIN")
+		case "STARTTLS":
+			tp.PrintfLine("220 ready")
+			tc := tls.Server(conn, s.config)
+			if tc.Handshake() != nil {
+				s.mu.Unlock()
+				return
+			}
+			conn, tp, encrypted = tc, textproto.NewConn(tc), true
+		case "AUTH":
+			_, resp, _ := strings.Cut(arg, " ")
+			dec, _ := base64.StdEncoding.DecodeString(resp)
+			s.auth = string(dec)
+			tp.PrintfLine("235 accepted")
+		case "MAIL":
+			s.from = arg
+			tp.PrintfLine("250 ok")
+		case "RCPT":
+			s.rcpts = append(s.rcpts, arg)
+			tp.PrintfLine("250 ok")
+		case "DATA":
+			tp.PrintfLine("354 go ahead")
+			data, _ := tp.ReadDotBytes()
+			s.data, s.encrypted = string(data), encrypted
+			tp.PrintfLine("250 queued")
+		case "QUIT":
+			tp.PrintfLine("221 bye")
+			s.mu.Unlock()
+			return
+		default:
+			tp.PrintfLine("502 not implemented")
+		}
+		s.mu.Unlock()
+	}
+}
+
+func TestBuildEmail(t *testing.T) {
+	from := &mail.Address{Name: "Backup", Address: "backup@example.com"}
+	to := []*mail.Address{{Address: "anna@example.com"}, {Name: "Jörg", Address: "joerg@example.com"}}
+	cc := []*mail.Address{{Address: "admin@example.com"}}
+	n := notification{
+		Subject:  "Sicherung fehlgeschlagen ✗",
+		Body:     "health report:\nrestic available: yes\njob docs:\n  repository reachable: no (<timeout>)\n  paths to backup:\n   - /home/anna\n",
+		Status:   "failure",
+		Host:     "laptop",
+		Job:      "docs",
+		Error:    "disk <full>",
+		Priority: 1,
+	}
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	data, err := buildEmail(from, to, cc, n, now)
+	if err != nil {
+		t.Fatalf("buildEmail: %v", err)
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("not a valid message: %v", err)
+	}
+	h := msg.Header
+	if h.Get("From") != `"Backup" <backup@example.com>` || h.Get("Cc") != "<admin@example.com>" || h.Get("MIME-Version") != "1.0" || h.Get("Importance") != "high" {
+		t.Fatalf("unexpected headers: %v", h)
+	}
+	if got, _ := h.AddressList("To"); len(got) != 2 || got[1].Name != "Jörg" {
+		t.Fatalf("unexpected To: %v", h.Get("To"))
+	}
+	if got, _ := h.Date(); !got.Equal(now) {
+		t.Fatalf("unexpected Date: %s", h.Get("Date"))
+	}
+	if id := h.Get("Message-ID"); !strings.HasPrefix(id, "<") || !strings.HasSuffix(id, "@example.com>") {
+		t.Fatalf("unexpected Message-ID: %s", id)
+	}
+	if raw := h.Get("Subject"); !strings.HasPrefix(raw, "=?utf-8?q?") {
+		t.Fatalf("subject not encoded: %s", raw)
+	}
+	if got, _ := new(mime.WordDecoder).DecodeHeader(h.Get("Subject")); got != n.Subject {
+		t.Fatalf("unexpected subject %q", got)
+	}
+
+	mediaType, params, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	if mediaType != "multipart/alternative" {
+		t.Fatalf("unexpected content type %s", h.Get("Content-Type"))
+	}
+	parts := map[string]string{}
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("read part: %v", err)
+		}
+		// the reader decodes quoted-printable parts
+		body, _ := io.ReadAll(p)
+		ct, _, _ := mime.ParseMediaType(p.Header.Get("Content-Type"))
+		parts[ct] = string(body)
+	}
+	if !strings.Contains(parts["text/plain"], "repository reachable: no (<timeout>)\r\n") {
+		t.Fatalf("unexpected text part: %q", parts["text/plain"])
+	}
+	html := parts["text/html"]
+	for _, want := range []string{
+		"<table",
+		`<td style="padding:2px 12px">disk &lt;full&gt;</td>`,
+		`>job docs</th>`,
+		`padding:2px 12px 2px 24px;color:#555">repository reachable</td><td style="padding:2px 12px">no (&lt;timeout&gt;)</td>`,
+		`>- /home/anna</td>`,
+	} {
+		if !strings.Contains(html, want) {
+			t.Errorf("HTML part missing %q:\n%s", want, html)
+		}
+	}
+}
+
+func TestReportRows(t *testing.T) {
+	rows := reportRows("restic version: 0.17.0\npastebin content:\n{\n  \"a\": \"b\"\n}\njob docs:\n  largest directories:\n   - /home/x: 2 GB\n")
+	want := []emailRow{
+		{Key: "restic version", Value: "0.17.0"},
+		{Key: "pastebin content"},
+		{Value: "{"},
+		{Value: `"a": "b"`, Indent: 1},
+		{Value: "}"},
+		{Key: "job docs"},
+		{Key: "largest directories", Indent: 1},
+		{Value: "- /home/x: 2 GB", Indent: 1},
+	}
+	if len(rows) != len(want) {
+		t.Fatalf("got %d rows: %+v", len(rows), rows)
+	}
+	for i := range want {
+		if rows[i] != want[i] {
+			t.Errorf("row %d = %+v, want %+v", i, rows[i], want[i])
+		}
+	}
+}
+
+func TestEmailDelivery(t *testing.T) {
+	for _, tc := range []struct {
+		name               string
+		starttls, implicit bool
+		security           string
+		encrypted          bool
+		err                string
+	}{
+		{name: "starttls", starttls: true, encrypted: true},
+		{name: "implicit tls", implicit: true, security: "tls", encrypted: true},
+		{name: "plain", security: ""},
+		{name: "starttls required", security: "starttls", err: "server does not support STARTTLS"},
+		{name: "no tls", starttls: true, security: "none"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := startSMTP(t, tc.starttls, tc.implicit)
+			e := emailNotifier{
+				server:   s.addr,
+				user:     "mailer",
+				password: "mail-secret",
+				from:     "Backup <backup@example.com>",
+				to:       "anna@example.com, Jörg <joerg@example.com>",
+				cc:       "admin@example.com",
+				security: tc.security,
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			err := e.Notify(ctx, notification{Subject: "backup succeeded", Body: "backed up 2 GB"})
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Notify: %v", err)
+			}
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if s.encrypted != tc.encrypted || s.auth != "\x00mailer\x00mail-secret" || s.from != "FROM:<backup@example.com>" {
+				t.Fatalf("unexpected session: tls %v auth %q from %q", s.encrypted, s.auth, s.from)
+			}
+			if got := strings.Join(s.rcpts, " "); got != "TO:<anna@example.com> TO:<joerg@example.com> TO:<admin@example.com>" {
+				t.Fatalf("unexpected recipients: %s", got)
+			}
+			// ReadDotBytes turns CRLF into LF
+			if !strings.Contains(s.data, "Subject: backup succeeded\n") || !strings.Contains(s.data, "backed up 2 GB") {
+				t.Fatalf("unexpected message: %q", s.data)
+			}
+		})
+	}
+}
+
+func TestEmailConfigErrors(t *testing.T) {
+	base := emailNotifier{server: "127.0.0.1:1", user: "u", password: "p", from: "backup@example.com", to: "anna@example.com"}
+	for _, tc := range []struct {
+		change func(*emailNotifier)
+		want   string
+	}{
+		{func(e *emailNotifier) { e.from = "not an address" }, "email-from"},
+		{func(e *emailNotifier) { e.to = "anna@example.com, @" }, "email-to"},
+		{func(e *emailNotifier) { e.cc = "<" }, "email-cc"},
+		{func(e *emailNotifier) { e.security = "ssl" }, `unknown email-tls "ssl"`},
+	} {
+		e := base
+		tc.change(&e)
+		if err := e.Notify(context.Background(), notification{}); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("expected error containing %q, got %v", tc.want, err)
+		}
+	}
+}
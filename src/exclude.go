@@ -0,0 +1,320 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"backup/internal/restic"
+)
+
+// exclusions selects the files left out of a backup. It is embedded in config
+// and job, so its fields appear at the top level of both in config.json.
+type exclusions struct {
+	// Exclude holds restic patterns such as "*.tmp" or "node_modules".
+	Exclude []string `json:"exclude,omitempty"`
+	// ExcludeFile names files with one pattern per line.
+	ExcludeFile []string `json:"exclude-file,omitempty"`
+	// ExcludeIfPresent skips directories containing a file with one of
+	// these names, such as ".nobackup".
+	ExcludeIfPresent []string `json:"exclude-if-present,omitempty"`
+	// ExcludeLargerThan skips files larger than a size such as "2G".
+	ExcludeLargerThan string `json:"exclude-larger-than,omitempty"`
+	// ExcludeCaches skips directories tagged with a CACHEDIR.TAG file.
+	ExcludeCaches bool `json:"exclude-caches,omitempty"`
+	// ExcludePresets enables built-in exclusion lists by name, see
+	// excludePresets.
+	ExcludePresets []string `json:"exclude-presets,omitempty"`
+}
+
+// excludePreset is a curated list of patterns for a common kind of backup.
+type excludePreset struct {
+	// patterns are matched anywhere below the backed up paths.
+	patterns []string
+	// caseInsensitive passes the patterns with --iexclude for file systems
+	// that ignore case.
+	caseInsensitive bool
+}
+
+// excludePresets holds the built-in exclusion lists. They leave out caches,
+// the trash, package and build caches and virtual machine images, which are
+// large, change often and can be recreated. Every preset also enables
+// exclude-caches.
+var excludePresets = map[string]excludePreset{
+	"linux-home": {patterns: []string{
+		".cache",
+		".local/share/Trash",
+		".thumbnails",
+		".npm/_cacache",
+		".cargo/registry",
+		"go/pkg/mod",
+		".gradle/caches",
+		".m2/repository",
+		".local/share/containers",
+		".local/share/gnome-boxes/images",
+		".local/share/libvirt/images",
+		".var/app/*/cache",
+		"node_modules",
+		"__pycache__",
+		"VirtualBox VMs",
+		"*.qcow2",
+		"*.vdi",
+		"*.vmdk",
+	}},
+	"macos-home": {caseInsensitive: true, patterns: []string{
+		".Trash",
+		"Library/Caches",
+		"Library/Logs",
+		"Library/Containers/com.docker.docker",
+		"Library/Developer/Xcode/DerivedData",
+		"Library/Developer/CoreSimulator",
+		".cache",
+		".npm/_cacache",
+		"go/pkg/mod",
+		"node_modules",
+		"__pycache__",
+		"Parallels",
+		"Virtual Machines.localized",
+		"VirtualBox VMs",
+		"*.vdi",
+		"*.vmdk",
+		".DS_Store",
+	}},
+	"windows-profile": {caseInsensitive: true, patterns: []string{
+		"AppData/Local/Temp",
+		"AppData/Local/CrashDumps",
+		"AppData/Local/Microsoft/Windows/INetCache",
+		"AppData/Local/Microsoft/Windows/Explorer/thumbcache_*.db",
+		"AppData/Local/Google/Chrome/User Data/*/Cache",
+		"AppData/Local/Microsoft/Edge/User Data/*/Cache",
+		"AppData/Local/Mozilla/Firefox/Profiles/*/cache2",
+		"AppData/Local/Packages/*/AC/INetCache",
+		"AppData/Local/Docker",
+		"NTUSER.DAT*",
+		"node_modules",
+		"__pycache__",
+		"VirtualBox VMs",
+		"*.vhdx",
+		"*.vdi",
+		"*.vmdk",
+	}},
+}
+
+// presetNames returns the names of the built-in presets in order.
+func presetNames() []string {
+	names := make([]string, 0, len(excludePresets))
+	for name := range excludePresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// sizePattern matches the sizes restic accepts for --exclude-larger-than.
+var sizePattern = regexp.MustCompile(`^[0-9]+[kKmMgGtT]?$`)
+
+// isZero reports whether no exclusion is configured.
+func (e exclusions) isZero() bool {
+	return len(e.Exclude) == 0 && len(e.ExcludeFile) == 0 && len(e.ExcludeIfPresent) == 0 &&
+		e.ExcludeLargerThan == "" && !e.ExcludeCaches && len(e.ExcludePresets) == 0
+}
+
+// apply adds the exclusions, including those of the enabled presets, to opts.
+// It fails for an unknown preset or an invalid size.
+func (e exclusions) apply(opts *restic.BackupOptions) error {
+	if e.ExcludeLargerThan != "" && !sizePattern.MatchString(e.ExcludeLargerThan) {
+		return fmt.Errorf("exclude-larger-than %q: expected a size such as 500M or 2G", e.ExcludeLargerThan)
+	}
+	opts.Excludes = append(opts.Excludes, e.Exclude...)
+	for _, f := range e.ExcludeFile {
+		opts.ExcludeFiles = append(opts.ExcludeFiles, expandUser(f))
+	}
+	opts.ExcludeIfPresent = append(opts.ExcludeIfPresent, e.ExcludeIfPresent...)
+	opts.ExcludeLargerThan = e.ExcludeLargerThan
+	opts.ExcludeCaches = e.ExcludeCaches
+	for _, name := range e.ExcludePresets {
+		p, ok := excludePresets[name]
+		if !ok {
+			return fmt.Errorf("unknown exclude preset %q, available presets: %s", name, strings.Join(presetNames(), ", "))
+		}
+		if p.caseInsensitive {
+			opts.IExcludes = append(opts.IExcludes, p.patterns...)
+		} else {
+			opts.Excludes = append(opts.Excludes, p.patterns...)
+		}
+		opts.ExcludeCaches = true
+	}
+	return nil
+}
+
+// summary describes the exclusions in one line for the health report.
+func (e exclusions) summary() string {
+	var parts []string
+	if len(e.ExcludePresets) > 0 {
+		parts = append(parts, "presets "+strings.Join(e.ExcludePresets, ", "))
+	}
+	if n := len(e.Exclude); n > 0 {
+		parts = append(parts, fmt.Sprintf("%d patterns", n))
+	}
+	if len(e.ExcludeFile) > 0 {
+		parts = append(parts, "files "+strings.Join(e.ExcludeFile, ", "))
+	}
+	if len(e.ExcludeIfPresent) > 0 {
+		parts = append(parts, "directories containing "+strings.Join(e.ExcludeIfPresent, ", "))
+	}
+	if e.ExcludeLargerThan != "" {
+		parts = append(parts, "files larger than "+e.ExcludeLargerThan)
+	}
+	if e.ExcludeCaches {
+		parts = append(parts, "cache directories")
+	}
+	if len(parts) == 0 {
+		return "none"
+	}
+	return strings.Join(parts, "; ")
+}
+
+// cacheDirSignature starts a CACHEDIR.TAG file, see
+// https://bford.info/cachedir/.
+const cacheDirSignature = "Signature: 8a477f597d28d172789f06886806bc55"
+
+// excludeMatcher approximates restic's exclude rules so the pre-flight scan
+// can estimate the size of a backup. Patterns support *, ? and ** like restic;
+// a pattern without a leading / matches at any depth.
+type excludeMatcher struct {
+	patterns  [][]string
+	ipatterns [][]string
+	ifPresent []string
+	caches    bool
+	maxSize   int64
+}
+
+// matcher returns a matcher for the exclusions. An unknown preset or an
+// unreadable exclude file is returned as an error together with a matcher
+// for the remaining rules.
+func (e exclusions) matcher() (*excludeMatcher, error) {
+	var opts restic.BackupOptions
+	err := e.apply(&opts)
+	m := &excludeMatcher{caches: opts.ExcludeCaches}
+	for _, name := range opts.ExcludeIfPresent {
+		// restic accepts name:header; only the name is checked here
+		if i := strings.Index(name, ":"); i > 0 {
+			name = name[:i]
+		}
+		m.ifPresent = append(m.ifPresent, name)
+	}
+	if opts.ExcludeLargerThan != "" {
+		m.maxSize = parseSize(opts.ExcludeLargerThan)
+	}
+	patterns := opts.Excludes
+	for _, f := range opts.ExcludeFiles {
+		data, ferr := os.ReadFile(f)
+		if ferr != nil {
+			err = errors.Join(err, fmt.Errorf("exclude-file: %w", ferr))
+			continue
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+			if line != "" && !strings.HasPrefix(line, "#") {
+				patterns = append(patterns, os.ExpandEnv(line))
+			}
+		}
+	}
+	for _, p := range patterns {
+		m.patterns = append(m.patterns, splitPattern(p))
+	}
+	for _, p := range opts.IExcludes {
+		m.ipatterns = append(m.ipatterns, splitPattern(strings.ToLower(p)))
+	}
+	return m, err
+}
+
+// splitPattern splits a pattern into path components. A pattern that is not
+// anchored at the root is prefixed with ** so it matches at any depth.
+func splitPattern(p string) []string {
+	p = filepath.ToSlash(p)
+	if strings.HasPrefix(p, "/") {
+		return strings.Split(strings.Trim(p, "/"), "/")
+	}
+	return append([]string{"**"}, strings.Split(strings.Trim(p, "/"), "/")...)
+}
+
+// parseSize converts a size such as "500M" or "2G" to bytes. Like restic it
+// counts in powers of 1024.
+func parseSize(s string) int64 {
+	mult := int64(1)
+	if i := strings.IndexAny(s, "kKmMgGtT"); i > 0 {
+		mult = 1 << (10 * (strings.IndexByte("kmgt", byte(unicode.ToLower(rune(s[i])))) + 1))
+		s = s[:i]
+	}
+	n, _ := strconv.ParseInt(s, 10, 64)
+	return n * mult
+}
+
+// excluded reports whether the file or directory at path is left out.
+func (m *excludeMatcher) excluded(path string, d fs.DirEntry) bool {
+	parts := strings.Split(strings.Trim(filepath.ToSlash(path), "/"), "/")
+	for _, p := range m.patterns {
+		if matchParts(p, parts) {
+			return true
+		}
+	}
+	if len(m.ipatterns) > 0 {
+		lower := strings.Split(strings.ToLower(strings.Join(parts, "/")), "/")
+		for _, p := range m.ipatterns {
+			if matchParts(p, lower) {
+				return true
+			}
+		}
+	}
+	if !d.IsDir() {
+		return false
+	}
+	for _, name := range m.ifPresent {
+		if _, err := os.Lstat(filepath.Join(path, name)); err == nil {
+			return true
+		}
+	}
+	if m.caches {
+		if data, err := os.ReadFile(filepath.Join(path, "CACHEDIR.TAG")); err == nil && strings.HasPrefix(string(data), cacheDirSignature) {
+			return true
+		}
+	}
+	return false
+}
+
+// tooLarge reports whether a file of size bytes exceeds exclude-larger-than.
+func (m *excludeMatcher) tooLarge(size int64) bool {
+	return m.maxSize > 0 && size > m.maxSize
+}
+
+// matchParts matches path components against pattern components, where **
+// matches any number of components.
+func matchParts(pattern, parts []string) bool {
+	if len(pattern) == 0 {
+		return len(parts) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(parts); i++ {
+			if matchParts(pattern[1:], parts[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(parts) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(pattern[0], parts[0]); !ok {
+		return false
+	}
+	return matchParts(pattern[1:], parts[1:])
+}
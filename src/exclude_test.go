@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"backup/internal/restic"
+)
+
+func TestExclusionsApply(t *testing.T) {
+	e := exclusions{
+		Exclude:           []string{"*.iso"},
+		ExcludeFile:       []string{"/etc/backup-excludes"},
+		ExcludeIfPresent:  []string{".nobackup"},
+		ExcludeLargerThan: "4G",
+		ExcludePresets:    []string{"linux-home", "windows-profile"},
+	}
+	var opts restic.BackupOptions
+	if err := e.apply(&opts); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	excludes := strings.Join(opts.Excludes, " ")
+	if opts.Excludes[0] != "*.iso" || !strings.Contains(excludes, "node_modules") || !strings.Contains(excludes, ".local/share/Trash") {
+		t.Fatalf("unexpected excludes: %v", opts.Excludes)
+	}
+	if !strings.Contains(strings.Join(opts.IExcludes, " "), "AppData/Local/Temp") {
+		t.Fatalf("unexpected case-insensitive excludes: %v", opts.IExcludes)
+	}
+	if opts.ExcludeFiles[0] != "/etc/backup-excludes" || opts.ExcludeIfPresent[0] != ".nobackup" || opts.ExcludeLargerThan != "4G" || !opts.ExcludeCaches {
+		t.Fatalf("unexpected options: %+v", opts)
+	}
+}
+
+func TestExclusionsInvalid(t *testing.T) {
+	var opts restic.BackupOptions
+	err := exclusions{ExcludePresets: []string{"linux"}}.apply(&opts)
+	if err == nil || !strings.Contains(err.Error(), "linux-home, macos-home, windows-profile") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := (exclusions{ExcludeLargerThan: "2 GB"}).apply(&opts); err == nil {
+		t.Fatalf("expected error for invalid size")
+	}
+}
+
+// TestExclusionsConfig reads the exclude options at the top level of
+// config.json and in jobs.
+func TestExclusionsConfig(t *testing.T) {
+	data := `{"repo": "/r", "exclude": ["*.tmp"], "exclude-caches": true,
+		"jobs": [{"name": "home", "repo": "/h", "paths": ["~"], "exclude-presets": ["macos-home"], "exclude-larger-than": "1G"}]}`
+	var cfg config
+	if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if cfg.Exclude[0] != "*.tmp" || !cfg.ExcludeCaches {
+		t.Fatalf("unexpected top-level exclusions: %+v", cfg.exclusions)
+	}
+	jc := cfg.jobs()[0]
+	if jc.ExcludePresets[0] != "macos-home" || jc.ExcludeLargerThan != "1G" || len(jc.Exclude) != 0 {
+		t.Fatalf("unexpected job exclusions: %+v", jc.exclusions)
+	}
+	if got := jc.exclusions.summary(); got != "presets macos-home; files larger than 1G" {
+		t.Fatalf("unexpected summary: %q", got)
+	}
+	out, _ := json.Marshal(cfg)
+	if !strings.Contains(string(out), `"exclude":["*.tmp"],"exclude-caches":true`) {
+		t.Fatalf("exclusions not flattened: %s", out)
+	}
+}
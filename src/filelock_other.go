@@ -0,0 +1,11 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package main
+
+import "os"
+
+// lockFile does nothing on this platform; concurrent runs may lose updates.
+func lockFile(f *os.File) error { return nil }
+
+// unlockFile does nothing on this platform.
+func unlockFile(f *os.File) error { return nil }
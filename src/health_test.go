@@ -11,10 +11,7 @@ import (
 
 func TestHealthReport(t *testing.T) {
 	dir := t.TempDir()
-	restic := filepath.Join(dir, "restic")
-	if err := os.WriteFile(restic, []byte("#!/bin/sh\necho restic 0.9.6\n"), 0755); err != nil {
-		t.Fatalf("write restic: %v", err)
-	}
+	restic := fakeRestic(t, dir, "echo restic 0.9.6")
 	dataDir := filepath.Join(dir, "data")
 	if err := os.Mkdir(dataDir, 0755); err != nil {
 		t.Fatalf("mkdir: %v", err)
@@ -28,7 +25,7 @@ func TestHealthReport(t *testing.T) {
 		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body)), Header: make(http.Header), Request: req}, nil
 	}))
 	defer restore()
-	rep := healthReport(restic, cfg)
+	rep, _ := healthReport(restic, cfg, cfg.jobs())
 	if !strings.Contains(rep, "restic version: restic 0.9.6") {
 		t.Fatalf("report missing restic version: %s", rep)
 	}
@@ -45,3 +42,32 @@ func TestHealthReport(t *testing.T) {
 		t.Fatalf("report missing backup path: %s", rep)
 	}
 }
+
+// TestHealthReportRedactsSecrets masks secrets of the Pastebin document.
+func TestHealthReportRedactsSecrets(t *testing.T) {
+	dir := t.TempDir()
+	restic := fakeRestic(t, dir, "echo restic 0.9.6")
+	withSecrets(t, config{Password: "pb-pass"})
+	restore := withHTTPClient(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		body := `{"restic-repo-password":"pb-pass","email-password":"mail-secret","restic-repo":"/r"}`
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body)), Header: make(http.Header), Request: req}, nil
+	}))
+	defer restore()
+	rep, _ := healthReport(restic, config{}, config{}.jobs())
+	if strings.Contains(rep, "pb-pass") || strings.Contains(rep, "mail-secret") {
+		t.Fatalf("report leaks secrets: %s", rep)
+	}
+	if !strings.Contains(rep, `"restic-repo": "/r"`) {
+		t.Fatalf("report missing pastebin content: %s", rep)
+	}
+	showSecrets = true
+	rep, masked := healthReport(restic, config{}, config{}.jobs())
+	if !strings.Contains(rep, "mail-secret") {
+		t.Fatalf("show-secrets did not reveal secrets: %s", rep)
+	}
+	// the notification body stays masked, also for secrets only the remote
+	// document knows
+	if strings.Contains(masked, "pb-pass") || strings.Contains(masked, "mail-secret") || !strings.Contains(masked, `"restic-repo": "/r"`) {
+		t.Fatalf("masked report leaks secrets: %s", masked)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"backup/internal/restic"
+)
+
+const historyFile = "history.jsonl"
+
+// runRecord is one entry of the run history.
+type runRecord struct {
+	Kind     string                `json:"kind"`
+	Job      string                `json:"job,omitempty"`
+	Start    time.Time             `json:"start"`
+	End      time.Time             `json:"end"`
+	Success  bool                  `json:"success"`
+	Error    string                `json:"error,omitempty"`
+	Detail   string                `json:"detail,omitempty"`
+	Summary  *restic.BackupSummary `json:"summary,omitempty"`
+	Progress *progress             `json:"progress,omitempty"`
+}
+
+// appendHistory adds rec to the history file.
+func appendHistory(rec runRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	f, err := appendFile(statePath(historyFile))
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readHistory returns all history entries, oldest first. A missing history
+// file yields no entries.
+func readHistory() ([]runRecord, error) {
+	f, err := os.Open(statePath(historyFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var recs []runRecord
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var rec runRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err == nil {
+			recs = append(recs, rec)
+		}
+	}
+	return recs, scanner.Err()
+}
+
+// recordRun appends rec to the history and reports failures on stderr.
+func recordRun(rec runRecord) {
+	if err := appendHistory(rec); err != nil {
+		fmt.Fprintf(stderr, "failed to record history: %v\n", err)
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"backup/internal/restic"
+)
+
+func TestHistoryRoundTrip(t *testing.T) {
+	chdir(t, t.TempDir())
+	recs, err := readHistory()
+	if err != nil || len(recs) != 0 {
+		t.Fatalf("expected empty history, got %v %v", recs, err)
+	}
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	if err := appendHistory(runRecord{Kind: "backup", Start: start, Success: true, Summary: &restic.BackupSummary{FilesNew: 3}}); err != nil {
+		t.Fatalf("appendHistory: %v", err)
+	}
+	if err := appendHistory(runRecord{Kind: "backup", Start: start.Add(time.Hour), Error: "boom"}); err != nil {
+		t.Fatalf("appendHistory: %v", err)
+	}
+	recs, err = readHistory()
+	if err != nil {
+		t.Fatalf("readHistory: %v", err)
+	}
+	if len(recs) != 2 || !recs[0].Success || recs[0].Summary.FilesNew != 3 || recs[1].Error != "boom" || !recs[0].Start.Equal(start) {
+		t.Fatalf("unexpected history: %+v", recs)
+	}
+}
@@ -0,0 +1,406 @@
+// Package restic wraps the restic command line program.
+package restic
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Client runs restic commands against a single repository.
+type Client struct {
+	Path     string   // path of the restic binary
+	Repo     string   // repository location passed with -r
+	Password string   // repository password, passed as RESTIC_PASSWORD unless empty
+	Env      []string // additional environment variables
+
+	// Stdout and Stderr receive restic's human readable output of commands
+	// that do not return parsed results. Nil discards the output.
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// New returns a client for the repository at repo.
+func New(path, repo, password string) *Client {
+	return &Client{Path: path, Repo: repo, Password: password}
+}
+
+// Version returns the output of `restic version`.
+func (c *Client) Version(ctx context.Context) (string, error) {
+	out, err := c.output(ctx, false, "version")
+	return strings.TrimSpace(string(out)), err
+}
+
+// SelfUpdate runs `restic self-update`.
+func (c *Client) SelfUpdate(ctx context.Context) error {
+	return c.exec(ctx, false, "self-update")
+}
+
+// Exists reports whether the repository has been initialized. It reads the
+// repository config with `restic cat config`, which works for every backend,
+// and treats ErrRepoNotExist as a missing repository. Other failures such as
+// a wrong password or an unreachable server are returned as errors.
+func (c *Client) Exists(ctx context.Context) (bool, error) {
+	_, err := c.output(ctx, true, "cat", "config")
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, ErrRepoNotExist):
+		return false, nil
+	}
+	return false, err
+}
+
+// Init creates the repository.
+func (c *Client) Init(ctx context.Context) error {
+	return c.exec(ctx, true, "init")
+}
+
+// Backup backs up paths into the repository and returns restic's summary.
+// Output that is not part of the JSON protocol is passed to Stdout and Stderr.
+// If restic exits with ErrIncomplete the summary is returned together with the
+// error, because the snapshot was still created.
+func (c *Client) Backup(ctx context.Context, paths []string, opts BackupOptions) (*BackupSummary, error) {
+	args := []string{"backup", "--json"}
+	for _, t := range opts.Tags {
+		args = append(args, "--tag", t)
+	}
+	for _, e := range opts.Excludes {
+		args = append(args, "--exclude", e)
+	}
+	for _, e := range opts.IExcludes {
+		args = append(args, "--iexclude", e)
+	}
+	for _, f := range opts.ExcludeFiles {
+		args = append(args, "--exclude-file", f)
+	}
+	for _, f := range opts.ExcludeIfPresent {
+		args = append(args, "--exclude-if-present", f)
+	}
+	if opts.ExcludeLargerThan != "" {
+		args = append(args, "--exclude-larger-than", opts.ExcludeLargerThan)
+	}
+	if opts.ExcludeCaches {
+		args = append(args, "--exclude-caches")
+	}
+	args = append(args, paths...)
+
+	var (
+		mu      sync.Mutex
+		summary *BackupSummary
+	)
+	handle := func(passthrough io.Writer) func(line []byte) {
+		return func(line []byte) {
+			mu.Lock()
+			defer mu.Unlock()
+			var msg struct {
+				MessageType string `json:"message_type"`
+			}
+			if json.Unmarshal(line, &msg) != nil || msg.MessageType == "" {
+				if passthrough != nil {
+					passthrough.Write(append(line, '\n'))
+				}
+				return
+			}
+			switch msg.MessageType {
+			case "status":
+				var st BackupStatus
+				if json.Unmarshal(line, &st) == nil && opts.OnStatus != nil {
+					opts.OnStatus(st)
+				}
+			case "error":
+				var be BackupError
+				if json.Unmarshal(line, &be) == nil && opts.OnError != nil {
+					opts.OnError(be)
+				}
+			case "summary":
+				var sum BackupSummary
+				if json.Unmarshal(line, &sum) == nil {
+					summary = &sum
+				}
+			}
+		}
+	}
+
+	cmd := c.command(ctx, true, args...)
+	var errBuf bytes.Buffer
+	outLines := &lineWriter{fn: handle(c.Stdout)}
+	errLines := &lineWriter{fn: handle(c.Stderr)}
+	cmd.Stdout = outLines
+	cmd.Stderr = io.MultiWriter(&errBuf, errLines)
+	err := cmd.Run()
+	outLines.Flush()
+	errLines.Flush()
+	if err != nil {
+		return summary, wrapError("backup", err, errBuf.String())
+	}
+	if summary == nil {
+		summary = &BackupSummary{}
+	}
+	return summary, nil
+}
+
+// Snapshots lists all snapshots in the repository.
+func (c *Client) Snapshots(ctx context.Context) ([]Snapshot, error) {
+	var snaps []Snapshot
+	if err := c.json(ctx, &snaps, "snapshots"); err != nil {
+		return nil, err
+	}
+	return snaps, nil
+}
+
+// Forget applies a retention policy and returns the affected snapshot groups.
+func (c *Client) Forget(ctx context.Context, opts ForgetOptions) ([]ForgetGroup, error) {
+	p := opts.Policy
+	args := []string{"forget"}
+	for _, f := range []struct {
+		name string
+		n    int
+	}{
+		{"--keep-last", p.KeepLast},
+		{"--keep-hourly", p.KeepHourly},
+		{"--keep-daily", p.KeepDaily},
+		{"--keep-weekly", p.KeepWeekly},
+		{"--keep-monthly", p.KeepMonthly},
+		{"--keep-yearly", p.KeepYearly},
+	} {
+		if f.n != 0 {
+			args = append(args, f.name, strconv.Itoa(f.n))
+		}
+	}
+	if p.KeepWithin != "" {
+		args = append(args, "--keep-within", p.KeepWithin)
+	}
+	for _, t := range p.KeepTags {
+		args = append(args, "--keep-tag", t)
+	}
+	if len(opts.Tags) > 0 {
+		args = append(args, "--tag", strings.Join(opts.Tags, ","))
+	}
+	if opts.Prune {
+		args = append(args, "--prune")
+	}
+	if opts.DryRun {
+		args = append(args, "--dry-run")
+	}
+	var groups []ForgetGroup
+	if err := c.json(ctx, &groups, args...); err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+// Prune removes unreferenced data from the repository.
+func (c *Client) Prune(ctx context.Context) error {
+	return c.exec(ctx, true, "prune")
+}
+
+// Check verifies the repository structure and, if requested, a subset of the
+// pack files.
+func (c *Client) Check(ctx context.Context, opts CheckOptions) error {
+	args := []string{"check"}
+	if opts.ReadDataSubset != "" {
+		args = append(args, "--read-data-subset", opts.ReadDataSubset)
+	}
+	return c.exec(ctx, true, args...)
+}
+
+// Restore restores snapshot into opts.Target.
+func (c *Client) Restore(ctx context.Context, snapshot string, opts RestoreOptions) error {
+	args := []string{"restore", snapshot, "--target", opts.Target}
+	for _, p := range opts.Includes {
+		args = append(args, "--include", p)
+	}
+	return c.exec(ctx, true, args...)
+}
+
+// Find searches all snapshots for files matching the glob pattern, ignoring
+// case.
+func (c *Client) Find(ctx context.Context, pattern string) ([]FindResult, error) {
+	var res []FindResult
+	if err := c.json(ctx, &res, "find", "--ignore-case", pattern); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Ls lists the direct children of dir in snapshot.
+func (c *Client) Ls(ctx context.Context, snapshot, dir string) ([]Node, error) {
+	out, err := c.output(ctx, true, "ls", "--json", snapshot, dir)
+	if err != nil {
+		return nil, err
+	}
+	var nodes []Node
+	for _, line := range bytes.Split(out, []byte("\n")) {
+		var n struct {
+			Node
+			StructType string `json:"struct_type"`
+		}
+		if json.Unmarshal(line, &n) != nil || n.StructType != "node" {
+			continue
+		}
+		if parentDir(n.Path) == dir {
+			nodes = append(nodes, n.Node)
+		}
+	}
+	return nodes, nil
+}
+
+// parentDir returns the snapshot directory containing p.
+func parentDir(p string) string {
+	i := strings.LastIndex(p, "/")
+	if i <= 0 {
+		return "/"
+	}
+	return p[:i]
+}
+
+// Stats returns repository statistics. An empty mode uses restic's default.
+func (c *Client) Stats(ctx context.Context, mode string) (*Stats, error) {
+	args := []string{"stats"}
+	if mode != "" {
+		args = append(args, "--mode", mode)
+	}
+	var st Stats
+	if err := c.json(ctx, &st, args...); err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
+
+// Unlock removes stale locks from the repository.
+func (c *Client) Unlock(ctx context.Context) error {
+	return c.exec(ctx, true, "unlock")
+}
+
+// Run executes an arbitrary restic subcommand against the repository with
+// output connected to Stdout and Stderr.
+func (c *Client) Run(ctx context.Context, args ...string) error {
+	return c.exec(ctx, true, args...)
+}
+
+// command builds the exec.Cmd for a restic invocation. withRepo adds the
+// repository location and password.
+func (c *Client) command(ctx context.Context, withRepo bool, args ...string) *exec.Cmd {
+	if withRepo {
+		args = append([]string{"-r", c.Repo}, args...)
+	}
+	cmd := exec.CommandContext(ctx, c.Path, args...)
+	cmd.Env = append(os.Environ(), c.Env...)
+	if withRepo && c.Password != "" {
+		cmd.Env = append(cmd.Env, "RESTIC_PASSWORD="+c.Password)
+	}
+	return cmd
+}
+
+// exec runs restic with output connected to Stdout and Stderr.
+func (c *Client) exec(ctx context.Context, withRepo bool, args ...string) error {
+	cmd := c.command(ctx, withRepo, args...)
+	var errBuf bytes.Buffer
+	stdout, stderr := c.Stdout, c.Stderr
+	if sameWriter(stdout, stderr) {
+		// os/exec copies stdout and stderr in separate goroutines and only
+		// serializes them for the same writer, which the MultiWriter hides
+		w := &lockedWriter{w: stdout}
+		stdout, stderr = w, w
+	}
+	cmd.Stdout = stdout
+	cmd.Stderr = &errBuf
+	if stderr != nil {
+		cmd.Stderr = io.MultiWriter(stderr, &errBuf)
+	}
+	return wrapError(args[0], cmd.Run(), errBuf.String())
+}
+
+// sameWriter reports whether a and b are the same writer. Writers whose
+// dynamic type is not comparable are never the same.
+func sameWriter(a, b io.Writer) bool {
+	if a == nil || b == nil {
+		return false
+	}
+	ta, tb := reflect.TypeOf(a), reflect.TypeOf(b)
+	return ta == tb && ta.Comparable() && a == b
+}
+
+// lockedWriter serializes writes to w.
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
+
+// output runs restic and returns its standard output.
+func (c *Client) output(ctx context.Context, withRepo bool, args ...string) ([]byte, error) {
+	cmd := c.command(ctx, withRepo, args...)
+	var errBuf bytes.Buffer
+	cmd.Stderr = &errBuf
+	out, err := cmd.Output()
+	return out, wrapError(args[0], err, errBuf.String())
+}
+
+// json runs restic with --json and decodes its output into v.
+func (c *Client) json(ctx context.Context, v any, args ...string) error {
+	out, err := c.output(ctx, true, append(args, "--json")...)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(out, v); err != nil {
+		return &Error{Op: args[0], ExitCode: 0, Err: err}
+	}
+	return nil
+}
+
+// wrapError converts an error from os/exec into an *Error.
+func wrapError(op string, err error, stderr string) error {
+	if err == nil {
+		return nil
+	}
+	code := -1
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		code = exitErr.ExitCode()
+	}
+	return &Error{Op: op, ExitCode: code, Stderr: strings.TrimSpace(stderr), Err: err}
+}
+
+// lineWriter calls fn for every complete line written to it.
+type lineWriter struct {
+	buf []byte
+	fn  func(line []byte)
+}
+
+// Write buffers p and emits all complete lines.
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		line := bytes.TrimRight(w.buf[:i], "\r")
+		w.fn(append([]byte(nil), line...))
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush emits a trailing line without newline.
+func (w *lineWriter) Flush() {
+	if len(w.buf) > 0 {
+		w.fn(w.buf)
+		w.buf = nil
+	}
+}
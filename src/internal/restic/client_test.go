@@ -0,0 +1,331 @@
+package restic
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeRestic writes a shell script standing in for restic. The script records
+// its arguments and password in dir and then runs body.
+func fakeRestic(t *testing.T, body string) (string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "restic")
+	script := fmt.Sprintf("#!/bin/sh\necho \"$@\" > %s/args\necho \"$RESTIC_PASSWORD\" > %s/password\n%s\n", dir, dir, body)
+	if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatalf("write restic: %v", err)
+	}
+	return path, dir
+}
+
+// readArgs returns the arguments recorded by fakeRestic.
+func readArgs(t *testing.T, dir string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, "args"))
+	if err != nil {
+		t.Fatalf("read args: %v", err)
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func TestSnapshots(t *testing.T) {
+	path, dir := fakeRestic(t, `echo '[{"id":"abcdef","short_id":"abc","time":"2024-05-01T10:00:00Z","paths":["/a"],"hostname":"h"}]'`)
+	c := New(path, "/repo", "pw")
+	snaps, err := c.Snapshots(context.Background())
+	if err != nil {
+		t.Fatalf("Snapshots: %v", err)
+	}
+	if len(snaps) != 1 || snaps[0].ShortID != "abc" || snaps[0].Hostname != "h" || snaps[0].Time.Year() != 2024 {
+		t.Fatalf("unexpected snapshots: %+v", snaps)
+	}
+	if got := readArgs(t, dir); got != "-r /repo snapshots --json" {
+		t.Fatalf("unexpected args: %q", got)
+	}
+	pw, _ := os.ReadFile(filepath.Join(dir, "password"))
+	if strings.TrimSpace(string(pw)) != "pw" {
+		t.Fatalf("unexpected password: %q", pw)
+	}
+}
+
+func TestForgetArgs(t *testing.T) {
+	path, dir := fakeRestic(t, `echo '[{"host":"h","keep":[{"id":"k"}],"remove":[{"id":"r1"},{"id":"r2"}]}]'`)
+	c := New(path, "/repo", "pw")
+	groups, err := c.Forget(context.Background(), ForgetOptions{
+		Policy: Policy{KeepLast: 3, KeepDaily: 7, KeepWithin: "1y", KeepTags: []string{"keep"}},
+		Tags:   []string{"docs"},
+		Prune:  true,
+		DryRun: true,
+	})
+	if err != nil {
+		t.Fatalf("Forget: %v", err)
+	}
+	if len(groups) != 1 || len(groups[0].Remove) != 2 || groups[0].Keep[0].ID != "k" {
+		t.Fatalf("unexpected groups: %+v", groups)
+	}
+	exp := "-r /repo forget --keep-last 3 --keep-daily 7 --keep-within 1y --keep-tag keep --tag docs --prune --dry-run --json"
+	if got := readArgs(t, dir); got != exp {
+		t.Fatalf("unexpected args: %q", got)
+	}
+}
+
+func TestBackupExcludeArgs(t *testing.T) {
+	path, dir := fakeRestic(t, `echo '{"message_type":"summary","snapshot_id":"abc"}'`)
+	c := New(path, "/repo", "pw")
+	_, err := c.Backup(context.Background(), []string{"/home"}, BackupOptions{
+		Excludes:          []string{"*.tmp"},
+		IExcludes:         []string{"Temp"},
+		ExcludeFiles:      []string{"/etc/excludes"},
+		ExcludeIfPresent:  []string{".nobackup"},
+		ExcludeLargerThan: "2G",
+		ExcludeCaches:     true,
+	})
+	if err != nil {
+		t.Fatalf("Backup: %v", err)
+	}
+	exp := "-r /repo backup --json --exclude *.tmp --iexclude Temp --exclude-file /etc/excludes --exclude-if-present .nobackup --exclude-larger-than 2G --exclude-caches /home"
+	if got := readArgs(t, dir); got != exp {
+		t.Fatalf("unexpected args: %q", got)
+	}
+}
+
+func TestStats(t *testing.T) {
+	path, dir := fakeRestic(t, `echo '{"total_size":1024,"total_file_count":3,"snapshots_count":2}'`)
+	st, err := New(path, "/repo", "pw").Stats(context.Background(), "raw-data")
+	if err != nil {
+		t.Fatalf("Stats: %v", err)
+	}
+	if st.TotalSize != 1024 || st.TotalFileCount != 3 || st.SnapshotsCount != 2 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+	if got := readArgs(t, dir); got != "-r /repo stats --mode raw-data --json" {
+		t.Fatalf("unexpected args: %q", got)
+	}
+}
+
+func TestVersionWithoutRepo(t *testing.T) {
+	path, dir := fakeRestic(t, `echo "restic 0.16.4"`)
+	v, err := New(path, "/repo", "pw").Version(context.Background())
+	if err != nil || v != "restic 0.16.4" {
+		t.Fatalf("Version: %q %v", v, err)
+	}
+	if got := readArgs(t, dir); got != "version" {
+		t.Fatalf("unexpected args: %q", got)
+	}
+}
+
+func TestExitCodeErrors(t *testing.T) {
+	cases := []struct {
+		body string
+		want error
+	}{
+		{"exit 10", ErrRepoNotExist},
+		{"echo 'Fatal: unable to open config file: Stat: stat /r/config: no such file or directory\nIs there a repository at the following location?' >&2; exit 1", ErrRepoNotExist},
+		{"exit 11", ErrLocked},
+		{"echo 'Fatal: wrong password or no key found' >&2; exit 1", ErrWrongPassword},
+		{"exit 12", ErrWrongPassword},
+		{"exit 3", ErrIncomplete},
+		{"exit 130", ErrInterrupted},
+	}
+	for _, tc := range cases {
+		path, _ := fakeRestic(t, tc.body)
+		err := New(path, "/r", "pw").Init(context.Background())
+		if !errors.Is(err, tc.want) {
+			t.Errorf("%q: got %v, want %v", tc.body, err, tc.want)
+		}
+		var rerr *Error
+		if !errors.As(err, &rerr) || rerr.Op != "init" {
+			t.Errorf("%q: expected *Error, got %T", tc.body, err)
+		}
+	}
+}
+
+func TestExists(t *testing.T) {
+	tests := []struct {
+		body    string
+		exists  bool
+		wantErr error
+	}{
+		{"echo '{}'", true, nil},
+		{"exit 10", false, nil},
+		{"echo 'Is there a repository at the following location?' >&2; exit 1", false, nil},
+		{"exit 12", false, ErrWrongPassword},
+		{"echo 'Fatal: unable to open repository: connection refused' >&2; exit 1", false, ErrFatal},
+	}
+	for _, tt := range tests {
+		path, dir := fakeRestic(t, tt.body)
+		exists, err := New(path, "rest:http://host/repo", "pw").Exists(context.Background())
+		if exists != tt.exists || (tt.wantErr == nil) != (err == nil) || (tt.wantErr != nil && !errors.Is(err, tt.wantErr)) {
+			t.Errorf("%q: got %v, %v", tt.body, exists, err)
+		}
+		if got := readArgs(t, dir); got != "-r rest:http://host/repo cat config" {
+			t.Errorf("unexpected args: %q", got)
+		}
+	}
+}
+
+func TestErrorMessage(t *testing.T) {
+	path, _ := fakeRestic(t, "echo 'Fatal: something broke' >&2; exit 1")
+	err := New(path, "/r", "pw").Check(context.Background(), CheckOptions{})
+	if err == nil || err.Error() != "restic check failed (exit code 1): Fatal: something broke" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if errors.Is(err, ErrLocked) {
+		t.Fatalf("generic failure reported as locked")
+	}
+}
+
+// racyWriter fails the test when Write is called concurrently.
+type racyWriter struct {
+	t      *testing.T
+	active atomic.Int32
+	buf    bytes.Buffer
+}
+
+func (w *racyWriter) Write(p []byte) (int, error) {
+	if w.active.Add(1) > 1 {
+		w.t.Errorf("concurrent Write")
+	}
+	defer w.active.Add(-1)
+	time.Sleep(time.Millisecond)
+	return w.buf.Write(p)
+}
+
+// TestSharedOutput writes stdout and stderr of restic to one writer without
+// calling it concurrently.
+func TestSharedOutput(t *testing.T) {
+	path, _ := fakeRestic(t, "for i in 1 2 3 4 5 6 7 8; do echo out $i; echo err $i >&2; done; exit 1")
+	w := &racyWriter{t: t}
+	c := New(path, "/r", "pw")
+	c.Stdout, c.Stderr = w, w
+	err := c.Unlock(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "err 8") {
+		t.Fatalf("stderr not captured: %v", err)
+	}
+	if got := strings.Count(w.buf.String(), "\n"); got != 16 {
+		t.Fatalf("unexpected output %q", w.buf.String())
+	}
+}
+
+// funcWriter is a writer whose dynamic type is not comparable.
+type funcWriter func([]byte) (int, error)
+
+func (f funcWriter) Write(p []byte) (int, error) { return f(p) }
+
+func TestSameWriter(t *testing.T) {
+	var b bytes.Buffer
+	f := funcWriter(b.Write)
+	for _, tc := range []struct {
+		a, b io.Writer
+		want bool
+	}{
+		{&b, &b, true},
+		{&b, &bytes.Buffer{}, false},
+		{&b, nil, false},
+		{nil, nil, false},
+		{f, f, false},
+		{f, &b, false},
+	} {
+		if got := sameWriter(tc.a, tc.b); got != tc.want {
+			t.Errorf("sameWriter(%T, %T) = %v, want %v", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
+
+func TestPolicyEmpty(t *testing.T) {
+	if !(Policy{}).Empty() {
+		t.Fatalf("zero policy not empty")
+	}
+	if (Policy{KeepTags: []string{"x"}}).Empty() {
+		t.Fatalf("policy with keep-tag reported empty")
+	}
+}
+
+func TestBackupProgress(t *testing.T) {
+	body := `echo '{"message_type":"status","percent_done":0.5,"total_files":4,"files_done":2,"total_bytes":2048,"bytes_done":1024,"seconds_remaining":3,"current_files":["/a/x"]}'
+echo '{"message_type":"error","error":{"message":"permission denied"},"during":"archival","item":"/a/secret"}' >&2
+echo 'not json'
+echo '{"message_type":"summary","files_new":34,"files_changed":1,"data_added":1200000000,"total_duration":12.5,"snapshot_id":"deadbeef"}'`
+	path, dir := fakeRestic(t, body)
+	c := New(path, "/repo", "pw")
+	var other strings.Builder
+	c.Stdout = &other
+	var statuses []BackupStatus
+	var errs []BackupError
+	sum, err := c.Backup(context.Background(), []string{"/a"}, BackupOptions{
+		Tags:     []string{"t"},
+		OnStatus: func(s BackupStatus) { statuses = append(statuses, s) },
+		OnError:  func(e BackupError) { errs = append(errs, e) },
+	})
+	if err != nil {
+		t.Fatalf("Backup: %v", err)
+	}
+	if got := readArgs(t, dir); got != "-r /repo backup --json --tag t /a" {
+		t.Fatalf("unexpected args: %q", got)
+	}
+	if len(statuses) != 1 || statuses[0].PercentDone != 0.5 || statuses[0].CurrentFiles[0] != "/a/x" {
+		t.Fatalf("unexpected statuses: %+v", statuses)
+	}
+	if len(errs) != 1 || errs[0].Item != "/a/secret" || errs[0].Error.Message != "permission denied" {
+		t.Fatalf("unexpected errors: %+v", errs)
+	}
+	if sum.FilesNew != 34 || sum.DataAdded != 1200000000 || sum.SnapshotID != "deadbeef" {
+		t.Fatalf("unexpected summary: %+v", sum)
+	}
+	if other.String() != "not json\n" {
+		t.Fatalf("unexpected passthrough: %q", other.String())
+	}
+}
+
+func TestBackupIncomplete(t *testing.T) {
+	path, _ := fakeRestic(t, `echo '{"message_type":"summary","files_new":1}'; exit 3`)
+	sum, err := New(path, "/repo", "pw").Backup(context.Background(), []string{"/a"}, BackupOptions{})
+	if !errors.Is(err, ErrIncomplete) {
+		t.Fatalf("expected ErrIncomplete, got %v", err)
+	}
+	if sum == nil || sum.FilesNew != 1 {
+		t.Fatalf("summary missing: %+v", sum)
+	}
+}
+
+func TestFind(t *testing.T) {
+	path, dir := fakeRestic(t, `echo '[{"hits":1,"snapshot":"abc","matches":[{"path":"/home/u/report.docx","name":"report.docx","type":"file","size":42}]}]'`)
+	res, err := New(path, "/repo", "pw").Find(context.Background(), "*report*")
+	if err != nil {
+		t.Fatalf("Find: %v", err)
+	}
+	if len(res) != 1 || res[0].Snapshot != "abc" || res[0].Matches[0].Path != "/home/u/report.docx" || res[0].Matches[0].Size != 42 {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+	if got := readArgs(t, dir); got != "-r /repo find --ignore-case *report* --json" {
+		t.Fatalf("unexpected args: %q", got)
+	}
+}
+
+func TestLs(t *testing.T) {
+	body := `cat <<'EOF2'
+{"time":"2024-05-01T10:00:00Z","paths":["/home"],"id":"abc","struct_type":"snapshot"}
+{"name":"u","type":"dir","path":"/home/u","struct_type":"node"}
+{"name":"a.txt","type":"file","path":"/home/u/a.txt","size":3,"struct_type":"node"}
+{"name":"docs","type":"dir","path":"/home/u/docs","struct_type":"node"}
+EOF2`
+	path, dir := fakeRestic(t, body)
+	nodes, err := New(path, "/repo", "pw").Ls(context.Background(), "abc", "/home/u")
+	if err != nil {
+		t.Fatalf("Ls: %v", err)
+	}
+	if len(nodes) != 2 || nodes[0].Name != "a.txt" || nodes[1].Type != "dir" {
+		t.Fatalf("unexpected nodes: %+v", nodes)
+	}
+	if got := readArgs(t, dir); got != "-r /repo ls --json abc /home/u" {
+		t.Fatalf("unexpected args: %q", got)
+	}
+}
@@ -0,0 +1,71 @@
+package restic
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Sentinel errors matching restic's documented exit codes. Use errors.Is to
+// test an error returned by a Client method against them.
+var (
+	ErrFatal         = errors.New("restic: command failed")
+	ErrIncomplete    = errors.New("restic: some source files could not be read")
+	ErrRepoNotExist  = errors.New("restic: repository does not exist")
+	ErrLocked        = errors.New("restic: repository is locked")
+	ErrWrongPassword = errors.New("restic: wrong password")
+	ErrInterrupted   = errors.New("restic: interrupted")
+)
+
+// Error describes a failed restic invocation.
+type Error struct {
+	Op       string // restic subcommand, e.g. "backup"
+	ExitCode int    // process exit code, 0 for malformed output, -1 if restic could not be started
+	Stderr   string // trimmed standard error output
+	Err      error  // underlying error from os/exec
+}
+
+// Error returns a human readable description including restic's own message.
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("restic %s failed", e.Op)
+	if e.ExitCode > 0 {
+		msg += fmt.Sprintf(" (exit code %d)", e.ExitCode)
+	}
+	if s := lastLine(e.Stderr); s != "" {
+		msg += ": " + s
+	} else if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// Unwrap returns the underlying error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is maps the exit code, and for older restic versions the error text, to the
+// sentinel errors of this package.
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrFatal:
+		return e.ExitCode == 1
+	case ErrIncomplete:
+		return e.ExitCode == 3
+	case ErrRepoNotExist:
+		return e.ExitCode == 10 || (e.ExitCode == 1 && strings.Contains(e.Stderr, "Is there a repository at the following location?"))
+	case ErrLocked:
+		return e.ExitCode == 11 || (e.ExitCode == 1 && strings.Contains(e.Stderr, "repository is already locked"))
+	case ErrWrongPassword:
+		return e.ExitCode == 12 || (e.ExitCode == 1 && strings.Contains(e.Stderr, "wrong password"))
+	case ErrInterrupted:
+		return e.ExitCode == 130
+	}
+	return false
+}
+
+// lastLine returns the last non-empty line of s.
+func lastLine(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
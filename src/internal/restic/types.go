@@ -0,0 +1,147 @@
+package restic
+
+import "time"
+
+// Snapshot is a single entry of `restic snapshots --json`.
+type Snapshot struct {
+	ID       string    `json:"id"`
+	ShortID  string    `json:"short_id"`
+	Time     time.Time `json:"time"`
+	Tree     string    `json:"tree"`
+	Parent   string    `json:"parent,omitempty"`
+	Paths    []string  `json:"paths"`
+	Hostname string    `json:"hostname"`
+	Username string    `json:"username"`
+	Tags     []string  `json:"tags,omitempty"`
+}
+
+// Stats is the output of `restic stats --json`.
+type Stats struct {
+	TotalSize             uint64  `json:"total_size"`
+	TotalUncompressedSize uint64  `json:"total_uncompressed_size,omitempty"`
+	TotalFileCount        uint64  `json:"total_file_count"`
+	TotalBlobCount        uint64  `json:"total_blob_count,omitempty"`
+	SnapshotsCount        int     `json:"snapshots_count"`
+	CompressionRatio      float64 `json:"compression_ratio,omitempty"`
+}
+
+// Policy selects the snapshots kept by `restic forget`.
+type Policy struct {
+	KeepLast    int
+	KeepHourly  int
+	KeepDaily   int
+	KeepWeekly  int
+	KeepMonthly int
+	KeepYearly  int
+	KeepWithin  string
+	KeepTags    []string
+}
+
+// Empty reports whether the policy would keep nothing, which restic refuses.
+func (p Policy) Empty() bool {
+	return p.KeepLast == 0 && p.KeepHourly == 0 && p.KeepDaily == 0 && p.KeepWeekly == 0 &&
+		p.KeepMonthly == 0 && p.KeepYearly == 0 && p.KeepWithin == "" && len(p.KeepTags) == 0
+}
+
+// ForgetGroup is one snapshot group of `restic forget --json`.
+type ForgetGroup struct {
+	Host   string     `json:"host"`
+	Tags   []string   `json:"tags"`
+	Paths  []string   `json:"paths"`
+	Keep   []Snapshot `json:"keep"`
+	Remove []Snapshot `json:"remove"`
+}
+
+// BackupStatus is a `status` message of `restic backup --json`.
+type BackupStatus struct {
+	SecondsElapsed   float64  `json:"seconds_elapsed"`
+	SecondsRemaining float64  `json:"seconds_remaining"`
+	PercentDone      float64  `json:"percent_done"`
+	TotalFiles       uint64   `json:"total_files"`
+	FilesDone        uint64   `json:"files_done"`
+	TotalBytes       uint64   `json:"total_bytes"`
+	BytesDone        uint64   `json:"bytes_done"`
+	ErrorCount       uint64   `json:"error_count"`
+	CurrentFiles     []string `json:"current_files"`
+}
+
+// BackupSummary is the final `summary` message of `restic backup --json`.
+type BackupSummary struct {
+	FilesNew            uint64  `json:"files_new"`
+	FilesChanged        uint64  `json:"files_changed"`
+	FilesUnmodified     uint64  `json:"files_unmodified"`
+	DirsNew             uint64  `json:"dirs_new"`
+	DirsChanged         uint64  `json:"dirs_changed"`
+	DirsUnmodified      uint64  `json:"dirs_unmodified"`
+	DataBlobs           int64   `json:"data_blobs"`
+	TreeBlobs           int64   `json:"tree_blobs"`
+	DataAdded           uint64  `json:"data_added"`
+	DataAddedPacked     uint64  `json:"data_added_packed,omitempty"`
+	TotalFilesProcessed uint64  `json:"total_files_processed"`
+	TotalBytesProcessed uint64  `json:"total_bytes_processed"`
+	TotalDuration       float64 `json:"total_duration"`
+	SnapshotID          string  `json:"snapshot_id"`
+}
+
+// BackupError is an `error` message of `restic backup --json`, reported for
+// files that could not be read.
+type BackupError struct {
+	Error struct {
+		Message string `json:"message"`
+	} `json:"error"`
+	During string `json:"during"`
+	Item   string `json:"item"`
+}
+
+// BackupOptions holds optional arguments for Backup.
+type BackupOptions struct {
+	Tags              []string
+	Excludes          []string // patterns passed with --exclude
+	IExcludes         []string // case-insensitive patterns passed with --iexclude
+	ExcludeFiles      []string // pattern files passed with --exclude-file
+	ExcludeIfPresent  []string // file names passed with --exclude-if-present
+	ExcludeLargerThan string   // size such as "2G" passed with --exclude-larger-than
+	ExcludeCaches     bool     // pass --exclude-caches
+
+	// OnStatus and OnError receive the progress and error messages while the
+	// backup runs. Calls are serialized.
+	OnStatus func(BackupStatus)
+	OnError  func(BackupError)
+}
+
+// ForgetOptions holds the arguments for Forget.
+type ForgetOptions struct {
+	Policy Policy
+	// Tags limits the snapshots considered to those carrying every tag.
+	Tags   []string
+	Prune  bool
+	DryRun bool
+}
+
+// CheckOptions holds optional arguments for Check.
+type CheckOptions struct {
+	ReadDataSubset string // e.g. "1/12" or "10%"
+}
+
+// RestoreOptions holds the arguments for Restore.
+type RestoreOptions struct {
+	Target   string
+	Includes []string
+}
+
+// Node is a file or directory inside a snapshot, as reported by
+// `restic ls --json` and `restic find --json`.
+type Node struct {
+	Name  string    `json:"name"`
+	Type  string    `json:"type"`
+	Path  string    `json:"path"`
+	Size  uint64    `json:"size"`
+	Mtime time.Time `json:"mtime"`
+}
+
+// FindResult lists the matches of `restic find --json` in one snapshot.
+type FindResult struct {
+	Hits     int    `json:"hits"`
+	Snapshot string `json:"snapshot"`
+	Matches  []Node `json:"matches"`
+}
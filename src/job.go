@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// defaultJobName names the job built from the top-level repository settings
+// when no jobs are configured.
+const defaultJobName = "default"
+
+// job is a named backup with its own repository, password source, paths,
+// excludes, retention and schedule. Retention, check and backend settings
+// that a job leaves out are taken from the top level of the configuration.
+type job struct {
+	Name     string `json:"name"`
+	Repo     string `json:"repo"`
+	Password string `json:"password,omitempty"`
+	// PasswordFile and PasswordCommand are passed to restic as
+	// RESTIC_PASSWORD_FILE and RESTIC_PASSWORD_COMMAND.
+	PasswordFile    string `json:"password-file,omitempty"`
+	PasswordCommand string `json:"password-command,omitempty"`
+
+	Paths []string `json:"paths"`
+	exclusions
+	Retention *retention   `json:"retention,omitempty"`
+	Check     *checkConfig `json:"check,omitempty"`
+	Backend   *backend     `json:"backend,omitempty"`
+	// Schedule describes when the job runs, for example "1h" or "7d".
+	Schedule string `json:"schedule,omitempty"`
+	// MaxAge overrides the max-age of the top level.
+	MaxAge string `json:"max-age,omitempty"`
+}
+
+// jobs returns one configuration per job, each with the job's settings in
+// the top-level fields, so the rest of the program can treat a job like a
+// configuration with a single repository. Without configured jobs the
+// top-level settings form the default job.
+func (c config) jobs() []config {
+	if len(c.Jobs) == 0 {
+		d := c
+		d.Job = defaultJobName
+		return []config{d}
+	}
+	out := make([]config, 0, len(c.Jobs))
+	for _, j := range c.Jobs {
+		jc := c
+		jc.Jobs = nil
+		jc.Job = j.Name
+		jc.Repo = j.Repo
+		jc.Password = j.Password
+		jc.PasswordFile = j.PasswordFile
+		jc.PasswordCommand = j.PasswordCommand
+		jc.Paths = j.Paths
+		jc.exclusions = j.exclusions
+		jc.Schedule = j.Schedule
+		if j.Retention != nil {
+			jc.Retention = *j.Retention
+		}
+		if j.Check != nil {
+			jc.Check = *j.Check
+		}
+		if j.Backend != nil {
+			jc.Backend = *j.Backend
+		}
+		if j.MaxAge != "" {
+			jc.MaxAge = j.MaxAge
+		}
+		out = append(out, jc)
+	}
+	return out
+}
+
+// selectJobs returns the configurations of the named jobs, or of every job
+// when names is empty. The configured jobs are validated first.
+func (c config) selectJobs(names []string) ([]config, error) {
+	if err := c.validateJobs(); err != nil {
+		return nil, err
+	}
+	all := c.jobs()
+	if len(names) == 0 {
+		return all, nil
+	}
+	var out []config
+	for _, name := range names {
+		found := false
+		for _, jc := range all {
+			if jc.Job == name {
+				out = append(out, jc)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown job %q, configured jobs: %s", name, strings.Join(c.jobNames(), ", "))
+		}
+	}
+	return out, nil
+}
+
+// selectJob returns the configuration of a single job. name may be empty
+// when exactly one job is configured.
+func (c config) selectJob(name string) (config, error) {
+	var names []string
+	if name != "" {
+		names = []string{name}
+	}
+	jobs, err := c.selectJobs(names)
+	if err != nil {
+		return config{}, err
+	}
+	if len(jobs) > 1 {
+		return config{}, fmt.Errorf("several jobs are configured, choose one of: %s", strings.Join(c.jobNames(), ", "))
+	}
+	return jobs[0], nil
+}
+
+// jobNames returns the names of all jobs.
+func (c config) jobNames() []string {
+	var names []string
+	for _, jc := range c.jobs() {
+		names = append(names, jc.Job)
+	}
+	return names
+}
+
+// validateJobs checks that every configured job has a unique name, a
+// repository and paths, and that the backend settings only set backend
+// variables.
+func (c config) validateJobs() error {
+	if err := c.Backend.validate(); err != nil {
+		return err
+	}
+	seen := map[string]bool{}
+	for i, j := range c.Jobs {
+		switch {
+		case j.Name == "":
+			return fmt.Errorf("job %d has no name", i+1)
+		case strings.Contains(j.Name, ","):
+			// the name becomes a restic tag, which cannot contain commas
+			return fmt.Errorf("job %q: the name must not contain a comma", j.Name)
+		case seen[j.Name]:
+			return fmt.Errorf("job %q is configured twice", j.Name)
+		case j.Repo == "":
+			return fmt.Errorf("job %q has no repo", j.Name)
+		case len(j.Paths) == 0:
+			return fmt.Errorf("job %q has no paths", j.Name)
+		case j.PasswordFile != "" && j.PasswordCommand != "":
+			return fmt.Errorf("job %q sets both password-file and password-command", j.Name)
+		}
+		if j.Backend != nil {
+			if err := j.Backend.validate(); err != nil {
+				return fmt.Errorf("job %q: %w", j.Name, err)
+			}
+		}
+		seen[j.Name] = true
+	}
+	return nil
+}
+
+// snapshotTags returns the restic tags that mark the snapshots of the job, so
+// jobs sharing a repository only forget and check the age of their own.
+func (c config) snapshotTags() []string {
+	if c.Job == "" {
+		return nil
+	}
+	return []string{c.Job}
+}
+
+// jobLabel returns the job name for messages, or "" for the default job.
+func jobLabel(cfg config) string {
+	if cfg.Job == "" || cfg.Job == defaultJobName {
+		return ""
+	}
+	return fmt.Sprintf(" %q", cfg.Job)
+}
+
+// passwordEnv returns the environment variables selecting the password file
+// or command of a job.
+func (c config) passwordEnv() []string {
+	var env []string
+	if c.PasswordFile != "" {
+		env = append(env, "RESTIC_PASSWORD_FILE="+expandUser(c.PasswordFile))
+	}
+	if c.PasswordCommand != "" {
+		env = append(env, "RESTIC_PASSWORD_COMMAND="+c.PasswordCommand)
+	}
+	return env
+}
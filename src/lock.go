@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"backup/internal/restic"
+)
+
+// lockDir is the directory below the state directory that holds the lock
+// files of running jobs.
+const lockDir = "locks"
+
+// unreadableLockAge is how old a lock file that cannot be parsed must be
+// before it is treated as stale. A younger one may still be being written.
+const unreadableLockAge = time.Minute
+
+// lockGuardFile is locked while a job lock is taken, so two processes that
+// find the same stale lock cannot both replace it.
+var lockGuardFile = filepath.Join(lockDir, "acquire.flock")
+
+// errAlreadyRunning is returned by acquireLock when another process holds the
+// lock of a job.
+var errAlreadyRunning = errors.New("already running")
+
+// runLock is the content of a job's lock file and identifies the process
+// running the job.
+type runLock struct {
+	Job     string    `json:"job"`
+	Command string    `json:"command"`
+	PID     int       `json:"pid"`
+	Host    string    `json:"host"`
+	Start   time.Time `json:"start"`
+
+	path string
+}
+
+// lockPath returns the lock file of the named job.
+func lockPath(job string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		}
+		return '_'
+	}, job)
+	return statePath(filepath.Join(lockDir, name+".lock"))
+}
+
+// acquireLock creates the lock file of job for the current process, which
+// runs command. A lock left behind by a process that no longer exists is
+// removed; a lock held by a running process yields errAlreadyRunning. The
+// lock guard is held throughout, which makes checking and removing a stale
+// lock atomic.
+func acquireLock(job, command string) (*runLock, error) {
+	path := lockPath(job)
+	guard, err := appendFile(statePath(lockGuardFile))
+	if err != nil {
+		return nil, err
+	}
+	defer guard.Close()
+	if err := lockFile(guard); err != nil {
+		return nil, fmt.Errorf("lock guard: %w", err)
+	}
+	defer unlockFile(guard)
+	host, _ := os.Hostname()
+	l := &runLock{Job: job, Command: command, PID: os.Getpid(), Host: host, Start: time.Now(), path: path}
+	data, err := json.Marshal(l)
+	if err != nil {
+		return nil, err
+	}
+	for attempt := 0; attempt < 2; attempt++ {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			_, err = f.Write(data)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				os.Remove(path)
+				return nil, fmt.Errorf("write lock: %w", err)
+			}
+			return l, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, fmt.Errorf("create lock: %w", err)
+		}
+		held, err := readLock(path)
+		if err == nil && held.active() {
+			return nil, fmt.Errorf("job %s is %w: %s", job, errAlreadyRunning, held.describe())
+		}
+		if err != nil {
+			if info, serr := os.Stat(path); serr == nil && time.Since(info.ModTime()) < unreadableLockAge {
+				return nil, fmt.Errorf("job %s is %w: lock %s is being written", job, errAlreadyRunning, path)
+			}
+		} else {
+			fmt.Fprintf(stderr, "removing stale lock of job %s left by pid %d\n", job, held.PID)
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("remove stale lock: %w", err)
+		}
+	}
+	return nil, fmt.Errorf("job %s is %w: lock %s was taken by another process", job, errAlreadyRunning, path)
+}
+
+// release removes the lock file.
+func (l *runLock) release() {
+	if err := os.Remove(l.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		fmt.Fprintf(stderr, "failed to remove lock: %v\n", err)
+	}
+}
+
+// active reports whether the process that wrote the lock still runs. Locks
+// written on another host, which happens when the state directory is shared,
+// cannot be checked and count as active. A lock carrying the PID of the
+// current process was left by an earlier process that had the same PID,
+// which is common in containers.
+func (l runLock) active() bool {
+	if host, _ := os.Hostname(); l.Host != host {
+		return true
+	}
+	return l.PID != os.Getpid() && processAlive(l.PID)
+}
+
+// describe returns what the locking process runs and since when.
+func (l runLock) describe() string {
+	return fmt.Sprintf("%s since %s (pid %d on %s)", l.Command, l.Start.Local().Format("2006-01-02 15:04"), l.PID, l.Host)
+}
+
+// readLock reads the lock file at path.
+func readLock(path string) (runLock, error) {
+	var l runLock
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return l, err
+	}
+	if err := json.Unmarshal(data, &l); err != nil {
+		return l, fmt.Errorf("lock %s: %w", path, err)
+	}
+	if l.PID <= 0 {
+		return l, fmt.Errorf("lock %s: no pid", path)
+	}
+	l.path = path
+	return l, nil
+}
+
+// withJobLock runs fn while holding the lock of job.
+func withJobLock(job, command string, fn func() error) error {
+	l, err := acquireLock(job, command)
+	if err != nil {
+		return err
+	}
+	defer l.release()
+	return fn()
+}
+
+// unlockAndRetry runs op and, when restic reports that the repository is
+// locked, removes stale repository locks with restic unlock and runs op once
+// more. restic unlock keeps locks of processes that are still running, so a
+// backup from another machine is never disturbed.
+func unlockAndRetry(client *restic.Client, out io.Writer, op func() error) error {
+	err := op()
+	if !errors.Is(err, restic.ErrLocked) {
+		return err
+	}
+	logf(out, "repository is locked, removing stale locks")
+	if uerr := client.Unlock(context.Background()); uerr != nil {
+		logf(out, "restic unlock failed: %v", uerr)
+		return err
+	}
+	return op()
+}
+
+// printStatus writes a table of jobs to w with their active run, if any, and
+// when they last ran, last succeeded and run next.
+func printStatus(w io.Writer, jobs []config, now time.Time) {
+	when := func(t time.Time) string {
+		if t.IsZero() {
+			return "never"
+		}
+		return t.Local().Format("2006-01-02 15:04")
+	}
+	st := loadState()
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "JOB\tSTATUS\tLAST RUN\tLAST SUCCESS\tNEXT RUN")
+	for _, jc := range jobs {
+		status := "idle"
+		if l, err := readLock(lockPath(jc.Job)); err == nil {
+			status = "running " + l.describe()
+			if !l.active() {
+				status = fmt.Sprintf("stale lock of pid %d", l.PID)
+			}
+		}
+		js := st.job(jc.Job)
+		next := "on demand"
+		if s, err := parseSchedule(jc.Schedule); jc.Schedule != "" && err != nil {
+			next = "invalid schedule"
+		} else if err == nil {
+			next = "now"
+			if n := nextRun(s, js); n.After(now) {
+				next = when(n)
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", jc.Job, status, when(js.LastRun), when(js.LastSuccess), next)
+	}
+	tw.Flush()
+}
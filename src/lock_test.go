@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"backup/internal/restic"
+)
+
+// writeLock writes a lock file for job as if pid had taken it.
+func writeLock(t *testing.T, job string, pid int) {
+	t.Helper()
+	host, _ := os.Hostname()
+	data, _ := json.Marshal(runLock{Job: job, Command: "backup", PID: pid, Host: host, Start: local(2024, 5, 1, 10, 2)})
+	if err := writeFile(lockPath(job), data, 0600); err != nil {
+		t.Fatalf("write lock: %v", err)
+	}
+}
+
+// exitedPID returns the PID of a process that has already exited.
+func exitedPID(t *testing.T) int {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	return cmd.Process.Pid
+}
+
+func TestAcquireLock(t *testing.T) {
+	chdir(t, t.TempDir())
+	l, err := acquireLock("docs", "backup")
+	if err != nil {
+		t.Fatalf("acquireLock: %v", err)
+	}
+	held, err := readLock(lockPath("docs"))
+	if err != nil || held.PID != os.Getpid() || held.Command != "backup" {
+		t.Fatalf("unexpected lock: %+v %v", held, err)
+	}
+	if _, err := acquireLock("photos", "check"); err != nil {
+		t.Fatalf("jobs do not lock each other: %v", err)
+	}
+	l.release()
+	if _, err := os.Stat(lockPath("docs")); !os.IsNotExist(err) {
+		t.Fatalf("lock not removed: %v", err)
+	}
+}
+
+func TestAcquireLockAlreadyRunning(t *testing.T) {
+	chdir(t, t.TempDir())
+	writeLock(t, "docs", os.Getppid())
+	_, err := acquireLock("docs", "backup")
+	if !errors.Is(err, errAlreadyRunning) || !strings.Contains(err.Error(), "backup since 2024-05-01 10:02") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// a lock that is still being written is not taken over
+	if err := os.WriteFile(lockPath("photos"), nil, 0600); err != nil {
+		t.Fatalf("write lock: %v", err)
+	}
+	if _, err := acquireLock("photos", "backup"); !errors.Is(err, errAlreadyRunning) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestAcquireLockStale(t *testing.T) {
+	chdir(t, t.TempDir())
+	captureOutput(t)
+	for _, pid := range []int{exitedPID(t), os.Getpid()} {
+		writeLock(t, "docs", pid)
+		l, err := acquireLock("docs", "backup")
+		if err != nil {
+			t.Fatalf("stale lock of pid %d not taken over: %v", pid, err)
+		}
+		l.release()
+	}
+	// an unreadable lock is taken over once it is old enough
+	if err := os.WriteFile(lockPath("docs"), []byte("{"), 0600); err != nil {
+		t.Fatalf("write lock: %v", err)
+	}
+	old := time.Now().Add(-2 * unreadableLockAge)
+	os.Chtimes(lockPath("docs"), old, old)
+	if _, err := acquireLock("docs", "backup"); err != nil {
+		t.Fatalf("unreadable lock not taken over: %v", err)
+	}
+}
+
+// TestAcquireLockHelper is run by TestAcquireLockStaleRace in a separate
+// process. It takes the lock of job docs, reports the outcome and holds the
+// lock until stdin is closed.
+func TestAcquireLockHelper(t *testing.T) {
+	dir := os.Getenv("BACKUP_LOCK_HELPER_DIR")
+	if dir == "" {
+		t.Skip("helper process")
+	}
+	userDirs = func() appDirs { return appDirs{State: dir} }
+	stderr = io.Discard
+	if _, err := acquireLock("docs", "backup"); err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println("acquired")
+	io.Copy(io.Discard, os.Stdin)
+}
+
+// TestAcquireLockStaleRace lets several processes find the same stale lock
+// at once; only one of them may take it over.
+func TestAcquireLockStaleRace(t *testing.T) {
+	dir := t.TempDir()
+	chdir(t, dir)
+	writeLock(t, "docs", exitedPID(t))
+	type helper struct {
+		cmd   *exec.Cmd
+		stdin io.WriteCloser
+		out   *bufio.Reader
+	}
+	var helpers []helper
+	for i := 0; i < 8; i++ {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestAcquireLockHelper$")
+		cmd.Env = append(os.Environ(), "BACKUP_LOCK_HELPER_DIR="+dir)
+		stdin, _ := cmd.StdinPipe()
+		stdout, _ := cmd.StdoutPipe()
+		if err := cmd.Start(); err != nil {
+			t.Fatalf("start helper: %v", err)
+		}
+		helpers = append(helpers, helper{cmd, stdin, bufio.NewReader(stdout)})
+	}
+	acquired := 0
+	for _, h := range helpers {
+		line, _ := h.out.ReadString('\n')
+		switch {
+		case line == "acquired\n":
+			acquired++
+		case !strings.Contains(line, "already running"):
+			t.Errorf("unexpected helper output %q", line)
+		}
+	}
+	for _, h := range helpers {
+		h.stdin.Close()
+		h.cmd.Wait()
+	}
+	if acquired != 1 {
+		t.Fatalf("stale lock taken over by %d processes", acquired)
+	}
+}
+
+// TestBackupJobAlreadyRunning skips a job that another process is running
+// without recording or notifying a failure.
+func TestBackupJobAlreadyRunning(t *testing.T) {
+	chdir(t, t.TempDir())
+	captureOutput(t)
+	writeLock(t, "docs", os.Getppid())
+	var out bytes.Buffer
+	err := backupJob("restic-not-called", config{Job: "docs", Repo: "/repo", Unattended: true}, &out)
+	if !errors.Is(err, errAlreadyRunning) || !strings.Contains(out.String(), `backup "docs" skipped`) {
+		t.Fatalf("unexpected result: %v %q", err, out.String())
+	}
+	if recs, _ := readHistory(); len(recs) != 0 {
+		t.Fatalf("skipped run recorded: %+v", recs)
+	}
+	if !loadState().job("docs").LastRun.IsZero() {
+		t.Fatalf("skipped run updated the state")
+	}
+}
+
+func TestUnlockAndRetry(t *testing.T) {
+	dir := t.TempDir()
+	body := `case "$*" in
+*unlock*) touch ` + filepath.Join(dir, "unlocked") + ` ;;
+*check*) [ -f ` + filepath.Join(dir, "unlocked") + ` ] || { echo "repository is already locked" >&2; exit 11; } ;;
+esac`
+	resticPath := fakeRestic(t, dir, body)
+	client := restic.New(resticPath, "/repo", "pw")
+	var out bytes.Buffer
+	client.Stdout, client.Stderr = &out, &out
+	err := unlockAndRetry(client, &out, func() error {
+		return client.Check(context.Background(), restic.CheckOptions{})
+	})
+	if err != nil {
+		t.Fatalf("unlockAndRetry: %v", err)
+	}
+	if calls := readCalls(t, dir); len(calls) != 3 || calls[1] != "-r /repo unlock" {
+		t.Fatalf("unexpected calls: %q", calls)
+	}
+	if !strings.Contains(out.String(), "removing stale locks") {
+		t.Fatalf("unexpected output: %q", out.String())
+	}
+}
+
+func TestPrintStatus(t *testing.T) {
+	chdir(t, t.TempDir())
+	now := local(2024, 5, 1, 11, 30)
+	ran := local(2024, 5, 1, 11, 0)
+	updateJobState("docs", func(js *jobState) { js.LastRun, js.LastSuccess = ran, ran })
+	writeLock(t, "docs", os.Getppid())
+	writeLock(t, "photos", exitedPID(t))
+	var out bytes.Buffer
+	printStatus(&out, []config{{Job: "docs", Schedule: "1h"}, {Job: "photos"}, {Job: "music", Schedule: "@daily"}}, now)
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("unexpected status: %q", out.String())
+	}
+	for i, want := range [][]string{
+		{"docs", "running backup since 2024-05-01 10:02", "2024-05-01 11:00", "2024-05-01 12:00"},
+		{"photos", "stale lock of pid", "never", "on demand"},
+		{"music", "idle", "never", "now"},
+	} {
+		for _, w := range want {
+			if !strings.Contains(lines[i+1], w) {
+				t.Errorf("line %q does not contain %q", lines[i+1], w)
+			}
+		}
+	}
+}
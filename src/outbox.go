@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const outboxFile = "outbox.json"
+
+const (
+	// outboxRetryDelay is the wait before the first retry. It doubles with
+	// every failed attempt up to outboxMaxDelay.
+	outboxRetryDelay = time.Minute
+	outboxMaxDelay   = 6 * time.Hour
+	// outboxMaxAge is how long a notification is retried before it expires.
+	outboxMaxAge = 7 * 24 * time.Hour
+	// outboxKeep is how long delivered and expired notifications are kept
+	// for the notify queue command.
+	outboxKeep = 7 * 24 * time.Hour
+)
+
+// outboxEntry is a notification that a channel failed to deliver.
+type outboxEntry struct {
+	Channel      string       `json:"channel"`
+	Notification notification `json:"notification"`
+	Queued       time.Time    `json:"queued"`
+	Attempts     int          `json:"attempts"`
+	LastAttempt  time.Time    `json:"last-attempt"`
+	NextAttempt  time.Time    `json:"next-attempt,omitempty"`
+	LastError    string       `json:"last-error,omitempty"`
+	// Delivered or Expired is set once the entry is no longer retried.
+	Delivered time.Time `json:"delivered,omitempty"`
+	Expired   time.Time `json:"expired,omitempty"`
+}
+
+// status is "pending", "delivered" or "expired".
+func (e outboxEntry) status() string {
+	switch {
+	case !e.Delivered.IsZero():
+		return "delivered"
+	case !e.Expired.IsZero():
+		return "expired"
+	}
+	return "pending"
+}
+
+// finished returns when the entry was delivered or expired.
+func (e outboxEntry) finished() time.Time {
+	if !e.Delivered.IsZero() {
+		return e.Delivered
+	}
+	return e.Expired
+}
+
+// retryDelay returns the wait before the next attempt after attempts failed
+// deliveries.
+func retryDelay(attempts int) time.Duration {
+	d := outboxRetryDelay
+	for i := 1; i < attempts && d < outboxMaxDelay; i++ {
+		d *= 2
+	}
+	return min(d, outboxMaxDelay)
+}
+
+// loadOutbox reads the outbox. A missing or unreadable file yields no
+// entries.
+func loadOutbox() []outboxEntry {
+	var entries []outboxEntry
+	if data, err := os.ReadFile(statePath(outboxFile)); err == nil {
+		_ = json.Unmarshal(data, &entries)
+	}
+	return entries
+}
+
+// saveOutbox writes the outbox.
+func saveOutbox(entries []outboxEntry) error {
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(statePath(outboxFile), data, 0600)
+}
+
+// transient reports whether a delivery that failed with err may succeed
+// later: the network failed, the service was unavailable or rate limited the
+// request, or the mail server reported a temporary failure. Other failures,
+// such as a rejected token, a broken webhook template or a bad address, are
+// permanent and not queued.
+func transient(err error) bool {
+	var se *serviceError
+	if errors.As(err, &se) {
+		return se.Code >= 500 || se.Code == http.StatusTooManyRequests
+	}
+	var te *textproto.Error
+	if errors.As(err, &te) {
+		return te.Code/100 == 4
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	// a url.Error is a net.Error itself, whatever it wraps
+	var ue *url.Error
+	if errors.As(err, &ue) {
+		err = ue.Err
+	}
+	var ne net.Error
+	return errors.As(err, &ne)
+}
+
+// queueNotification adds n to the outbox after channel failed to deliver it
+// with err.
+func queueNotification(channel string, n notification, err error, now time.Time) error {
+	entries := append(loadOutbox(), outboxEntry{
+		Channel:      channel,
+		Notification: n,
+		Queued:       now,
+		Attempts:     1,
+		LastAttempt:  now,
+		NextAttempt:  now.Add(retryDelay(1)),
+		LastError:    err.Error(),
+	})
+	return saveOutbox(entries)
+}
+
+// retryOutbox retries the queued notifications that are due through the
+// channels configured in cfg. Notifications older than outboxMaxAge, or whose
+// channel is no longer configured, expire. The outcome is logged to out and
+// recorded like a regular delivery.
+func retryOutbox(cfg config, out io.Writer, now time.Time) []delivery {
+	entries := loadOutbox()
+	if len(entries) == 0 {
+		return nil
+	}
+	channels := map[string]Notifier{}
+	for _, ch := range notifiers(cfg) {
+		channels[ch.Name()] = ch
+	}
+	var results []delivery
+	kept := entries[:0]
+	for _, e := range entries {
+		switch {
+		case e.status() != "pending":
+			if now.Sub(e.finished()) > outboxKeep {
+				continue
+			}
+		case now.Sub(e.Queued) > outboxMaxAge:
+			e.Expired = now
+			logf(out, "queued notification %q via %s expired after %d attempts", e.Notification.Subject, e.Channel, e.Attempts)
+		case channels[e.Channel] == nil:
+			e.Expired = now
+			e.LastError = "channel no longer configured"
+			logf(out, "queued notification %q expired: %s is no longer configured", e.Notification.Subject, e.Channel)
+		case !now.Before(e.NextAttempt):
+			err := deliver(channels[e.Channel], e.Notification)
+			e.Attempts++
+			e.LastAttempt = now
+			switch {
+			case err != nil && !transient(err):
+				e.LastError = err.Error()
+				e.Expired = now
+				logf(out, "queued notification %q via %s expired: %v", e.Notification.Subject, e.Channel, err)
+			case err != nil:
+				e.LastError = err.Error()
+				e.NextAttempt = now.Add(retryDelay(e.Attempts))
+			default:
+				e.Delivered = now
+				logf(out, "queued notification %q delivered via %s after %d attempts", e.Notification.Subject, e.Channel, e.Attempts)
+			}
+			results = append(results, delivery{Channel: e.Channel, Err: err})
+		}
+		kept = append(kept, e)
+	}
+	if err := saveOutbox(kept); err != nil {
+		fmt.Fprintf(stderr, "failed to save notification outbox: %v\n", err)
+	}
+	if len(results) > 0 {
+		if err := recordDeliveries(results, now); err != nil {
+			fmt.Fprintf(stderr, "failed to save state: %v\n", err)
+		}
+	}
+	return results
+}
+
+// printOutbox lists the queued notifications, oldest first.
+func printOutbox(w io.Writer, entries []outboxEntry, now time.Time) {
+	if len(entries) == 0 {
+		fmt.Fprintln(w, "no queued notifications")
+		return
+	}
+	when := func(t time.Time) string { return t.Local().Format("2006-01-02 15:04") }
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "STATUS\tCHANNEL\tQUEUED\tATTEMPTS\tNEXT\tSUBJECT\tLAST ERROR")
+	for _, e := range entries {
+		next, lastErr := "now", e.LastError
+		switch e.status() {
+		case "delivered":
+			next, lastErr = "delivered "+when(e.Delivered), ""
+		case "expired":
+			next = "expired " + when(e.Expired)
+		default:
+			if e.NextAttempt.After(now) {
+				next = when(e.NextAttempt)
+			}
+		}
+		// keep the table on one line per entry
+		lastErr = strings.Join(strings.Fields(lastErr), " ")
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%s\t%s\n", e.status(), e.Channel, when(e.Queued), e.Attempts, next, e.Notification.Subject, lastErr)
+	}
+	tw.Flush()
+}
+
+// pendingNotifications counts the notifications waiting to be retried.
+func pendingNotifications() int {
+	n := 0
+	for _, e := range loadOutbox() {
+		if e.status() == "pending" {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+var errOffline = errors.New("dial tcp: connect: network is unreachable")
+
+// outboxServer serves a webhook answering with *status and returns a config
+// using it as the only channel.
+func outboxServer(t *testing.T, status *int) config {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(*status)
+	}))
+	t.Cleanup(srv.Close)
+	return config{Webhooks: []webhook{{Name: "hook", URL: srv.URL}}}
+}
+
+func TestRetryDelay(t *testing.T) {
+	for attempts, want := range map[int]time.Duration{1: time.Minute, 2: 2 * time.Minute, 4: 8 * time.Minute, 9: 256 * time.Minute, 10: outboxMaxDelay, 100: outboxMaxDelay} {
+		if got := retryDelay(attempts); got != want {
+			t.Errorf("retryDelay(%d) = %v, want %v", attempts, got, want)
+		}
+	}
+}
+
+// TestNotifyQueuesFailures queues failed deliveries, except test messages.
+func TestNotifyQueuesFailures(t *testing.T) {
+	chdir(t, t.TempDir())
+	status := http.StatusBadGateway
+	cfg := outboxServer(t, &status)
+	notify(cfg, &bytes.Buffer{}, notification{Subject: "test notification", Event: "test"})
+	if entries := loadOutbox(); len(entries) != 0 {
+		t.Fatalf("test message queued: %+v", entries)
+	}
+	notify(cfg, &bytes.Buffer{}, notification{Subject: "backup failed", Body: "disk full", Event: "backup", Status: "failure", Job: "docs"})
+	entries := loadOutbox()
+	if len(entries) != 1 {
+		t.Fatalf("got %d entries", len(entries))
+	}
+	e := entries[0]
+	if e.status() != "pending" || e.Channel != "webhook hook" || e.Attempts != 1 || e.Notification.Body != "disk full" || e.Notification.Job != "docs" || !strings.Contains(e.LastError, "502") {
+		t.Fatalf("unexpected entry: %+v", e)
+	}
+	if got := e.NextAttempt.Sub(e.LastAttempt); got != outboxRetryDelay {
+		t.Fatalf("next attempt in %v", got)
+	}
+}
+
+func TestTransient(t *testing.T) {
+	dnsErr := &net.DNSError{Err: "no such host", Name: "api.pushover.net"}
+	for _, tc := range []struct {
+		err  error
+		want bool
+	}{
+		{&url.Error{Op: "Post", URL: "https://api.pushover.net", Err: dnsErr}, true},
+		{&url.Error{Op: "Post", URL: "ftp://hook", Err: errors.New(`unsupported protocol scheme "ftp"`)}, false},
+		{&maskedError{msg: "masked", err: &serviceError{Code: http.StatusBadGateway}}, true},
+		{&serviceError{Code: http.StatusTooManyRequests}, true},
+		{&serviceError{Code: http.StatusBadRequest}, false},
+		{fmt.Errorf("email: %w", &textproto.Error{Code: 421, Msg: "try again later"}), true},
+		{fmt.Errorf("email: %w", &textproto.Error{Code: 550, Msg: "no such user"}), false},
+		{fmt.Errorf("email: email-to: %w", errors.New("mail: no address")), false},
+		{fmt.Errorf("body template: %w", errors.New("can't evaluate field Repo")), false},
+		{context.DeadlineExceeded, true},
+	} {
+		if got := transient(tc.err); got != tc.want {
+			t.Errorf("transient(%v) = %v, want %v", tc.err, got, tc.want)
+		}
+	}
+}
+
+// TestNotifyPermanentFailures neither queues nor retries deliveries the
+// service rejected.
+func TestNotifyPermanentFailures(t *testing.T) {
+	chdir(t, t.TempDir())
+	status := http.StatusUnauthorized
+	cfg := outboxServer(t, &status)
+	var out bytes.Buffer
+	notify(cfg, &out, notification{Subject: "backup failed", Event: "backup"})
+	if entries := loadOutbox(); len(entries) != 0 {
+		t.Fatalf("permanent failure queued: %+v", entries)
+	}
+	if !strings.Contains(out.String(), "notification via webhook hook failed: webhook hook: 401 Unauthorized") {
+		t.Fatalf("failure not logged: %q", out.String())
+	}
+
+	// a channel that starts rejecting a queued message expires it
+	now := time.Now().Round(0)
+	queueNotification("webhook hook", notification{Subject: "backup failed"}, errOffline, now)
+	if results := retryOutbox(cfg, &out, now.Add(time.Minute)); len(results) != 1 || results[0].Err == nil {
+		t.Fatalf("unexpected results: %+v", results)
+	}
+	if e := loadOutbox()[0]; e.status() != "expired" || !strings.Contains(e.LastError, "401") {
+		t.Fatalf("unexpected entry: %+v", e)
+	}
+}
+
+func TestRetryOutbox(t *testing.T) {
+	chdir(t, t.TempDir())
+	status := http.StatusBadGateway
+	cfg := outboxServer(t, &status)
+	now := time.Now().Round(0)
+	queueNotification("webhook hook", notification{Subject: "backup failed"}, errOffline, now)
+	queueNotification("pushover", notification{Subject: "check failed"}, errOffline, now)
+	queueNotification("webhook hook", notification{Subject: "old"}, errOffline, now.Add(-outboxMaxAge-time.Hour))
+
+	var out bytes.Buffer
+	if results := retryOutbox(cfg, &out, now.Add(30*time.Second)); len(results) != 0 {
+		t.Fatalf("retried before the delay: %+v", results)
+	}
+	entries := loadOutbox()
+	if entries[0].status() != "pending" || entries[1].status() != "expired" || entries[2].status() != "expired" {
+		t.Fatalf("unexpected entries: %+v", entries)
+	}
+	if !strings.Contains(out.String(), `"check failed" expired: pushover is no longer configured`) || !strings.Contains(out.String(), `"old" via webhook hook expired after 1 attempts`) {
+		t.Fatalf("unexpected output: %q", out.String())
+	}
+
+	// still offline: the delay doubles
+	now = now.Add(time.Minute)
+	if results := retryOutbox(cfg, &out, now); len(results) != 1 || results[0].Err == nil {
+		t.Fatalf("unexpected results: %+v", results)
+	}
+	if e := loadOutbox()[0]; e.Attempts != 2 || !e.NextAttempt.Equal(now.Add(2*time.Minute)) {
+		t.Fatalf("unexpected entry: %+v", e)
+	}
+
+	status = http.StatusOK
+	now = now.Add(2 * time.Minute)
+	if results := retryOutbox(cfg, &out, now); len(results) != 1 || results[0].Err != nil {
+		t.Fatalf("unexpected results: %+v", results)
+	}
+	if e := loadOutbox()[0]; e.status() != "delivered" || e.Attempts != 3 {
+		t.Fatalf("unexpected entry: %+v", e)
+	}
+	if cs := loadState().Notifications["webhook hook"]; cs.LastError != "" || !cs.LastSuccess.Equal(now) {
+		t.Fatalf("delivery not recorded: %+v", cs)
+	}
+	if pendingNotifications() != 0 {
+		t.Fatalf("notifications still pending")
+	}
+
+	// finished entries are dropped after a while
+	retryOutbox(cfg, &out, now.Add(outboxKeep+time.Minute))
+	if entries := loadOutbox(); len(entries) != 0 {
+		t.Fatalf("finished entries kept: %+v", entries)
+	}
+}
+
+func TestDaemonRetriesOutbox(t *testing.T) {
+	chdir(t, t.TempDir())
+	d, _, _, _ := testDaemon(t, []config{{Job: "docs", Schedule: "1h"}}, false)
+	retries := 0
+	d.retry = func() { retries++ }
+	d.tick()
+	d.tick()
+	if retries != 2 {
+		t.Fatalf("outbox retried %d times", retries)
+	}
+}
+
+func TestRunCLINotifyQueue(t *testing.T) {
+	chdir(t, t.TempDir())
+	out, _ := captureOutput(t)
+	if code := runCLI([]string{"notify", "queue"}); code != 0 || out.String() != "no queued notifications\n" {
+		t.Fatalf("unexpected result: %d %q", code, out.String())
+	}
+	now := time.Now()
+	queueNotification("pushover", notification{Subject: "backup failed"}, errOffline, now)
+	entries := loadOutbox()
+	entries = append(entries, outboxEntry{Channel: "email", Notification: notification{Subject: "check failed"}, Queued: now, Attempts: 2, Delivered: now})
+	saveOutbox(entries)
+	out.Reset()
+	if code := runCLI([]string{"notify", "queue"}); code != 0 {
+		t.Fatalf("unexpected exit code %d", code)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(strings.Join(strings.Fields(lines[1]), " "), "pending pushover") || !strings.Contains(lines[1], "network is unreachable") ||
+		!strings.HasPrefix(strings.Join(strings.Fields(lines[2]), " "), "delivered email") || !strings.Contains(lines[2], "check failed") {
+		t.Fatalf("unexpected queue: %q", out.String())
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"text/tabwriter"
+)
+
+// appName names the per-user directories of the program.
+const appName = "backup"
+
+// appDirs holds the per-user directories the program keeps its files in.
+type appDirs struct {
+	// Config holds config.json.
+	Config string
+	// Data holds the downloaded restic binary.
+	Data string
+	// State holds the state file, run history, log and remote config cache.
+	State string
+}
+
+// userDirs returns the directories used for the current user. Tests replace
+// it to keep their files in a temporary directory.
+var userDirs = func() appDirs {
+	home, _ := os.UserHomeDir()
+	return platformDirs(runtime.GOOS, home, os.Getenv)
+}
+
+// platformDirs resolves the per-user directories for goos. On Linux and other
+// Unix systems the XDG base directory variables are honoured, macOS uses
+// ~/Library and Windows %APPDATA% and %LOCALAPPDATA%.
+func platformDirs(goos, home string, getenv func(string) string) appDirs {
+	dir := func(env string, fallback ...string) string {
+		if v := getenv(env); filepath.IsAbs(v) {
+			return filepath.Join(v, appName)
+		}
+		return filepath.Join(append([]string{home}, append(fallback, appName)...)...)
+	}
+	switch goos {
+	case "windows":
+		return appDirs{
+			Config: dir("APPDATA", "AppData", "Roaming"),
+			Data:   dir("LOCALAPPDATA", "AppData", "Local"),
+			State:  dir("LOCALAPPDATA", "AppData", "Local"),
+		}
+	case "darwin":
+		support := filepath.Join(home, "Library", "Application Support", appName)
+		return appDirs{Config: support, Data: support, State: support}
+	default:
+		return appDirs{
+			Config: dir("XDG_CONFIG_HOME", ".config"),
+			Data:   dir("XDG_DATA_HOME", ".local", "share"),
+			State:  dir("XDG_STATE_HOME", ".local", "state"),
+		}
+	}
+}
+
+// configPath returns the location of a file in the config directory.
+func configPath(name string) string {
+	return filepath.Join(userDirs().Config, name)
+}
+
+// dataPath returns the location of a file in the data directory.
+func dataPath(name ...string) string {
+	return filepath.Join(append([]string{userDirs().Data}, name...)...)
+}
+
+// statePath returns the location of a file in the state directory.
+func statePath(name string) string {
+	return filepath.Join(userDirs().State, name)
+}
+
+// writeFile writes data to path, creating its directory first.
+func writeFile(path string, data []byte, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, perm)
+}
+
+// appendFile opens path for appending, creating it and its directory first.
+func appendFile(path string) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+}
+
+// programFile describes a file shown by the paths command.
+type programFile struct {
+	Name string
+	Path string
+}
+
+// programFiles lists the files the program reads and writes.
+func programFiles() []programFile {
+	return []programFile{
+		{"config", configPath(configFile)},
+		{"restic", managedResticPath()},
+		{"state", statePath(stateFile)},
+		{"history", statePath(historyFile)},
+		{"log", statePath(logFile)},
+		{"locks", statePath(lockDir)},
+		{"remote config cache", statePath(remoteConfigCacheFile)},
+		{"notification outbox", statePath(outboxFile)},
+	}
+}
+
+// printPaths writes the location of every program file to w, marking files
+// that do not exist yet.
+func printPaths(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, f := range programFiles() {
+		note := ""
+		if _, err := os.Stat(f.Path); err != nil {
+			note = " (not created yet)"
+		}
+		fmt.Fprintf(tw, "%s\t%s%s\n", f.Name, f.Path, note)
+	}
+	tw.Flush()
+}
+
+// migrateLegacyFiles copies files that older versions kept in the working
+// directory, or next to the executable, into the per-user directories.
+// Existing files in the new location are never overwritten and the old files
+// are left in place.
+func migrateLegacyFiles() {
+	var dirs []string
+	if wd, err := os.Getwd(); err == nil {
+		dirs = append(dirs, wd)
+	}
+	if exe, err := os.Executable(); err == nil {
+		dirs = append(dirs, filepath.Dir(exe))
+	}
+	files := map[string]string{
+		configFile:            configPath(configFile),
+		stateFile:             statePath(stateFile),
+		historyFile:           statePath(historyFile),
+		logFile:               statePath(logFile),
+		remoteConfigCacheFile: statePath(remoteConfigCacheFile),
+	}
+	for name, dst := range files {
+		for _, dir := range dirs {
+			src := filepath.Join(dir, name)
+			if src == dst {
+				break
+			}
+			if _, err := os.Stat(dst); err == nil {
+				break
+			}
+			data, err := os.ReadFile(src)
+			if err != nil {
+				continue
+			}
+			if err := writeFile(dst, data, 0600); err != nil {
+				fmt.Fprintf(stderr, "failed to migrate %s: %v\n", src, err)
+				break
+			}
+			fmt.Fprintf(stderr, "copied %s to %s\n", src, dst)
+			break
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPlatformDirs(t *testing.T) {
+	env := map[string]string{}
+	getenv := func(k string) string { return env[k] }
+
+	d := platformDirs("linux", "/home/u", getenv)
+	want := appDirs{
+		Config: "/home/u/.config/backup",
+		Data:   "/home/u/.local/share/backup",
+		State:  "/home/u/.local/state/backup",
+	}
+	if d != want {
+		t.Fatalf("unexpected defaults: %+v", d)
+	}
+
+	env["XDG_CONFIG_HOME"] = "/xdg/config"
+	env["XDG_DATA_HOME"] = "relative/ignored"
+	env["XDG_STATE_HOME"] = "/xdg/state"
+	d = platformDirs("linux", "/home/u", getenv)
+	want = appDirs{
+		Config: "/xdg/config/backup",
+		Data:   "/home/u/.local/share/backup",
+		State:  "/xdg/state/backup",
+	}
+	if d != want {
+		t.Fatalf("unexpected XDG dirs: %+v", d)
+	}
+
+	d = platformDirs("darwin", "/Users/u", getenv)
+	if d.Config != "/Users/u/Library/Application Support/backup" || d.State != d.Config || d.Data != d.Config {
+		t.Fatalf("unexpected macOS dirs: %+v", d)
+	}
+}
+
+// TestChdirIndependence keeps using the same files after the working
+// directory changes, which is what happens when the program is autostarted.
+func TestChdirIndependence(t *testing.T) {
+	dirs := appDirs{Config: t.TempDir(), Data: t.TempDir(), State: t.TempDir()}
+	old := userDirs
+	userDirs = func() appDirs { return dirs }
+	defer func() { userDirs = old }()
+
+	chdirOnly(t, t.TempDir())
+	if err := saveState(state{jobState: jobState{CheckPart: 4}}); err != nil {
+		t.Fatalf("saveState: %v", err)
+	}
+	chdirOnly(t, t.TempDir())
+	if st := loadState(); st.CheckPart != 4 {
+		t.Fatalf("state not found after chdir: %+v", st)
+	}
+	if _, err := os.Stat(filepath.Join(dirs.State, stateFile)); err != nil {
+		t.Fatalf("state not in state dir: %v", err)
+	}
+	if !strings.HasPrefix(managedResticPath(), dirs.Data) {
+		t.Fatalf("restic not in data dir: %s", managedResticPath())
+	}
+}
+
+// TestMigrateLegacyFiles starts from the layout of older versions, which
+// kept config.json and state.json in the working directory.
+func TestMigrateLegacyFiles(t *testing.T) {
+	dirs := appDirs{Config: t.TempDir(), Data: t.TempDir(), State: t.TempDir()}
+	old := userDirs
+	userDirs = func() appDirs { return dirs }
+	defer func() { userDirs = old }()
+	_, errOut := captureOutput(t)
+
+	legacy := t.TempDir()
+	chdirOnly(t, legacy)
+	os.WriteFile(configFile, []byte(`{"repo":"/mnt/backup"}`), 0644)
+	os.WriteFile(stateFile, []byte(`{"check-part":4}`), 0644)
+	os.WriteFile(filepath.Join(dirs.State, historyFile), []byte("new\n"), 0644)
+	os.WriteFile(historyFile, []byte("old\n"), 0644)
+
+	migrateLegacyFiles()
+	if data, err := os.ReadFile(configPath(configFile)); err != nil || string(data) != `{"repo":"/mnt/backup"}` {
+		t.Fatalf("config not migrated: %q %v", data, err)
+	}
+	if st := loadState(); st.CheckPart != 4 {
+		t.Fatalf("state not migrated: %+v", st)
+	}
+	if data, _ := os.ReadFile(statePath(historyFile)); string(data) != "new\n" {
+		t.Fatalf("existing file overwritten: %q", data)
+	}
+	if _, err := os.Stat(filepath.Join(legacy, configFile)); err != nil {
+		t.Fatalf("old file removed: %v", err)
+	}
+	if !strings.Contains(errOut.String(), "copied "+filepath.Join(legacy, configFile)) {
+		t.Fatalf("unexpected output: %q", errOut.String())
+	}
+}
+
+// chdirOnly changes the working directory without touching userDirs.
+func chdirOnly(t *testing.T, dir string) {
+	t.Helper()
+	old, _ := os.Getwd()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("chdir: %v", err)
+	}
+	t.Cleanup(func() { os.Chdir(old) })
+}
+
+func TestRunCLIPaths(t *testing.T) {
+	dir := t.TempDir()
+	chdir(t, dir)
+	os.WriteFile(filepath.Join(dir, configFile), []byte("{}"), 0644)
+	out, _ := captureOutput(t)
+	if code := runCLI([]string{"paths"}); code != 0 {
+		t.Fatalf("unexpected exit code %d", code)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != len(programFiles()) {
+		t.Fatalf("unexpected output: %q", out.String())
+	}
+	if !strings.HasPrefix(lines[0], "config") || !strings.Contains(lines[0], filepath.Join(dir, configFile)) || strings.Contains(lines[0], "not created") {
+		t.Fatalf("unexpected config line: %q", lines[0])
+	}
+	if !strings.Contains(out.String(), filepath.Join(dir, historyFile)+" (not created yet)") {
+		t.Fatalf("missing history line: %q", out.String())
+	}
+}
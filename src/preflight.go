@@ -0,0 +1,190 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// largestDirs is how many of the biggest directories a scan reports.
+const largestDirs = 5
+
+// maxListed limits the missing and unreadable paths printed by printScan.
+const maxListed = 10
+
+// diskFree returns the free space at a path. Tests replace it.
+var diskFree = freeSpace
+
+// scanResult is the outcome of the pre-flight scan of a job's paths.
+type scanResult struct {
+	Job string `json:"job"`
+	// Files and Bytes count the regular files that will be backed up,
+	// after the exclusions are applied.
+	Files   int64      `json:"files"`
+	Bytes   uint64     `json:"bytes"`
+	Largest []dirUsage `json:"largest-directories,omitempty"`
+	Missing []string   `json:"missing,omitempty"`
+	Denied  []string   `json:"permission-denied,omitempty"`
+	Errors  []string   `json:"errors,omitempty"`
+	// Repo is the free space of a local repository.
+	Repo     *repoSpace `json:"repository,omitempty"`
+	Warnings []string   `json:"warnings,omitempty"`
+}
+
+// dirUsage is the size of a directory directly below a backed up path.
+type dirUsage struct {
+	Path  string `json:"path"`
+	Files int64  `json:"files"`
+	Bytes uint64 `json:"bytes"`
+}
+
+// repoSpace describes the disk holding a local repository.
+type repoSpace struct {
+	Path string `json:"path"`
+	Free uint64 `json:"free"`
+	// Needed is the space the backup may need: the size of the files for a
+	// new repository, a tenth of it for an existing one.
+	Needed uint64 `json:"needed"`
+}
+
+// scanPaths walks the paths of a job like restic would, applying its
+// exclusions, and reports their size, the largest directories and paths that
+// are missing or cannot be read. For a local repository it also checks that
+// the target disk has room for the backup.
+func scanPaths(cfg config) scanResult {
+	res := scanResult{Job: cfg.Job}
+	m, err := cfg.exclusions.matcher()
+	if err != nil {
+		res.Warnings = append(res.Warnings, err.Error())
+	}
+	for _, p := range cfg.Paths {
+		root := expandUser(p)
+		if _, err := os.Lstat(root); err != nil {
+			res.addError(root, err)
+			continue
+		}
+		children := map[string]*dirUsage{}
+		filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				res.addError(path, err)
+				return nil
+			}
+			if path != root && m.excluded(path, d) {
+				if d.IsDir() {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil || m.tooLarge(info.Size()) {
+				return nil
+			}
+			res.Files++
+			res.Bytes += uint64(info.Size())
+			if rel, err := filepath.Rel(root, path); err == nil {
+				if i := strings.IndexRune(rel, filepath.Separator); i > 0 {
+					child := filepath.Join(root, rel[:i])
+					u := children[child]
+					if u == nil {
+						u = &dirUsage{Path: child}
+						children[child] = u
+					}
+					u.Files++
+					u.Bytes += uint64(info.Size())
+				}
+			}
+			return nil
+		})
+		for _, u := range children {
+			res.Largest = append(res.Largest, *u)
+		}
+	}
+	sort.Slice(res.Largest, func(i, j int) bool { return res.Largest[i].Bytes > res.Largest[j].Bytes })
+	if len(res.Largest) > largestDirs {
+		res.Largest = res.Largest[:largestDirs]
+	}
+	res.checkSpace(cfg.Repo)
+	return res
+}
+
+// addError records a path that could not be scanned.
+func (r *scanResult) addError(path string, err error) {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		r.Missing = append(r.Missing, path)
+	case errors.Is(err, fs.ErrPermission):
+		r.Denied = append(r.Denied, path)
+	default:
+		r.Errors = append(r.Errors, err.Error())
+	}
+}
+
+// checkSpace records the free space of a local repository and warns when it
+// is smaller than the backup may need.
+func (r *scanResult) checkSpace(repo string) {
+	if repo == "" || repoBackend(repo) != "local" {
+		return
+	}
+	dir := expandUser(strings.TrimPrefix(repo, "local:"))
+	needed := r.Bytes
+	if _, err := os.Stat(filepath.Join(dir, "config")); err == nil {
+		needed /= 10
+	}
+	// a new repository is created on the first backup, so measure the
+	// nearest directory that exists
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			break
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return
+		}
+		dir = parent
+	}
+	free, err := diskFree(dir)
+	if err != nil {
+		return
+	}
+	r.Repo = &repoSpace{Path: dir, Free: free, Needed: needed}
+	if free < needed {
+		r.Warnings = append(r.Warnings, fmt.Sprintf("only %s free at %s, the backup may need up to %s", formatBytes(free), dir, formatBytes(needed)))
+	}
+}
+
+// printScan writes a scan result to w, indenting every line by indent.
+func printScan(w io.Writer, indent string, r scanResult) {
+	fmt.Fprintf(w, "%sto back up: %d files, %s\n", indent, r.Files, formatBytes(r.Bytes))
+	if len(r.Largest) > 0 {
+		fmt.Fprintf(w, "%slargest directories:\n", indent)
+		for _, u := range r.Largest {
+			fmt.Fprintf(w, "%s - %s: %s\n", indent, u.Path, formatBytes(u.Bytes))
+		}
+	}
+	list := func(what string, paths []string) {
+		for i, p := range paths {
+			if i == maxListed {
+				fmt.Fprintf(w, "%s%s: %d more\n", indent, what, len(paths)-maxListed)
+				break
+			}
+			fmt.Fprintf(w, "%s%s: %s\n", indent, what, p)
+		}
+	}
+	list("missing path", r.Missing)
+	list("permission denied", r.Denied)
+	list("scan error", r.Errors)
+	if r.Repo != nil {
+		fmt.Fprintf(w, "%srepository disk: %s free at %s\n", indent, formatBytes(r.Repo.Free), r.Repo.Path)
+	}
+	for _, warn := range r.Warnings {
+		fmt.Fprintf(w, "%swarning: %s\n", indent, warn)
+	}
+}
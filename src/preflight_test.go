@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// writeTree creates files with the given sizes below dir.
+func writeTree(t *testing.T, dir string, files map[string]int) {
+	t.Helper()
+	for name, size := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := writeFile(p, bytes.Repeat([]byte("x"), size), 0644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+}
+
+func TestScanPaths(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]int{
+		"home/notes.txt":            5,
+		"home/docs/a.txt":           10,
+		"home/docs/sub/b.txt":       20,
+		"home/videos/v.mp4":         100,
+		"home/videos/big.iso":       3000,
+		"home/node_modules/x.js":    50,
+		"home/.cache/thumb.png":     50,
+		"home/build/CACHEDIR.TAG":   0,
+		"home/private/.nobackup":    0,
+		"home/private/secret.txt":   50,
+		"home/docs/report.tmp":      50,
+		"home/docs/sub/keep.TMP":    1,
+		"home/docs/sub/deep/x.bak":  50,
+		"home/docs/sub/deep/y.bak2": 1,
+	})
+	os.WriteFile(filepath.Join(dir, "home/build/CACHEDIR.TAG"), []byte(cacheDirSignature+"\n"), 0644)
+	excludes := filepath.Join(dir, "excludes.txt")
+	os.WriteFile(excludes, []byte("# comment\n\ndocs/**/*.bak\n"), 0644)
+	home := filepath.Join(dir, "home")
+	cfg := config{Job: "docs", Paths: []string{home, filepath.Join(dir, "missing")}, exclusions: exclusions{
+		Exclude:           []string{"*.tmp"},
+		ExcludeFile:       []string{excludes},
+		ExcludeIfPresent:  []string{".nobackup"},
+		ExcludeLargerThan: "2k",
+		ExcludePresets:    []string{"linux-home"},
+	}}
+	r := scanPaths(cfg)
+	if r.Files != 6 || r.Bytes != 137 {
+		t.Fatalf("unexpected size: %d files, %d bytes", r.Files, r.Bytes)
+	}
+	if len(r.Largest) != 2 || r.Largest[0].Path != filepath.Join(home, "videos") || r.Largest[1].Bytes != 32 {
+		t.Fatalf("unexpected largest directories: %+v", r.Largest)
+	}
+	if len(r.Missing) != 1 || r.Missing[0] != filepath.Join(dir, "missing") || len(r.Warnings) != 0 {
+		t.Fatalf("unexpected problems: %+v", r)
+	}
+	var out bytes.Buffer
+	printScan(&out, "  ", r)
+	for _, s := range []string{"  to back up: 6 files, 137 B", " - " + filepath.Join(home, "videos") + ": 100 B", "  missing path: "} {
+		if !strings.Contains(out.String(), s) {
+			t.Fatalf("output missing %q: %q", s, out.String())
+		}
+	}
+	data, _ := json.Marshal(r)
+	if !strings.Contains(string(data), `"files":6,"bytes":137,"largest-directories":[`) {
+		t.Fatalf("unexpected JSON: %s", data)
+	}
+}
+
+// TestRunCLIScanJSON prints only the scan results on stdout, so they can be
+// piped to a JSON parser.
+func TestRunCLIScanJSON(t *testing.T) {
+	dir := t.TempDir()
+	chdir(t, dir)
+	writeTree(t, dir, map[string]int{"home/a.txt": 10})
+	out, errOut := captureOutput(t)
+	restore := withHTTPClient(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		body, _ := json.Marshal(map[string]any{"restic-repo": filepath.Join(dir, "repo"), "paths": []string{filepath.Join(dir, "home")}})
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(body)), Header: make(http.Header), Request: req}, nil
+	}))
+	defer restore()
+	if code := runCLI([]string{"scan", "-json"}); code != 0 {
+		t.Fatalf("unexpected exit code %d: %s", code, errOut.String())
+	}
+	var results []scanResult
+	if err := json.Unmarshal(out.Bytes(), &results); err != nil {
+		t.Fatalf("stdout is not JSON (%v): %q", err, out.String())
+	}
+	if len(results) != 1 || results[0].Files != 1 || results[0].Bytes != 10 {
+		t.Fatalf("unexpected results: %+v", results)
+	}
+}
+
+func TestScanPermissionDenied(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root can read every directory")
+	}
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]int{"locked/a.txt": 1, "open/b.txt": 2})
+	locked := filepath.Join(dir, "locked")
+	if err := os.Chmod(locked, 0); err != nil {
+		t.Fatalf("chmod: %v", err)
+	}
+	t.Cleanup(func() { os.Chmod(locked, 0755) })
+	r := scanPaths(config{Paths: []string{dir}})
+	if len(r.Denied) != 1 || r.Denied[0] != locked || r.Files != 1 {
+		t.Fatalf("unexpected result: %+v", r)
+	}
+}
+
+// TestScanFreeSpace warns when the disk of a local repository is too small.
+func TestScanFreeSpace(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]int{"data/a": 1000})
+	var measured string
+	old := diskFree
+	diskFree = func(path string) (uint64, error) { measured = path; return 500, nil }
+	t.Cleanup(func() { diskFree = old })
+
+	r := scanPaths(config{Repo: filepath.Join(dir, "disk", "repo"), Paths: []string{filepath.Join(dir, "data")}})
+	if measured != dir || r.Repo == nil || r.Repo.Needed != 1000 || len(r.Warnings) != 1 || !strings.Contains(r.Warnings[0], "only 500 B free") {
+		t.Fatalf("unexpected result: %q %+v", measured, r)
+	}
+	// an existing repository only needs room for the changes
+	writeTree(t, dir, map[string]int{"disk/repo/config": 1})
+	if r := scanPaths(config{Repo: "local:" + filepath.Join(dir, "disk", "repo"), Paths: []string{filepath.Join(dir, "data")}}); r.Repo.Needed != 100 || len(r.Warnings) != 0 {
+		t.Fatalf("unexpected result: %+v", r)
+	}
+	if r := scanPaths(config{Repo: "sftp:host:/repo", Paths: []string{filepath.Join(dir, "data")}}); r.Repo != nil {
+		t.Fatalf("free space checked for a remote repository: %+v", r.Repo)
+	}
+}
+
+func TestExcludeMatcher(t *testing.T) {
+	m, err := exclusions{Exclude: []string{"/srv/skip", "*.o", "a/**/c"}, ExcludePresets: []string{"macos-home"}}.matcher()
+	if err != nil {
+		t.Fatalf("matcher: %v", err)
+	}
+	entries, _ := fs.ReadDir(fstest.MapFS{"f": {}}, ".")
+	file := entries[0]
+	tests := map[string]bool{
+		"/srv/skip":                       true,
+		"/home/srv/skip":                  false,
+		"/x/main.o":                       true,
+		"/x/main.go":                      false,
+		"/x/a/c":                          true,
+		"/x/a/b/b/c":                      true,
+		"/Users/anna/Library/caches":      true,
+		"/Users/anna/Library/Preferences": false,
+		"/Users/anna/Pictures/.ds_store":  true,
+	}
+	for p, want := range tests {
+		if got := m.excluded(filepath.FromSlash(p), file); got != want {
+			t.Errorf("excluded(%q) = %v, want %v", p, got, want)
+		}
+	}
+	if _, err := (exclusions{ExcludeFile: []string{"/does/not/exist"}}).matcher(); err == nil {
+		t.Fatalf("expected error for a missing exclude file")
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"backup/internal/restic"
+)
+
+// progress is the state of a running backup derived from restic's status
+// messages.
+type progress struct {
+	PercentDone float64       `json:"percent_done"`
+	FilesDone   uint64        `json:"files_done"`
+	TotalFiles  uint64        `json:"total_files"`
+	BytesDone   uint64        `json:"bytes_done"`
+	TotalBytes  uint64        `json:"total_bytes"`
+	ETA         time.Duration `json:"eta"`
+	CurrentFile string        `json:"current_file,omitempty"`
+	Errors      int           `json:"errors"`
+}
+
+// update applies a restic status message to the progress.
+func (p *progress) update(s restic.BackupStatus) {
+	p.PercentDone = s.PercentDone * 100
+	p.FilesDone = s.FilesDone
+	p.TotalFiles = s.TotalFiles
+	p.BytesDone = s.BytesDone
+	p.TotalBytes = s.TotalBytes
+	p.ETA = time.Duration(s.SecondsRemaining) * time.Second
+	if len(s.CurrentFiles) > 0 {
+		p.CurrentFile = s.CurrentFiles[0]
+	}
+}
+
+// line renders the progress as a single line of at most width characters.
+func (p progress) line(width int) string {
+	s := fmt.Sprintf("%5.1f%%  %d/%d files  %s/%s", p.PercentDone, p.FilesDone, p.TotalFiles, formatBytes(p.BytesDone), formatBytes(p.TotalBytes))
+	if p.ETA > 0 {
+		s += "  ETA " + p.ETA.String()
+	}
+	if p.CurrentFile != "" && len(s)+2 < width {
+		s += "  " + shortenPath(p.CurrentFile, width-len(s)-2)
+	}
+	if len(s) > width {
+		s = s[:width]
+	}
+	return s
+}
+
+// progressLine redraws a single terminal line with the current progress.
+type progressLine struct {
+	w     io.Writer
+	width int
+	last  time.Time
+	shown bool
+}
+
+// draw replaces the current line with p, at most a few times per second.
+func (l *progressLine) draw(p progress) {
+	if time.Since(l.last) < 250*time.Millisecond {
+		return
+	}
+	l.last = time.Now()
+	fmt.Fprintf(l.w, "\r%-*s", l.width, p.line(l.width))
+	l.shown = true
+}
+
+// clear erases the progress line so regular output can follow.
+func (l *progressLine) clear() {
+	if l.shown {
+		fmt.Fprintf(l.w, "\r%s\r", strings.Repeat(" ", l.width))
+		l.shown = false
+	}
+}
+
+// shortenPath trims the middle of p so it fits into max characters.
+func shortenPath(p string, max int) string {
+	if len(p) <= max {
+		return p
+	}
+	if max <= 3 {
+		return ""
+	}
+	keep := max - 3
+	return p[:keep/2] + "..." + p[len(p)-(keep-keep/2):]
+}
+
+// formatBytes formats n using decimal units, e.g. 1.2 GB.
+func formatBytes(n uint64) string {
+	const unit = 1000
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "kMGTPE"[exp])
+}
+
+// summaryText describes a finished backup in one sentence for notifications.
+func summaryText(sum *restic.BackupSummary, errors int) string {
+	s := fmt.Sprintf("backed up %s, %d new files, %d changed files", formatBytes(sum.DataAdded), sum.FilesNew, sum.FilesChanged)
+	if sum.TotalDuration > 0 {
+		s += fmt.Sprintf(" in %s", (time.Duration(sum.TotalDuration) * time.Second).String())
+	}
+	if errors > 0 {
+		s += fmt.Sprintf(", %d files could not be read", errors)
+	}
+	return s
+}
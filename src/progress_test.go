@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"backup/internal/restic"
+)
+
+func TestFormatBytes(t *testing.T) {
+	cases := map[uint64]string{
+		0:          "0 B",
+		999:        "999 B",
+		1000:       "1.0 kB",
+		1234567:    "1.2 MB",
+		1200000000: "1.2 GB",
+	}
+	for n, exp := range cases {
+		if got := formatBytes(n); got != exp {
+			t.Errorf("formatBytes(%d) = %q, want %q", n, got, exp)
+		}
+	}
+}
+
+func TestProgressLine(t *testing.T) {
+	var p progress
+	p.update(restic.BackupStatus{
+		PercentDone:      0.452,
+		FilesDone:        12,
+		TotalFiles:       40,
+		BytesDone:        1200000,
+		TotalBytes:       3400000,
+		SecondsRemaining: 125,
+		CurrentFiles:     []string{"/home/user/Documents/very/long/path/to/some/file/that/does/not/fit.txt"},
+	})
+	line := p.line(80)
+	if len(line) > 80 {
+		t.Fatalf("line too long: %d", len(line))
+	}
+	for _, s := range []string{" 45.2%", "12/40 files", "1.2 MB/3.4 MB", "ETA 2m5s", "...", "fit.txt"} {
+		if !strings.Contains(line, s) {
+			t.Fatalf("line missing %q: %q", s, line)
+		}
+	}
+}
+
+func TestProgressLineDraw(t *testing.T) {
+	var b bytes.Buffer
+	l := &progressLine{w: &b, width: 20}
+	l.draw(progress{PercentDone: 10})
+	l.draw(progress{PercentDone: 20})
+	if strings.Count(b.String(), "\r") != 1 {
+		t.Fatalf("expected throttled draw: %q", b.String())
+	}
+	l.clear()
+	if !strings.HasSuffix(b.String(), strings.Repeat(" ", 20)+"\r") {
+		t.Fatalf("line not cleared: %q", b.String())
+	}
+}
+
+func TestSummaryTextDuration(t *testing.T) {
+	got := summaryText(&restic.BackupSummary{DataAdded: 500, FilesNew: 1, TotalDuration: 90}, 0)
+	if got != "backed up 500 B, 1 new files, 0 changed files in "+(90*time.Second).String() {
+		t.Fatalf("unexpected summary: %q", got)
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+const remoteConfigCacheFile = "remote-config-cache.json"
+
+// remoteConfigCache is the last good remote configuration kept on disk for
+// conditional requests and offline use.
+type remoteConfigCache struct {
+	URL          string    `json:"url"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last-modified,omitempty"`
+	FetchedAt    time.Time `json:"fetched-at"`
+	Body         []byte    `json:"body"`
+	Signature    []byte    `json:"signature,omitempty"`
+}
+
+// remoteConfigInfo describes where a loaded remote configuration came from.
+type remoteConfigInfo struct {
+	Signature signatureStatus
+	FetchedAt time.Time
+	// Warning is set when the cached copy was used because fetching or
+	// verifying a fresh copy failed.
+	Warning error
+}
+
+// fetchPastebinConfig retrieves JSON configuration from the provided Pastebin
+// URL and verifies its signature.
+func fetchPastebinConfig(url string) (map[string]any, error) {
+	data, _, err := fetchRemoteConfig(url)
+	return data, err
+}
+
+// fetchRemoteConfig retrieves and verifies the remote configuration without
+// using the cache and reports the state of its signature.
+func fetchRemoteConfig(url string) (map[string]any, signatureStatus, error) {
+	doc, _, err := fetchRemoteDocument(url, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	return doc.verify()
+}
+
+// loadRemoteConfig returns the remote configuration at url. It sends a
+// conditional request based on the cached copy, stores verified fresh copies
+// and falls back to the cached copy when the remote one is unavailable or
+// rejected.
+func loadRemoteConfig(url string) (map[string]any, remoteConfigInfo, error) {
+	prev := readRemoteConfigCache(url)
+	doc, notModified, err := fetchRemoteDocument(url, prev)
+	if err == nil {
+		data, status, verr := doc.verify()
+		if verr == nil {
+			if !notModified {
+				if serr := writeRemoteConfigCache(doc); serr != nil {
+					fmt.Fprintf(os.Stderr, "failed to cache pastebin config: %v\n", serr)
+				}
+			}
+			return data, remoteConfigInfo{Signature: status, FetchedAt: doc.FetchedAt}, nil
+		}
+		if notModified {
+			// the cached copy itself no longer verifies
+			return nil, remoteConfigInfo{Signature: status}, verr
+		}
+		err = verr
+	}
+	if prev != nil {
+		if data, status, verr := prev.verify(); verr == nil {
+			return data, remoteConfigInfo{Signature: status, FetchedAt: prev.FetchedAt, Warning: err}, nil
+		}
+	}
+	return nil, remoteConfigInfo{}, err
+}
+
+// fetchRemoteDocument downloads the document at url. With prev it sends
+// If-None-Match and If-Modified-Since and returns prev with notModified set
+// when the server answers 304.
+func fetchRemoteDocument(url string, prev *remoteConfigCache) (doc *remoteConfigCache, notModified bool, err error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	if prev != nil {
+		if prev.ETag != "" {
+			req.Header.Set("If-None-Match", prev.ETag)
+		}
+		if prev.LastModified != "" {
+			req.Header.Set("If-Modified-Since", prev.LastModified)
+		}
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified && prev != nil {
+		return prev, true, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, false, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, false, err
+	}
+	return &remoteConfigCache{
+		URL:          url,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		FetchedAt:    time.Now(),
+		Body:         body,
+	}, false, nil
+}
+
+// verify checks the signature of the document and decodes it. A detached
+// signature is fetched from URL+".sig" once and kept with the document.
+func (c *remoteConfigCache) verify() (map[string]any, signatureStatus, error) {
+	payload, status, err := verifyRemoteConfig(c.Body, func() ([]byte, error) {
+		if c.Signature == nil {
+			sig, err := httpGetBody(c.URL + ".sig")
+			if err != nil {
+				return nil, err
+			}
+			c.Signature = sig
+		}
+		return c.Signature, nil
+	})
+	if err != nil {
+		return nil, status, err
+	}
+	var data map[string]any
+	if err := json.Unmarshal(payload, &data); err != nil {
+		return nil, status, err
+	}
+	return data, status, nil
+}
+
+// httpGetBody fetches url and returns the body of a successful response.
+func httpGetBody(url string) ([]byte, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &statusError{Code: resp.StatusCode, Status: resp.Status}
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// statusError reports an unsuccessful HTTP response.
+type statusError struct {
+	Code   int
+	Status string
+}
+
+func (e *statusError) Error() string {
+	return "unexpected status: " + e.Status
+}
+
+// readRemoteConfigCache returns the cached remote configuration for url, or
+// nil if there is none.
+func readRemoteConfigCache(url string) *remoteConfigCache {
+	data, err := os.ReadFile(statePath(remoteConfigCacheFile))
+	if err != nil {
+		return nil
+	}
+	var c remoteConfigCache
+	if json.Unmarshal(data, &c) != nil || c.URL != url {
+		return nil
+	}
+	return &c
+}
+
+// writeRemoteConfigCache stores c. The file holds secrets and is only readable
+// by the user.
+func writeRemoteConfigCache(c *remoteConfigCache) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(statePath(remoteConfigCacheFile), data, 0600)
+}
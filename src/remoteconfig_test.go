@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestLoadRemoteConfigConditional caches the document and revalidates it with
+// its ETag.
+func TestLoadRemoteConfigConditional(t *testing.T) {
+	chdir(t, t.TempDir())
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Header.Get("If-None-Match")+"|"+r.Header.Get("If-Modified-Since"))
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Last-Modified", "Wed, 01 May 2024 10:00:00 GMT")
+		io.WriteString(w, `{"restic-repo":"/real"}`)
+	}))
+	defer srv.Close()
+	for i := 0; i < 2; i++ {
+		data, info, err := loadRemoteConfig(srv.URL)
+		if err != nil || data["restic-repo"] != "/real" || info.Warning != nil {
+			t.Fatalf("load %d: %v %+v %v", i, data, info, err)
+		}
+	}
+	if len(requests) != 2 || requests[0] != "|" || requests[1] != `"v1"|Wed, 01 May 2024 10:00:00 GMT` {
+		t.Fatalf("unexpected requests: %q", requests)
+	}
+}
+
+// TestLoadRemoteConfigOffline falls back to the cached copy.
+func TestLoadRemoteConfigOffline(t *testing.T) {
+	chdir(t, t.TempDir())
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"restic-repo":"/real"}`)
+	}))
+	url := srv.URL
+	if _, _, err := loadRemoteConfig(url); err != nil {
+		t.Fatalf("initial load: %v", err)
+	}
+	srv.Close()
+	data, info, err := loadRemoteConfig(url)
+	if err != nil || data["restic-repo"] != "/real" {
+		t.Fatalf("offline load: %v %v", data, err)
+	}
+	if info.Warning == nil || info.FetchedAt.IsZero() {
+		t.Fatalf("cache use not reported: %+v", info)
+	}
+	// a cache for another URL is not used
+	if _, _, err := loadRemoteConfig(url + "/other"); err == nil {
+		t.Fatalf("cache used for a different URL")
+	}
+}
+
+// TestLoadRemoteConfigRejectedKeepsCache keeps the last good copy when a new
+// document fails verification.
+func TestLoadRemoteConfigRejectedKeepsCache(t *testing.T) {
+	chdir(t, t.TempDir())
+	priv := withPublicKey(t)
+	good := `{"restic-repo":"/real"}`
+	body := `{"config":` + good + `,"signature":"` + sign(priv, good) + `"}`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, body)
+	}))
+	defer srv.Close()
+	if _, _, err := loadRemoteConfig(srv.URL); err != nil {
+		t.Fatalf("initial load: %v", err)
+	}
+	body = `{"config":{"restic-repo":"/evil"},"signature":"` + sign(priv, good) + `"}`
+	data, info, err := loadRemoteConfig(srv.URL)
+	if err != nil || data["restic-repo"] != "/real" || !errors.Is(info.Warning, errConfigBadSignature) {
+		t.Fatalf("unexpected result: %v %+v %v", data, info, err)
+	}
+}
+
+// TestGetConfigOfflineUsesCache keeps the real repository while offline.
+func TestGetConfigOfflineUsesCache(t *testing.T) {
+	chdir(t, t.TempDir())
+	unsetEnv(t, "RESTIC-REPO")
+	unsetEnv(t, "RESTIC-REPO-PASSWORD")
+	online := true
+	restore := withHTTPClient(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		if !online {
+			return nil, errors.New("network unreachable")
+		}
+		if req.URL.String() != "https://config.example/family.json" {
+			t.Fatalf("unexpected URL %s", req.URL)
+		}
+		body := `{"restic-repo":"/real","restic-repo-password":"pw","paths":["/docs"]}`
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body)), Header: make(http.Header), Request: req}, nil
+	}))
+	defer restore()
+	if err := os.WriteFile(configFile, []byte(`{"remote-config-url":"https://config.example/family.json"}`), 0644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	if cfg := getConfig(); cfg.Repo != "/real" {
+		t.Fatalf("unexpected repo: %s", cfg.Repo)
+	}
+	online = false
+	cfg := getConfig()
+	if cfg.Repo != "/real" || cfg.Password != "pw" || len(cfg.Paths) != 1 || cfg.Paths[0] != "/docs" {
+		t.Fatalf("offline config lost remote settings: %+v", cfg)
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"backup/internal/restic"
+)
+
+// errRestoreCanceled is returned when the user leaves the guided restore.
+var errRestoreCanceled = errors.New("restore canceled")
+
+// restoreSelection is a set of paths to restore from one snapshot. Empty paths
+// restore the whole snapshot.
+type restoreSelection struct {
+	Snapshot string
+	Paths    []string
+}
+
+// prompter asks questions on a line based terminal.
+type prompter struct {
+	in  *bufio.Scanner
+	out io.Writer
+}
+
+// ask prints question and returns the trimmed answer. EOF cancels.
+func (p *prompter) ask(question string) (string, error) {
+	fmt.Fprint(p.out, question)
+	if !p.in.Scan() {
+		fmt.Fprintln(p.out)
+		return "", errRestoreCanceled
+	}
+	return strings.TrimSpace(p.in.Text()), nil
+}
+
+// chooseNumbers parses a comma or space separated list of numbers between 1
+// and max.
+func chooseNumbers(answer string, max int) ([]int, error) {
+	var nums []int
+	for _, f := range strings.FieldsFunc(answer, func(r rune) bool { return r == ',' || r == ' ' }) {
+		n, err := strconv.Atoi(f)
+		if err != nil || n < 1 || n > max {
+			return nil, fmt.Errorf("invalid choice %q", f)
+		}
+		nums = append(nums, n)
+	}
+	if len(nums) == 0 {
+		return nil, errors.New("nothing selected")
+	}
+	return nums, nil
+}
+
+// defaultRestoreTarget returns a new directory below ~/Restored named after the
+// current date, adding a counter if it already exists.
+func defaultRestoreTarget(now time.Time) string {
+	base := filepath.Join(expandUser("~/Restored"), now.Format("2006-01-02"))
+	target := base
+	for i := 2; ; i++ {
+		if _, err := os.Stat(target); os.IsNotExist(err) {
+			return target
+		}
+		target = fmt.Sprintf("%s-%d", base, i)
+	}
+}
+
+// globPattern turns a plain file name into a substring match.
+func globPattern(s string) string {
+	if strings.ContainsAny(s, "*?[") {
+		return s
+	}
+	return "*" + s + "*"
+}
+
+// guidedRestore lets the user pick a snapshot or search for files and returns
+// what to restore.
+func guidedRestore(client *restic.Client, in io.Reader, out io.Writer) ([]restoreSelection, error) {
+	ctx := context.Background()
+	p := &prompter{in: bufio.NewScanner(in), out: out}
+	snaps, err := client.Snapshots(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(snaps) == 0 {
+		return nil, errors.New("the repository contains no snapshots")
+	}
+	sort.Slice(snaps, func(i, j int) bool { return snaps[i].Time.After(snaps[j].Time) })
+	fmt.Fprintln(out, "snapshots (newest first):")
+	for i, s := range snaps {
+		fmt.Fprintf(out, " [%d] %s  %s  %s  %s\n", i+1, s.Time.Local().Format("2006-01-02 15:04"), shortID(s), s.Hostname, strings.Join(s.Paths, ", "))
+	}
+	for {
+		answer, err := p.ask("enter a snapshot number, or a file name to search for (q to quit): ")
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case answer == "":
+			continue
+		case answer == "q":
+			return nil, errRestoreCanceled
+		}
+		if n, err := strconv.Atoi(answer); err == nil {
+			if n < 1 || n > len(snaps) {
+				fmt.Fprintln(out, "no such snapshot")
+				continue
+			}
+			return browseSnapshot(client, p, snaps[n-1])
+		}
+		sel, err := searchFiles(client, p, answer, snaps)
+		if err != nil {
+			return nil, err
+		}
+		if sel != nil {
+			return sel, nil
+		}
+	}
+}
+
+// searchFiles looks for name in all snapshots and lets the user pick matches.
+// It returns nil when nothing was found so the caller can ask again.
+func searchFiles(client *restic.Client, p *prompter, name string, snaps []restic.Snapshot) ([]restoreSelection, error) {
+	results, err := client.Find(context.Background(), globPattern(name))
+	if err != nil {
+		return nil, err
+	}
+	times := map[string]time.Time{}
+	for _, s := range snaps {
+		times[s.ID] = s.Time
+	}
+	type hit struct {
+		snapshot string
+		node     restic.Node
+	}
+	var hits []hit
+	for _, r := range results {
+		for _, m := range r.Matches {
+			hits = append(hits, hit{r.Snapshot, m})
+		}
+	}
+	if len(hits) == 0 {
+		fmt.Fprintf(p.out, "no files matching %q found\n", name)
+		return nil, nil
+	}
+	sort.SliceStable(hits, func(i, j int) bool { return times[hits[i].snapshot].After(times[hits[j].snapshot]) })
+	for i, h := range hits {
+		fmt.Fprintf(p.out, " [%d] %s  %s  %s  %s\n", i+1, times[h.snapshot].Local().Format("2006-01-02 15:04"), shortID(restic.Snapshot{ID: h.snapshot}), formatBytes(h.node.Size), h.node.Path)
+	}
+	for {
+		answer, err := p.ask("files to restore, e.g. 1 or 1,3 (enter to search again): ")
+		if err != nil || answer == "" {
+			return nil, err
+		}
+		nums, err := chooseNumbers(answer, len(hits))
+		if err != nil {
+			fmt.Fprintln(p.out, err)
+			continue
+		}
+		var sels []restoreSelection
+		idx := map[string]int{}
+		for _, n := range nums {
+			h := hits[n-1]
+			i, ok := idx[h.snapshot]
+			if !ok {
+				i = len(sels)
+				idx[h.snapshot] = i
+				sels = append(sels, restoreSelection{Snapshot: h.snapshot})
+			}
+			sels[i].Paths = append(sels[i].Paths, h.node.Path)
+		}
+		return sels, nil
+	}
+}
+
+// browseSnapshot walks the directories of snap until the user picks a file or
+// directory to restore.
+func browseSnapshot(client *restic.Client, p *prompter, snap restic.Snapshot) ([]restoreSelection, error) {
+	dir := "/"
+	for {
+		nodes, err := client.Ls(context.Background(), snap.ID, dir)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(p.out, "%s:%s\n", shortID(snap), dir)
+		for i, n := range nodes {
+			name := n.Name
+			if n.Type == "dir" {
+				name += "/"
+			}
+			fmt.Fprintf(p.out, " [%d] %-40s %s\n", i+1, name, formatBytes(n.Size))
+		}
+		answer, err := p.ask("number to open or select, 'a' to restore this folder, '..' to go up, q to quit: ")
+		if err != nil {
+			return nil, err
+		}
+		switch answer {
+		case "q":
+			return nil, errRestoreCanceled
+		case "a":
+			sel := restoreSelection{Snapshot: snap.ID}
+			if dir != "/" {
+				sel.Paths = []string{dir}
+			}
+			return []restoreSelection{sel}, nil
+		case "..":
+			dir = path.Dir(dir)
+			continue
+		}
+		nums, err := chooseNumbers(answer, len(nodes))
+		if err != nil || len(nums) != 1 {
+			fmt.Fprintln(p.out, "please enter one number")
+			continue
+		}
+		n := nodes[nums[0]-1]
+		if n.Type == "dir" {
+			dir = n.Path
+			continue
+		}
+		return []restoreSelection{{Snapshot: snap.ID, Paths: []string{n.Path}}}, nil
+	}
+}
+
+// restoreSelections restores sels below target. When files from several
+// snapshots are restored, each snapshot gets its own subdirectory.
+func restoreSelections(client *restic.Client, sels []restoreSelection, target string, out io.Writer) error {
+	for _, sel := range sels {
+		dst := target
+		if len(sels) > 1 {
+			dst = filepath.Join(target, shortID(restic.Snapshot{ID: sel.Snapshot}))
+		}
+		fmt.Fprintf(out, "restoring %s from snapshot %s to %s\n", describePaths(sel.Paths), shortID(restic.Snapshot{ID: sel.Snapshot}), dst)
+		if err := client.Restore(context.Background(), sel.Snapshot, restic.RestoreOptions{Target: dst, Includes: sel.Paths}); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintln(out, "restored files are in", target)
+	return nil
+}
+
+// describePaths summarizes the restored paths for the progress output.
+func describePaths(paths []string) string {
+	switch len(paths) {
+	case 0:
+		return "everything"
+	case 1:
+		return paths[0]
+	default:
+		return fmt.Sprintf("%d paths", len(paths))
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"backup/internal/restic"
+)
+
+// fakeRestoreRestic returns a client for a restic stand-in that answers
+// snapshots, find, ls and restore.
+func fakeRestoreRestic(t *testing.T, dir string) *restic.Client {
+	t.Helper()
+	path := fakeRestic(t, dir, `case "$3" in
+snapshots) echo '[{"id":"old111111","short_id":"old11111","time":"2024-01-01T10:00:00Z","paths":["/home/u"]},{"id":"new222222","short_id":"new22222","time":"2024-05-01T10:00:00Z","paths":["/home/u"]}]' ;;
+find) echo '[{"hits":1,"snapshot":"old111111","matches":[{"path":"/home/u/report.docx","type":"file","size":42}]},{"hits":1,"snapshot":"new222222","matches":[{"path":"/home/u/report.docx","type":"file","size":43}]}]' ;;
+ls)
+  if [ "$6" = "/" ]; then echo '{"name":"home","type":"dir","path":"/home","struct_type":"node"}'; fi
+  if [ "$6" = "/home" ]; then echo '{"name":"notes.txt","type":"file","path":"/home/notes.txt","size":5,"struct_type":"node"}'; fi
+  ;;
+esac`)
+	return restic.New(path, "/repo", "pw")
+}
+
+func TestGuidedRestoreSearch(t *testing.T) {
+	dir := t.TempDir()
+	client := fakeRestoreRestic(t, dir)
+	var out bytes.Buffer
+	sels, err := guidedRestore(client, strings.NewReader("report\n1\n"), &out)
+	if err != nil {
+		t.Fatalf("guidedRestore: %v", err)
+	}
+	// newest snapshot is listed first
+	if len(sels) != 1 || sels[0].Snapshot != "new222222" || sels[0].Paths[0] != "/home/u/report.docx" {
+		t.Fatalf("unexpected selection: %+v", sels)
+	}
+	if !strings.Contains(out.String(), "[1] 2024-05-01") {
+		t.Fatalf("snapshots not sorted newest first: %q", out.String())
+	}
+	if calls := readCalls(t, dir); !slices.Contains(calls, "-r /repo find --ignore-case *report* --json") {
+		t.Fatalf("unexpected calls: %q", calls)
+	}
+}
+
+func TestGuidedRestoreBrowse(t *testing.T) {
+	dir := t.TempDir()
+	client := fakeRestoreRestic(t, dir)
+	var out bytes.Buffer
+	sels, err := guidedRestore(client, strings.NewReader("2\n1\n1\n"), &out)
+	if err != nil {
+		t.Fatalf("guidedRestore: %v", err)
+	}
+	if len(sels) != 1 || sels[0].Snapshot != "old111111" || sels[0].Paths[0] != "/home/notes.txt" {
+		t.Fatalf("unexpected selection: %+v", sels)
+	}
+}
+
+func TestGuidedRestoreQuit(t *testing.T) {
+	client := fakeRestoreRestic(t, t.TempDir())
+	if _, err := guidedRestore(client, strings.NewReader("q\n"), &bytes.Buffer{}); err != errRestoreCanceled {
+		t.Fatalf("expected cancel, got %v", err)
+	}
+	if _, err := guidedRestore(client, strings.NewReader(""), &bytes.Buffer{}); err != errRestoreCanceled {
+		t.Fatalf("expected cancel on EOF, got %v", err)
+	}
+}
+
+func TestRestoreSelections(t *testing.T) {
+	dir := t.TempDir()
+	client := fakeRestoreRestic(t, dir)
+	sels := []restoreSelection{
+		{Snapshot: "old111111", Paths: []string{"/a"}},
+		{Snapshot: "new222222", Paths: []string{"/b", "/c"}},
+	}
+	var out bytes.Buffer
+	if err := restoreSelections(client, sels, "/t", &out); err != nil {
+		t.Fatalf("restoreSelections: %v", err)
+	}
+	calls := readCalls(t, dir)
+	exp := []string{"-r /repo restore old111111 --target /t/old11111 --include /a", "-r /repo restore new222222 --target /t/new22222 --include /b --include /c"}
+	if !slices.Equal(calls, exp) {
+		t.Fatalf("unexpected calls: %q", calls)
+	}
+}
+
+func TestDefaultRestoreTarget(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.Local)
+	first := defaultRestoreTarget(now)
+	if first != filepath.Join(home, "Restored", "2024-05-01") {
+		t.Fatalf("unexpected target: %s", first)
+	}
+	if err := os.MkdirAll(first, 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if got := defaultRestoreTarget(now); got != first+"-2" {
+		t.Fatalf("existing target reused: %s", got)
+	}
+}
+
+func TestChooseNumbers(t *testing.T) {
+	nums, err := chooseNumbers("1, 3", 3)
+	if err != nil || len(nums) != 2 || nums[1] != 3 {
+		t.Fatalf("unexpected numbers: %v %v", nums, err)
+	}
+	for _, s := range []string{"", "0", "4", "x"} {
+		if _, err := chooseNumbers(s, 3); err == nil {
+			t.Errorf("chooseNumbers(%q) succeeded", s)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"backup/internal/restic"
+)
+
+// defaultPruneInterval is used when the retention block sets no interval.
+const defaultPruneInterval = 7 * 24 * time.Hour
+
+// retention configures which snapshots are kept after a backup.
+type retention struct {
+	KeepLast      int      `json:"keep-last,omitempty"`
+	KeepHourly    int      `json:"keep-hourly,omitempty"`
+	KeepDaily     int      `json:"keep-daily,omitempty"`
+	KeepWeekly    int      `json:"keep-weekly,omitempty"`
+	KeepMonthly   int      `json:"keep-monthly,omitempty"`
+	KeepYearly    int      `json:"keep-yearly,omitempty"`
+	KeepWithin    string   `json:"keep-within,omitempty"`
+	KeepTag       []string `json:"keep-tag,omitempty"`
+	PruneInterval string   `json:"prune-interval,omitempty"`
+}
+
+// policy converts the retention settings into a restic forget policy.
+func (r retention) policy() restic.Policy {
+	return restic.Policy{
+		KeepLast:    r.KeepLast,
+		KeepHourly:  r.KeepHourly,
+		KeepDaily:   r.KeepDaily,
+		KeepWeekly:  r.KeepWeekly,
+		KeepMonthly: r.KeepMonthly,
+		KeepYearly:  r.KeepYearly,
+		KeepWithin:  r.KeepWithin,
+		KeepTags:    r.KeepTag,
+	}
+}
+
+// enabled reports whether any keep rule is configured.
+func (r retention) enabled() bool {
+	return !r.policy().Empty()
+}
+
+// pruneDue reports whether enough time has passed since lastPrune to prune
+// again.
+func (r retention) pruneDue(lastPrune, now time.Time) (bool, error) {
+	interval := defaultPruneInterval
+	if r.PruneInterval != "" {
+		d, err := parseDuration(r.PruneInterval)
+		if err != nil {
+			return false, fmt.Errorf("retention prune-interval: %w", err)
+		}
+		interval = d
+	}
+	return now.Sub(lastPrune) >= interval, nil
+}
+
+// applyRetention forgets snapshots outside the retention policy and prunes the
+// repository when the prune interval has passed.
+func applyRetention(client *restic.Client, jc config, out io.Writer, now time.Time) error {
+	job, r := jc.Job, jc.Retention
+	if !r.enabled() {
+		return nil
+	}
+	prune, err := r.pruneDue(loadState().job(job).LastPrune, now)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	var groups []restic.ForgetGroup
+	err = unlockAndRetry(client, out, func() (err error) {
+		groups, err = client.Forget(ctx, restic.ForgetOptions{Policy: r.policy(), Tags: jc.snapshotTags()})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	printForgetGroups(out, groups, false)
+	if !prune {
+		return nil
+	}
+	fmt.Fprintln(out, "pruning repository")
+	if err := unlockAndRetry(client, out, func() error { return client.Prune(ctx) }); err != nil {
+		return err
+	}
+	return updateJobState(job, func(js *jobState) { js.LastPrune = now })
+}
+
+// printForgetGroups lists the snapshots removed by forget. With dryRun the
+// text describes what would happen.
+func printForgetGroups(w io.Writer, groups []restic.ForgetGroup, dryRun bool) {
+	verb, total := "removed", "removed"
+	if dryRun {
+		verb, total = "would remove", "would be removed"
+	}
+	removed := 0
+	for _, g := range groups {
+		for _, s := range g.Remove {
+			fmt.Fprintf(w, "%s %s  %s  %s  %s\n", verb, shortID(s), s.Time.Local().Format("2006-01-02 15:04:05"), s.Hostname, strings.Join(s.Paths, ", "))
+			removed++
+		}
+	}
+	kept := 0
+	for _, g := range groups {
+		kept += len(g.Keep)
+	}
+	fmt.Fprintf(w, "retention: %d snapshots kept, %d %s\n", kept, removed, total)
+}
+
+// shortID returns the short form of a snapshot ID.
+func shortID(s restic.Snapshot) string {
+	if s.ShortID != "" {
+		return s.ShortID
+	}
+	if len(s.ID) > 8 {
+		return s.ID[:8]
+	}
+	return s.ID
+}
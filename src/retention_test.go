@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"backup/internal/restic"
+)
+
+func TestRetentionPruneDue(t *testing.T) {
+	now := time.Date(2024, 5, 10, 0, 0, 0, 0, time.UTC)
+	r := retention{KeepLast: 1}
+	if due, _ := r.pruneDue(now.Add(-6*24*time.Hour), now); due {
+		t.Fatalf("prune due before default interval")
+	}
+	if due, _ := r.pruneDue(now.Add(-7*24*time.Hour), now); !due {
+		t.Fatalf("prune not due after default interval")
+	}
+	r.PruneInterval = "1d"
+	if due, _ := r.pruneDue(now.Add(-25*time.Hour), now); !due {
+		t.Fatalf("prune not due after configured interval")
+	}
+	r.PruneInterval = "often"
+	if _, err := r.pruneDue(now, now); err == nil {
+		t.Fatalf("expected error for invalid interval")
+	}
+}
+
+// TestApplyRetention forgets snapshots and prunes once per interval.
+func TestApplyRetention(t *testing.T) {
+	dir := t.TempDir()
+	chdir(t, dir)
+	resticPath := fakeRestic(t, dir, `case "$*" in
+*forget*) echo '[{"keep":[{"id":"aaaaaaaaaa"}],"remove":[{"id":"bbbbbbbbbb","paths":["/a"]}]}]' ;;
+esac`)
+	client := restic.New(resticPath, "/repo", "pw")
+	jc := config{Job: defaultJobName, Retention: retention{KeepDaily: 7, KeepTag: []string{"keep"}}}
+	now := time.Now()
+	var out bytes.Buffer
+	if err := applyRetention(client, jc, &out, now); err != nil {
+		t.Fatalf("applyRetention: %v", err)
+	}
+	if err := applyRetention(client, jc, &out, now.Add(time.Hour)); err != nil {
+		t.Fatalf("applyRetention: %v", err)
+	}
+	calls := readCalls(t, dir)
+	exp := []string{
+		"-r /repo forget --keep-daily 7 --keep-tag keep --tag default --json",
+		"-r /repo prune",
+		"-r /repo forget --keep-daily 7 --keep-tag keep --tag default --json",
+	}
+	if strings.Join(calls, "|") != strings.Join(exp, "|") {
+		t.Fatalf("unexpected calls: %q", calls)
+	}
+	if !strings.Contains(out.String(), "removed bbbbbbbb") || !strings.Contains(out.String(), "1 snapshots kept, 1 removed") {
+		t.Fatalf("unexpected output: %q", out.String())
+	}
+	if loadState().LastPrune.IsZero() {
+		t.Fatalf("last prune not recorded")
+	}
+}
+
+// TestApplyRetentionDisabled does nothing without keep rules.
+func TestApplyRetentionDisabled(t *testing.T) {
+	client := restic.New(filepath.Join(t.TempDir(), "missing"), "/repo", "pw")
+	if err := applyRetention(client, config{Job: defaultJobName, Retention: retention{PruneInterval: "1d"}}, &bytes.Buffer{}, time.Now()); err != nil {
+		t.Fatalf("applyRetention: %v", err)
+	}
+}
+
+func TestPrintForgetGroupsDryRun(t *testing.T) {
+	var b bytes.Buffer
+	printForgetGroups(&b, []restic.ForgetGroup{{Remove: []restic.Snapshot{{ShortID: "abc", Hostname: "h"}}}}, true)
+	if !strings.Contains(b.String(), "would remove abc") || !strings.Contains(b.String(), "0 snapshots kept, 1 would be removed") {
+		t.Fatalf("unexpected output: %q", b.String())
+	}
+}
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// openRunLog opens the log file used by unattended runs for appending.
+func openRunLog() (*os.File, error) {
+	return appendFile(statePath(logFile))
+}
+
+// logf writes a timestamped line with secrets masked to w.
+func logf(w io.Writer, format string, args ...any) {
+	fmt.Fprintf(w, "%s %s\n", time.Now().Format(time.RFC3339), redact(fmt.Sprintf(format, args...)))
+}
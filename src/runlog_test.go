@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+// TestIsTerminal ensures regular files and the null device are not treated as
+// terminals.
+func TestIsTerminal(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "in"))
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	defer f.Close()
+	if isTerminal(f) {
+		t.Fatalf("regular file reported as terminal")
+	}
+	// login sessions connect stdin to the null device, a character device
+	null, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatalf("open %s: %v", os.DevNull, err)
+	}
+	defer null.Close()
+	if isTerminal(null) {
+		t.Fatalf("%s reported as terminal", os.DevNull)
+	}
+}
+
+// TestLogf prefixes log lines with a timestamp.
+func TestLogf(t *testing.T) {
+	var b bytes.Buffer
+	logf(&b, "backup %s", "started")
+	if !regexp.MustCompile(`^\d{4}-\d{2}-\d{2}T\S+ backup started\n$`).MatchString(b.String()) {
+		t.Fatalf("unexpected log line: %q", b.String())
+	}
+}
+
+// TestOpenRunLogAppends keeps earlier log entries.
+func TestOpenRunLogAppends(t *testing.T) {
+	chdir(t, t.TempDir())
+	for _, line := range []string{"one\n", "two\n"} {
+		f, err := openRunLog()
+		if err != nil {
+			t.Fatalf("openRunLog: %v", err)
+		}
+		f.WriteString(line)
+		f.Close()
+	}
+	data, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatalf("read log: %v", err)
+	}
+	if string(data) != "one\ntwo\n" {
+		t.Fatalf("unexpected log: %q", data)
+	}
+}
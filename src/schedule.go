@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// schedule computes when a job runs next.
+type schedule interface {
+	// next returns the first run time after t.
+	next(t time.Time) time.Time
+}
+
+// intervalSchedule runs a job a fixed time after its last run.
+type intervalSchedule time.Duration
+
+func (s intervalSchedule) next(t time.Time) time.Time {
+	return t.Add(time.Duration(s))
+}
+
+// cronSchedule runs a job at the minutes matching a five field cron
+// expression, evaluated in local time.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64 // bit sets of allowed values
+	domAny, dowAny                bool
+}
+
+// cronAliases maps the @ shortcuts to cron expressions.
+var cronAliases = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+}
+
+// parseSchedule parses a job schedule. Intervals such as "1h" or "7d" run the
+// job that long after the last successful run; cron expressions such as
+// "30 2 * * *" or the shortcuts @hourly, @daily, @weekly and @monthly run it
+// at fixed times.
+func parseSchedule(s string) (schedule, error) {
+	s = strings.TrimSpace(s)
+	if alias, ok := cronAliases[s]; ok {
+		s = alias
+	}
+	if !strings.Contains(s, " ") {
+		d, err := parseDuration(s)
+		if err != nil {
+			return nil, fmt.Errorf("schedule %q: %w", s, err)
+		}
+		if d < time.Minute {
+			return nil, fmt.Errorf("schedule %q: interval shorter than a minute", s)
+		}
+		return intervalSchedule(d), nil
+	}
+	fields := strings.Fields(s)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("schedule %q: cron expressions need 5 fields", s)
+	}
+	var c cronSchedule
+	var err error
+	bounds := [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	sets := [5]*uint64{&c.minute, &c.hour, &c.dom, &c.month, &c.dow}
+	for i, f := range fields {
+		if *sets[i], err = parseCronField(f, bounds[i][0], bounds[i][1]); err != nil {
+			return nil, fmt.Errorf("schedule %q: %w", s, err)
+		}
+	}
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1 // 7 is another name for Sunday
+	}
+	c.domAny = fields[2] == "*"
+	c.dowAny = fields[4] == "*"
+	if c.next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("schedule %q never matches", s)
+	}
+	return c, nil
+}
+
+// parseCronField parses a comma separated list of values, ranges and steps
+// such as "*/15", "1-5" or "0,30" into a bit set.
+func parseCronField(f string, min, max int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(f, ",") {
+		rng, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+			rng, step = part[:i], n
+		}
+		lo, hi := min, max
+		if rng != "*" {
+			bounds := strings.SplitN(rng, "-", 2)
+			var err error
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("invalid value %q", part)
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("invalid value %q", part)
+				}
+			} else if step > 1 {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("value %q out of range %d-%d", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+	return set, nil
+}
+
+// maxCronSearch bounds the search for the next matching time, so an
+// expression that never matches, such as "0 0 31 2 *", cannot loop forever.
+const maxCronSearch = 5 * 366 * 24 * time.Hour
+
+func (c cronSchedule) next(t time.Time) time.Time {
+	t = t.Local().Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(maxCronSearch)
+	for t.Before(limit) {
+		switch {
+		case c.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// dayMatches applies the cron rule that a day matches either the day of the
+// month or the weekday when both are restricted.
+func (c cronSchedule) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	switch {
+	case c.domAny && c.dowAny:
+		return true
+	case c.domAny:
+		return dow
+	case c.dowAny:
+		return dom
+	}
+	return dom || dow
+}
+
+// failedRunRetry is the longest a job waits to be retried after a failed run.
+const failedRunRetry = time.Hour
+
+// nextRun returns when a job with schedule s is due, given its state. A job
+// that never ran is due immediately. Because the time is computed from the
+// last successful run, runs missed while the machine was off or asleep are
+// caught up as soon as it runs again. After a failure the job is retried at
+// its next scheduled time, but no later than failedRunRetry.
+func nextRun(s schedule, js jobState) time.Time {
+	if js.LastSuccess.IsZero() && js.LastRun.IsZero() {
+		return time.Time{}
+	}
+	var next time.Time
+	if !js.LastSuccess.IsZero() {
+		next = s.next(js.LastSuccess)
+	}
+	if js.LastRun.After(js.LastSuccess) {
+		retry := s.next(js.LastRun)
+		if r := js.LastRun.Add(failedRunRetry); retry.IsZero() || r.Before(retry) {
+			retry = r
+		}
+		if retry.After(next) {
+			next = retry
+		}
+	}
+	return next
+}
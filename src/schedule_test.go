@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// local returns a time in the local time zone.
+func local(year int, month time.Month, day, hour, min int) time.Time {
+	return time.Date(year, month, day, hour, min, 0, 0, time.Local)
+}
+
+func TestParseScheduleInterval(t *testing.T) {
+	s, err := parseSchedule("7d")
+	if err != nil {
+		t.Fatalf("parseSchedule: %v", err)
+	}
+	start := local(2024, 5, 1, 10, 0)
+	if got := s.next(start); !got.Equal(start.Add(7 * 24 * time.Hour)) {
+		t.Fatalf("unexpected next: %v", got)
+	}
+	for _, bad := range []string{"", "soon", "30s", "61 * * * *", "* * *", "0 0 31 2 *", "*/0 * * * *"} {
+		if _, err := parseSchedule(bad); err == nil {
+			t.Errorf("parseSchedule(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	tests := []struct {
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{"30 2 * * *", local(2024, 5, 1, 1, 0), local(2024, 5, 1, 2, 30)},
+		{"30 2 * * *", local(2024, 5, 1, 2, 30), local(2024, 5, 2, 2, 30)},
+		{"*/15 * * * *", local(2024, 5, 1, 10, 7), local(2024, 5, 1, 10, 15)},
+		{"@hourly", local(2024, 5, 1, 10, 0), local(2024, 5, 1, 11, 0)},
+		// 2024-05-01 is a Wednesday; 7 means Sunday.
+		{"0 9 * * 7", local(2024, 5, 1, 10, 0), local(2024, 5, 5, 9, 0)},
+		{"0 9 * * 1-5", local(2024, 5, 3, 10, 0), local(2024, 5, 6, 9, 0)},
+		{"0 0 1 */3 *", local(2024, 5, 1, 10, 0), local(2024, 7, 1, 0, 0)},
+		// day of month or weekday when both are restricted
+		{"0 0 15 * 0", local(2024, 5, 1, 10, 0), local(2024, 5, 5, 0, 0)},
+		{"0 12 29 2 *", local(2024, 3, 1, 0, 0), local(2028, 2, 29, 12, 0)},
+	}
+	for _, tt := range tests {
+		s, err := parseSchedule(tt.expr)
+		if err != nil {
+			t.Fatalf("parseSchedule(%q): %v", tt.expr, err)
+		}
+		if got := s.next(tt.from); !got.Equal(tt.want) {
+			t.Errorf("%q after %v: got %v, want %v", tt.expr, tt.from, got, tt.want)
+		}
+	}
+}
+
+func TestNextRun(t *testing.T) {
+	s := intervalSchedule(24 * time.Hour)
+	if next := nextRun(s, jobState{}); !next.IsZero() {
+		t.Fatalf("job that never ran not due: %v", next)
+	}
+	ok := local(2024, 5, 1, 10, 0)
+	if next := nextRun(s, jobState{LastRun: ok, LastSuccess: ok}); !next.Equal(ok.Add(24 * time.Hour)) {
+		t.Fatalf("unexpected next run: %v", next)
+	}
+	// a failure is retried after failedRunRetry instead of a day later
+	failed := ok.Add(24 * time.Hour)
+	if next := nextRun(s, jobState{LastRun: failed, LastSuccess: ok}); !next.Equal(failed.Add(failedRunRetry)) {
+		t.Fatalf("unexpected retry: %v", next)
+	}
+	// a run missed while the machine slept is due right away
+	cron, _ := parseSchedule("0 2 * * *")
+	last := local(2024, 5, 1, 2, 0)
+	if next := nextRun(cron, jobState{LastRun: last, LastSuccess: last}); !next.Before(local(2024, 5, 3, 8, 0)) {
+		t.Fatalf("missed run not caught up: %v", next)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// semver is a major.minor.patch version.
+type semver [3]int
+
+// parseSemver parses versions like "0.16.4", "v0.16" or "0.17". Missing parts
+// are zero; parts reports how many were given.
+func parseSemver(s string) (v semver, parts int, err error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "v")
+	fields := strings.Split(s, ".")
+	if s == "" || len(fields) > 3 {
+		return v, 0, fmt.Errorf("invalid version %q", s)
+	}
+	for i, f := range fields {
+		n, err := strconv.Atoi(f)
+		if err != nil || n < 0 {
+			return v, 0, fmt.Errorf("invalid version %q", s)
+		}
+		v[i] = n
+	}
+	return v, len(fields), nil
+}
+
+// compare returns -1, 0 or 1 depending on whether v is older than, equal to or
+// newer than w.
+func (v semver) compare(w semver) int {
+	for i := range v {
+		switch {
+		case v[i] < w[i]:
+			return -1
+		case v[i] > w[i]:
+			return 1
+		}
+	}
+	return 0
+}
+
+// String formats v as major.minor.patch.
+func (v semver) String() string {
+	return fmt.Sprintf("%d.%d.%d", v[0], v[1], v[2])
+}
+
+// versionTerm is a single comparison of a version constraint.
+type versionTerm struct {
+	op    string
+	v     semver
+	parts int
+}
+
+// versionConstraint is a set of terms that must all hold, e.g.
+// ">=0.16 <0.18". An empty constraint allows every version.
+type versionConstraint []versionTerm
+
+// parseVersionConstraint parses an exact version such as "0.17.3" or
+// space separated comparisons using >=, >, <=, < and =. An exact version with
+// fewer than three parts matches every release with that prefix.
+func parseVersionConstraint(s string) (versionConstraint, error) {
+	var c versionConstraint
+	for _, f := range strings.Fields(s) {
+		op := ""
+		for _, o := range []string{">=", "<=", ">", "<", "="} {
+			if strings.HasPrefix(f, o) {
+				op = o
+				break
+			}
+		}
+		v, parts, err := parseSemver(strings.TrimPrefix(f, op))
+		if err != nil {
+			return nil, fmt.Errorf("restic-version: %w", err)
+		}
+		if op == "" {
+			op = "="
+		}
+		c = append(c, versionTerm{op, v, parts})
+	}
+	return c, nil
+}
+
+// allows reports whether v satisfies every term.
+func (c versionConstraint) allows(v semver) bool {
+	for _, t := range c {
+		cmp := v.compare(t.v)
+		ok := false
+		switch t.op {
+		case ">=":
+			ok = cmp >= 0
+		case ">":
+			ok = cmp > 0
+		case "<=":
+			ok = cmp <= 0
+		case "<":
+			ok = cmp < 0
+		case "=":
+			ok = true
+			for i := 0; i < t.parts; i++ {
+				ok = ok && v[i] == t.v[i]
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
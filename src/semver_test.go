@@ -0,0 +1,51 @@
+package main
+
+import "testing"
+
+func TestParseSemver(t *testing.T) {
+	v, parts, err := parseSemver("v0.16.4")
+	if err != nil || v != (semver{0, 16, 4}) || parts != 3 {
+		t.Fatalf("unexpected result: %v %d %v", v, parts, err)
+	}
+	if v, parts, _ := parseSemver("0.17"); v != (semver{0, 17, 0}) || parts != 2 {
+		t.Fatalf("unexpected partial version: %v %d", v, parts)
+	}
+	for _, s := range []string{"", "x", "1.2.3.4", "1.-2"} {
+		if _, _, err := parseSemver(s); err == nil {
+			t.Errorf("parseSemver(%q) succeeded", s)
+		}
+	}
+}
+
+func TestVersionConstraint(t *testing.T) {
+	cases := []struct {
+		constraint string
+		version    string
+		want       bool
+	}{
+		{"", "0.9.0", true},
+		{"0.17.3", "0.17.3", true},
+		{"0.17.3", "0.17.4", false},
+		{"0.16", "0.16.5", true},
+		{"0.16", "0.17.0", false},
+		{">=0.16 <0.18", "0.16.0", true},
+		{">=0.16 <0.18", "0.17.9", true},
+		{">=0.16 <0.18", "0.18.0", false},
+		{">=0.16 <0.18", "0.15.2", false},
+		{">0.16.4 <=0.17.0", "0.17.0", true},
+		{">0.16.4 <=0.17.0", "0.16.4", false},
+	}
+	for _, tc := range cases {
+		c, err := parseVersionConstraint(tc.constraint)
+		if err != nil {
+			t.Fatalf("parse %q: %v", tc.constraint, err)
+		}
+		v, _, _ := parseSemver(tc.version)
+		if got := c.allows(v); got != tc.want {
+			t.Errorf("%q allows %s = %v, want %v", tc.constraint, tc.version, got, tc.want)
+		}
+	}
+	if _, err := parseVersionConstraint(">=abc"); err == nil {
+		t.Fatalf("expected error for invalid constraint")
+	}
+}
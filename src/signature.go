@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// signatureStatus describes the outcome of verifying the remote configuration.
+type signatureStatus string
+
+const (
+	sigNotChecked signatureStatus = "not checked (no public key compiled in)"
+	sigValid      signatureStatus = "valid"
+	sigMissing    signatureStatus = "missing"
+	sigInvalid    signatureStatus = "invalid"
+)
+
+var (
+	errConfigUnsigned     = errors.New("remote config is not signed")
+	errConfigBadSignature = errors.New("remote config signature is invalid")
+)
+
+// signedConfig is a remote configuration with an embedded signature over the
+// exact bytes of Config.
+type signedConfig struct {
+	Config    json.RawMessage `json:"config"`
+	Signature string          `json:"signature"`
+}
+
+// configPublicKey decodes ConfigPublicKey. It returns nil when no key was
+// compiled in.
+func configPublicKey() (ed25519.PublicKey, error) {
+	if ConfigPublicKey == "" {
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(ConfigPublicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("%w: compiled-in public key is malformed", errConfigBadSignature)
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// verifyRemoteConfig returns the configuration document contained in body.
+// The signature is taken from body if it has the form
+// {"config": {...}, "signature": "..."}; otherwise fetchSig is asked for a
+// detached signature over the whole body. With a compiled-in public key,
+// unsigned and tampered documents are rejected.
+func verifyRemoteConfig(body []byte, fetchSig func() ([]byte, error)) ([]byte, signatureStatus, error) {
+	key, err := configPublicKey()
+	if err != nil {
+		return nil, sigInvalid, err
+	}
+	payload := body
+	var sig []byte
+	var sc signedConfig
+	if json.Unmarshal(body, &sc) == nil && len(sc.Config) > 0 && sc.Signature != "" {
+		payload = sc.Config
+		sig, err = base64.StdEncoding.DecodeString(sc.Signature)
+		if err != nil {
+			sig = []byte{}
+		}
+	}
+	if key == nil {
+		return payload, sigNotChecked, nil
+	}
+	if sig == nil && fetchSig != nil {
+		if raw, err := fetchSig(); err == nil {
+			sig, err = base64.StdEncoding.DecodeString(string(bytes.TrimSpace(raw)))
+			if err != nil {
+				sig = []byte{}
+			}
+		}
+	}
+	if sig == nil {
+		return nil, sigMissing, errConfigUnsigned
+	}
+	if !ed25519.Verify(key, payload, sig) {
+		return nil, sigInvalid, errConfigBadSignature
+	}
+	return payload, sigValid, nil
+}
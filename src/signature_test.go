@@ -0,0 +1,134 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// withPublicKey compiles in a fresh public key for the test and returns the
+// matching private key.
+func withPublicKey(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	old := ConfigPublicKey
+	ConfigPublicKey = base64.StdEncoding.EncodeToString(pub)
+	t.Cleanup(func() { ConfigPublicKey = old })
+	return priv
+}
+
+// sign returns the base64 signature of doc.
+func sign(priv ed25519.PrivateKey, doc string) string {
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(priv, []byte(doc)))
+}
+
+func TestVerifyRemoteConfigWithoutKey(t *testing.T) {
+	old := ConfigPublicKey
+	ConfigPublicKey = ""
+	defer func() { ConfigPublicKey = old }()
+	doc, status, err := verifyRemoteConfig([]byte(`{"a":"b"}`), nil)
+	if err != nil || status != sigNotChecked || string(doc) != `{"a":"b"}` {
+		t.Fatalf("unexpected result: %s %s %v", doc, status, err)
+	}
+}
+
+func TestVerifyRemoteConfigEmbedded(t *testing.T) {
+	priv := withPublicKey(t)
+	cfg := `{"restic-repo": "/r"}`
+	body := `{"config": ` + cfg + `, "signature": "` + sign(priv, cfg) + `"}`
+	doc, status, err := verifyRemoteConfig([]byte(body), nil)
+	if err != nil || status != sigValid || string(doc) != cfg {
+		t.Fatalf("unexpected result: %s %s %v", doc, status, err)
+	}
+	tampered := strings.Replace(body, "/r", "/evil", 1)
+	if _, status, err := verifyRemoteConfig([]byte(tampered), nil); !errors.Is(err, errConfigBadSignature) || status != sigInvalid {
+		t.Fatalf("tampered config accepted: %s %v", status, err)
+	}
+}
+
+func TestVerifyRemoteConfigDetached(t *testing.T) {
+	priv := withPublicKey(t)
+	cfg := `{"restic-repo":"/r"}`
+	fetch := func() ([]byte, error) { return []byte(sign(priv, cfg) + "\n"), nil }
+	if _, status, err := verifyRemoteConfig([]byte(cfg), fetch); err != nil || status != sigValid {
+		t.Fatalf("detached signature rejected: %s %v", status, err)
+	}
+	missing := func() ([]byte, error) { return nil, errors.New("404") }
+	if _, status, err := verifyRemoteConfig([]byte(cfg), missing); !errors.Is(err, errConfigUnsigned) || status != sigMissing {
+		t.Fatalf("unsigned config accepted: %s %v", status, err)
+	}
+}
+
+func TestVerifyRemoteConfigMalformedKey(t *testing.T) {
+	old := ConfigPublicKey
+	ConfigPublicKey = "not a key"
+	defer func() { ConfigPublicKey = old }()
+	if _, _, err := verifyRemoteConfig([]byte(`{}`), nil); !errors.Is(err, errConfigBadSignature) {
+		t.Fatalf("expected error for malformed key, got %v", err)
+	}
+}
+
+// TestFetchPastebinConfigSiblingSignature fetches the signature from url.sig.
+func TestFetchPastebinConfigSiblingSignature(t *testing.T) {
+	priv := withPublicKey(t)
+	cfg := `{"a":"b"}`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/cfg":
+			io.WriteString(w, cfg)
+		case "/cfg.sig":
+			io.WriteString(w, sign(priv, cfg))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	m, err := fetchPastebinConfig(srv.URL + "/cfg")
+	if err != nil || m["a"] != "b" {
+		t.Fatalf("unexpected result: %v %v", m, err)
+	}
+}
+
+// TestGetConfigRejectsUnsigned ignores an unsigned Pastebin document.
+func TestGetConfigRejectsUnsigned(t *testing.T) {
+	chdir(t, t.TempDir())
+	withPublicKey(t)
+	unsetEnv(t, "RESTIC-REPO")
+	unsetEnv(t, "RESTIC-REPO-PASSWORD")
+	restore := withHTTPClient(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		if strings.HasSuffix(req.URL.Path, ".sig") {
+			return &http.Response{StatusCode: http.StatusNotFound, Body: io.NopCloser(strings.NewReader("")), Header: make(http.Header), Request: req}, nil
+		}
+		body := `{"restic-repo":"evil-repo"}`
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body)), Header: make(http.Header), Request: req}, nil
+	}))
+	defer restore()
+	if cfg := getConfig(); cfg.Repo == "evil-repo" {
+		t.Fatalf("unsigned config applied")
+	}
+}
+
+// TestHealthReportSignature reports rejected configurations.
+func TestHealthReportSignature(t *testing.T) {
+	dir := t.TempDir()
+	restic := fakeRestic(t, dir, "echo restic 0.9.6")
+	priv := withPublicKey(t)
+	cfg := `{"a":"b"}`
+	body := `{"config":` + cfg + `,"signature":"` + sign(priv, `{"a":"c"}`) + `"}`
+	restore := withHTTPClient(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body)), Header: make(http.Header), Request: req}, nil
+	}))
+	defer restore()
+	rep, _ := healthReport(restic, config{}, nil)
+	if !strings.Contains(rep, "config signature: invalid (config rejected)") {
+		t.Fatalf("report missing signature state: %s", rep)
+	}
+}
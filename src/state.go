@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const stateFile = "state.json"
+
+// stateLockFile is locked while the state is updated. Job lock names end in
+// .lock, so it cannot clash with them.
+var stateLockFile = filepath.Join(lockDir, "state.flock")
+
+// state holds bookkeeping that must survive between runs.
+type state struct {
+	// jobState holds the state of the default job at the top level, where
+	// it was kept before jobs existed.
+	jobState
+	// LastResticCheck is when the restic releases were last looked up.
+	LastResticCheck time.Time `json:"last-restic-check,omitempty"`
+	// ResticPin is the restic version install -rollback-restic switched
+	// back to. It is not upgraded until the next install.
+	ResticPin string `json:"restic-pin,omitempty"`
+	// Jobs holds the state of named jobs.
+	Jobs map[string]jobState `json:"jobs,omitempty"`
+	// Notifications holds the last delivery outcome of every notification
+	// channel.
+	Notifications map[string]channelState `json:"notifications,omitempty"`
+}
+
+// jobState holds the bookkeeping of a single job.
+type jobState struct {
+	// LastRun is when the last backup started and LastSuccess when the last
+	// successful one started. The daemon schedules runs from them.
+	LastRun     time.Time `json:"last-run,omitempty"`
+	LastSuccess time.Time `json:"last-success,omitempty"`
+	LastPrune   time.Time `json:"last-prune,omitempty"`
+	LastCheck   time.Time `json:"last-check,omitempty"`
+	CheckPart   int       `json:"check-part,omitempty"`
+	// AgeAlertLevel is the max-age level last notified and LastAgeAlert
+	// when it was notified.
+	AgeAlertLevel int       `json:"age-alert-level,omitempty"`
+	LastAgeAlert  time.Time `json:"last-age-alert,omitempty"`
+}
+
+// job returns the state of the named job.
+func (s state) job(name string) jobState {
+	if name == "" || name == defaultJobName {
+		return s.jobState
+	}
+	return s.Jobs[name]
+}
+
+// setJob replaces the state of the named job.
+func (s *state) setJob(name string, js jobState) {
+	if name == "" || name == defaultJobName {
+		s.jobState = js
+		return
+	}
+	if s.Jobs == nil {
+		s.Jobs = map[string]jobState{}
+	}
+	s.Jobs[name] = js
+}
+
+// updateJobState applies fn to the stored state of the named job.
+func updateJobState(name string, fn func(*jobState)) error {
+	return updateState(func(st *state) {
+		js := st.job(name)
+		fn(&js)
+		st.setJob(name, js)
+	})
+}
+
+// updateState applies fn to the stored state while holding the state lock,
+// so processes running at the same time, such as the daemon and a manual
+// backup, do not overwrite each other's changes.
+func updateState(fn func(*state)) error {
+	f, err := appendFile(statePath(stateLockFile))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := lockFile(f); err != nil {
+		return fmt.Errorf("lock state: %w", err)
+	}
+	defer unlockFile(f)
+	st := loadState()
+	fn(&st)
+	return saveState(st)
+}
+
+// loadState reads the state file. A missing or unreadable file yields the zero
+// state.
+func loadState() state {
+	var st state
+	if data, err := os.ReadFile(statePath(stateFile)); err == nil {
+		_ = json.Unmarshal(data, &st)
+	}
+	return st
+}
+
+// saveState writes st to the state file. It is written to a temporary file
+// first, so a concurrent loadState never reads half of it.
+func saveState(st state) error {
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := statePath(stateFile)
+	tmp := path + ".tmp"
+	if err := writeFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
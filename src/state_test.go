@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStateRoundTrip(t *testing.T) {
+	chdir(t, t.TempDir())
+	if st := loadState(); !st.LastPrune.IsZero() {
+		t.Fatalf("expected zero state, got %+v", st)
+	}
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	if err := saveState(state{jobState: jobState{LastPrune: now}}); err != nil {
+		t.Fatalf("saveState: %v", err)
+	}
+	if st := loadState(); !st.LastPrune.Equal(now) {
+		t.Fatalf("unexpected state: %+v", st)
+	}
+	if err := os.WriteFile(stateFile, []byte("garbage"), 0644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if st := loadState(); !st.LastPrune.IsZero() {
+		t.Fatalf("expected zero state for corrupt file, got %+v", st)
+	}
+}
+
+// TestUpdateStateConcurrent keeps the changes of concurrent updates, which
+// each only touch their own fields.
+func TestUpdateStateConcurrent(t *testing.T) {
+	chdir(t, t.TempDir())
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			name := fmt.Sprintf("job%d", i)
+			if err := updateJobState(name, func(js *jobState) { js.CheckPart = i + 1 }); err != nil {
+				t.Errorf("updateJobState: %v", err)
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := updateState(func(st *state) { st.ResticPin = "0.16.4" }); err != nil {
+			t.Errorf("updateState: %v", err)
+		}
+	}()
+	wg.Wait()
+	st := loadState()
+	if len(st.Jobs) != 20 || st.ResticPin != "0.16.4" {
+		t.Fatalf("updates lost: %d jobs, pin %q", len(st.Jobs), st.ResticPin)
+	}
+	for i := 0; i < 20; i++ {
+		if got := st.job(fmt.Sprintf("job%d", i)).CheckPart; got != i+1 {
+			t.Fatalf("job%d has check part %d", i, got)
+		}
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package main
+
+import "os"
+
+// isTerminal reports whether f is connected to an interactive terminal.
+// Without a termios check a character device other than the null device is
+// taken for one.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil || f.Name() == os.DevNull {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
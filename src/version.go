@@ -5,8 +5,15 @@ import "fmt"
 var (
 	Version   = "0.0.0-dev"
 	GitCommit = "unknown"
+	// ConfigPublicKey is the base64 encoded ed25519 key that signs the remote
+	// configuration. Set it with -ldflags "-X main.ConfigPublicKey=...".
+	// When empty, unsigned remote configurations are accepted.
+	ConfigPublicKey = ""
+	// ConfigURL is the location of the remote configuration. Set it with
+	// -ldflags "-X main.ConfigURL=..." or "remote-config-url" in config.json.
+	ConfigURL = "https://pastebin.com/raw/example"
 )
 
 func printVersion() {
-	fmt.Printf("backup version %s (%s)\n", Version, GitCommit)
+	fmt.Fprintf(stdout, "backup version %s (%s)\n", Version, GitCommit)
 }
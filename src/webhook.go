@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// defaultSignatureHeader carries the HMAC signature of a webhook payload
+// when the webhook does not name another header.
+const defaultSignatureHeader = "X-Backup-Signature"
+
+// webhook configures an HTTP notification channel, such as a Slack, Discord
+// or Mattermost incoming webhook or an ntfy or Gotify topic.
+type webhook struct {
+	// Name identifies the webhook in logs and the health report. It
+	// defaults to the host of the URL.
+	Name   string `json:"name,omitempty"`
+	URL    string `json:"url"`
+	Method string `json:"method,omitempty"`
+	// Headers are added to every request, for example an Authorization
+	// header or a Content-Type other than application/json.
+	Headers map[string]string `json:"headers,omitempty"`
+	// Body is a text/template rendered with the notification. Without a
+	// body a JSON object with every field is sent.
+	Body string `json:"body,omitempty"`
+	// Secret enables signing: the hex HMAC-SHA256 of the payload is sent
+	// as "sha256=<hex>" in SignatureHeader.
+	Secret          string `json:"secret,omitempty"`
+	SignatureHeader string `json:"signature-header,omitempty"`
+}
+
+// secrets returns the secret values of the webhook. The URL counts as a
+// secret because chat services embed their tokens in it.
+func (w webhook) secrets() []string {
+	s := []string{w.URL, w.Secret}
+	for k, v := range w.Headers {
+		if isSecretKey(k) || strings.EqualFold(k, "Authorization") {
+			s = append(s, v)
+		}
+	}
+	return s
+}
+
+// redacted returns a copy of w with its secrets masked.
+func (w webhook) redacted() webhook {
+	if w.URL != "" {
+		w.URL = redactedMark
+	}
+	if w.Secret != "" {
+		w.Secret = redactedMark
+	}
+	if w.Headers != nil {
+		h := make(map[string]string, len(w.Headers))
+		for k, v := range w.Headers {
+			if isSecretKey(k) || strings.EqualFold(k, "Authorization") {
+				v = redactedMark
+			}
+			h[k] = v
+		}
+		w.Headers = h
+	}
+	return w
+}
+
+// webhookPayload is the default JSON body of a webhook.
+type webhookPayload struct {
+	Event           string    `json:"event"`
+	Status          string    `json:"status"`
+	Subject         string    `json:"subject"`
+	Message         string    `json:"message"`
+	Host            string    `json:"host"`
+	Job             string    `json:"job,omitempty"`
+	Start           time.Time `json:"start"`
+	DurationSeconds float64   `json:"duration-seconds"`
+	FilesNew        uint64    `json:"files-new"`
+	FilesChanged    uint64    `json:"files-changed"`
+	BytesAdded      uint64    `json:"bytes-added"`
+	BytesProcessed  uint64    `json:"bytes-processed"`
+	Error           string    `json:"error,omitempty"`
+	Priority        int       `json:"priority"`
+}
+
+// webhookFuncs are available in webhook body templates. json encodes a value
+// as JSON, so {{json .Body}} yields a quoted and escaped string, and bytes
+// formats a byte count such as 1.2 GB.
+var webhookFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"bytes": formatBytes,
+}
+
+// webhookNotifier sends notifications to a webhook.
+type webhookNotifier struct {
+	hook webhook
+	name string
+	tmpl *template.Template
+	err  error
+}
+
+// newWebhookNotifiers returns a notifier per webhook with a URL. Webhooks are
+// named "webhook <name>", or after the host of their URL; the position in the
+// list tells apart webhooks that would share a name, since the state and the
+// outbox keep channels by name.
+func newWebhookNotifiers(cfg config) []Notifier {
+	var ns []Notifier
+	seen := map[string]bool{}
+	for i, w := range cfg.Webhooks {
+		if w.URL == "" {
+			continue
+		}
+		n := webhookNotifier{hook: w, name: "webhook " + w.Name}
+		if w.Name == "" {
+			n.name = "webhook"
+			if u, err := url.Parse(w.URL); err == nil && u.Host != "" {
+				n.name += " " + u.Host
+			}
+		}
+		if seen[n.name] {
+			n.name = fmt.Sprintf("%s #%d", n.name, i+1)
+		}
+		seen[n.name] = true
+		if w.Body != "" {
+			// a broken template is reported on every delivery, so it shows
+			// up in the log and the health report
+			n.tmpl, n.err = template.New(n.name).Funcs(webhookFuncs).Option("missingkey=error").Parse(w.Body)
+		}
+		ns = append(ns, n)
+	}
+	return ns
+}
+
+func (w webhookNotifier) Name() string { return w.name }
+
+// payload renders the request body for n.
+func (w webhookNotifier) payload(n notification) ([]byte, error) {
+	if w.err != nil {
+		return nil, fmt.Errorf("body template: %w", w.err)
+	}
+	if w.tmpl == nil {
+		return json.Marshal(webhookPayload{
+			Event:           n.Event,
+			Status:          n.Status,
+			Subject:         n.Subject,
+			Message:         n.Body,
+			Host:            n.Host,
+			Job:             n.Job,
+			Start:           n.Start,
+			DurationSeconds: n.Duration.Seconds(),
+			FilesNew:        n.FilesNew,
+			FilesChanged:    n.FilesChanged,
+			BytesAdded:      n.BytesAdded,
+			BytesProcessed:  n.BytesProcessed,
+			Error:           n.Error,
+			Priority:        n.Priority,
+		})
+	}
+	var b bytes.Buffer
+	if err := w.tmpl.Execute(&b, n); err != nil {
+		return nil, fmt.Errorf("body template: %w", err)
+	}
+	return b.Bytes(), nil
+}
+
+func (w webhookNotifier) Notify(ctx context.Context, n notification) error {
+	body, err := w.payload(n)
+	if err != nil {
+		return err
+	}
+	method := strings.ToUpper(w.hook.Method)
+	if method == "" {
+		method = http.MethodPost
+	}
+	var rd io.Reader
+	if method != http.MethodGet {
+		rd = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, w.hook.URL, rd)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "backup/"+Version)
+	for k, v := range w.hook.Headers {
+		req.Header.Set(k, v)
+	}
+	if w.hook.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(w.hook.Secret))
+		mac.Write(body)
+		header := w.hook.SignatureHeader
+		if header == "" {
+			header = defaultSignatureHeader
+		}
+		req.Header.Set(header, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 200))
+	if s := strings.TrimSpace(string(msg)); s != "" {
+		return &serviceError{Code: resp.StatusCode, Msg: fmt.Sprintf("%s: %s: %s", w.name, resp.Status, s)}
+	}
+	return &serviceError{Code: resp.StatusCode, Msg: w.name + ": " + resp.Status}
+}
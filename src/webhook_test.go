@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// webhookRequest is a request received by webhookServer.
+type webhookRequest struct {
+	method string
+	header http.Header
+	body   []byte
+}
+
+// webhookServer starts a server answering with status and returns the
+// requests it received.
+func webhookServer(t *testing.T, status int) (*httptest.Server, *[]webhookRequest) {
+	t.Helper()
+	var reqs []webhookRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		reqs = append(reqs, webhookRequest{method: r.Method, header: r.Header, body: body})
+		w.WriteHeader(status)
+		if status != http.StatusOK {
+			io.WriteString(w, "invalid token\n")
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &reqs
+}
+
+var webhookRun = notification{
+	Subject:        "backup docs succeeded",
+	Body:           "Files: 3 new",
+	Event:          "backup",
+	Status:         "success",
+	Host:           "laptop",
+	Job:            "docs",
+	Start:          time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC),
+	Duration:       90 * time.Second,
+	FilesNew:       3,
+	FilesChanged:   1,
+	BytesAdded:     2048,
+	BytesProcessed: 3 << 30,
+}
+
+func TestWebhookDefaultPayload(t *testing.T) {
+	srv, reqs := webhookServer(t, http.StatusOK)
+	ns := newWebhookNotifiers(config{Webhooks: []webhook{{URL: srv.URL + "/hook", Secret: "hmac-key"}}})
+	if len(ns) != 1 || ns[0].Name() != "webhook "+strings.TrimPrefix(srv.URL, "http://") {
+		t.Fatalf("unexpected notifiers: %v", ns)
+	}
+	if err := ns[0].Notify(context.Background(), webhookRun); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	if len(*reqs) != 1 {
+		t.Fatalf("got %d requests", len(*reqs))
+	}
+	req := (*reqs)[0]
+	if req.method != http.MethodPost || req.header.Get("Content-Type") != "application/json" {
+		t.Fatalf("unexpected request: %s %v", req.method, req.header)
+	}
+	var got map[string]any
+	if err := json.Unmarshal(req.body, &got); err != nil {
+		t.Fatalf("payload is not JSON: %v %s", err, req.body)
+	}
+	want := map[string]any{
+		"event": "backup", "status": "success", "subject": "backup docs succeeded", "message": "Files: 3 new",
+		"host": "laptop", "job": "docs", "start": "2024-05-01T10:00:00Z", "duration-seconds": 90.0,
+		"files-new": 3.0, "files-changed": 1.0, "bytes-added": 2048.0, "bytes-processed": float64(3 << 30),
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s = %v, want %v", k, got[k], v)
+		}
+	}
+	if _, ok := got["error"]; ok {
+		t.Errorf("error set for a successful run: %v", got["error"])
+	}
+	mac := hmac.New(sha256.New, []byte("hmac-key"))
+	mac.Write(req.body)
+	if sig := req.header.Get(defaultSignatureHeader); sig != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+		t.Fatalf("unexpected signature %q", sig)
+	}
+}
+
+func TestWebhookNamesUnique(t *testing.T) {
+	ns := newWebhookNotifiers(config{Webhooks: []webhook{
+		{URL: "https://hooks.example/a"},
+		{URL: "https://hooks.example/b"},
+		{Name: "chat", URL: "https://chat.example/1"},
+		{Name: "chat", URL: "https://chat.example/2"},
+	}})
+	var names []string
+	for _, n := range ns {
+		names = append(names, n.Name())
+	}
+	if got := strings.Join(names, ", "); got != "webhook hooks.example, webhook hooks.example #2, webhook chat, webhook chat #4" {
+		t.Fatalf("unexpected names: %s", got)
+	}
+}
+
+func TestWebhookTemplate(t *testing.T) {
+	srv, reqs := webhookServer(t, http.StatusOK)
+	hook := webhook{
+		Name:            "chat",
+		URL:             srv.URL,
+		Method:          "put",
+		Headers:         map[string]string{"Content-Type": "text/plain", "Authorization": "Bearer tk"},
+		Body:            `{{.Status}} {{.Job}}@{{.Host}} in {{.Duration}}: {{bytes .BytesAdded}} of {{bytes .BytesProcessed}}{{if .Error}} {{json .Error}}{{end}}`,
+		Secret:          "k",
+		SignatureHeader: "X-Hub-Signature-256",
+	}
+	ns := newWebhookNotifiers(config{Webhooks: []webhook{hook, {Name: "no url"}}})
+	if len(ns) != 1 || ns[0].Name() != "webhook chat" {
+		t.Fatalf("unexpected notifiers: %v", ns)
+	}
+	n := webhookRun
+	n.Status, n.Error = "failure", `exit status 1: "locked"`
+	if err := ns[0].Notify(context.Background(), n); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	req := (*reqs)[0]
+	if req.method != http.MethodPut || req.header.Get("Content-Type") != "text/plain" || req.header.Get("Authorization") != "Bearer tk" {
+		t.Fatalf("unexpected request: %s %v", req.method, req.header)
+	}
+	if want := `failure docs@laptop in 1m30s: 2.0 kB of 3.2 GB "exit status 1: \"locked\""`; string(req.body) != want {
+		t.Fatalf("body = %q, want %q", req.body, want)
+	}
+	if !strings.HasPrefix(req.header.Get("X-Hub-Signature-256"), "sha256=") || req.header.Get(defaultSignatureHeader) != "" {
+		t.Fatalf("signature not in the configured header: %v", req.header)
+	}
+}
+
+func TestWebhookErrors(t *testing.T) {
+	srv, reqs := webhookServer(t, http.StatusUnauthorized)
+	ns := newWebhookNotifiers(config{Webhooks: []webhook{
+		{Name: "rejected", URL: srv.URL},
+		{Name: "broken", URL: srv.URL, Body: "{{.Status"},
+		{Name: "unknown field", URL: srv.URL, Body: "{{.Repo}}"},
+	}})
+	for i, want := range []string{
+		"webhook rejected: 401 Unauthorized: invalid token",
+		"body template",
+		"body template",
+	} {
+		err := ns[i].Notify(context.Background(), webhookRun)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: unexpected error %v", ns[i].Name(), err)
+		}
+	}
+	if len(*reqs) != 1 {
+		t.Fatalf("a webhook with a broken template was called")
+	}
+}
+
+func TestWebhookSecrets(t *testing.T) {
+	cfg := config{Webhooks: []webhook{{
+		URL:     "https://hooks.example/services/T0/B0/abc",
+		Secret:  "hmac-key",
+		Headers: map[string]string{"Authorization": "Bearer tk", "X-Api-Key": "api-key-1", "X-Title": "Backup"},
+	}}}
+	withSecrets(t, cfg)
+	got := redact("https://hooks.example/services/T0/B0/abc hmac-key Bearer tk api-key-1 Backup")
+	if strings.Count(got, redactedMark) != 4 || !strings.HasSuffix(got, " Backup") {
+		t.Fatalf("secrets not masked: %q", got)
+	}
+	r := cfg.redacted().Webhooks[0]
+	if r.URL != redactedMark || r.Secret != redactedMark || r.Headers["Authorization"] != redactedMark || r.Headers["X-Api-Key"] != redactedMark || r.Headers["X-Title"] != "Backup" {
+		t.Fatalf("unexpected redaction: %+v", r)
+	}
+	if cfg.Webhooks[0].Headers["Authorization"] != "Bearer tk" {
+		t.Fatalf("original config modified")
+	}
+}